@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/util"
+	"github.com/rivo/tview"
+)
+
+// commandNames are the commands of the command line (:):
+// * call domain.service [key=value ...]: call a service
+// * toggle name: toggle an entity or group (by name or entity id)
+// * reload: refetch all states and services
+// * w [file]: write the logs to a file
+// * q: quit
+var commandNames = []string{"call", "q", "reload", "toggle", "w"}
+
+// targetKeys are the arguments of :call that select the target.
+var targetKeys = []string{"entity_id", "device_id", "area_id"}
+
+// parseCall parses the arguments of :call, e.g.
+// light.turn_on entity_id=light.hue_go_1 brightness_pct=40:
+// * entity_id, device_id and area_id are the target (comma separated)
+// * other values are service data (numbers, booleans and JSON decoded)
+func parseCall(args []string) (homeassistant.ServiceCall, error) {
+	call := homeassistant.ServiceCall{}
+	if len(args) == 0 {
+		return call, fmt.Errorf("usage: call domain.service [key=value ...]")
+	}
+
+	var ok bool
+	call.Domain, call.Service, ok = strings.Cut(args[0], ".")
+	if !ok || call.Domain == "" || call.Service == "" {
+		return call, fmt.Errorf("invalid service [%v]", args[0])
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return call, fmt.Errorf("invalid argument [%v] (use key=value)", arg)
+		}
+		switch key {
+		case "entity_id":
+			call.Target.EntityID = append(call.Target.EntityID, strings.Split(value, ",")...)
+		case "device_id":
+			call.Target.DeviceID = append(call.Target.DeviceID, strings.Split(value, ",")...)
+		case "area_id":
+			call.Target.AreaID = append(call.Target.AreaID, strings.Split(value, ",")...)
+		default:
+			if call.Data == nil {
+				call.Data = map[string]interface{}{}
+			}
+			call.Data[key] = parseCallValue(value)
+		}
+	}
+	return call, nil
+}
+
+// codeArgument matches code=value arguments (quoted or not).
+var codeArgument = regexp.MustCompile(`(^|\s)code=("[^"]*"|\S*)`)
+
+// historyLine is a line as it is saved in the history,
+// the values of codes (e.g. of locks) are left out.
+func historyLine(line string) string {
+	return codeArgument.ReplaceAllString(line, "${1}code=")
+}
+
+// parseCallValue decodes numbers, booleans (only true and false,
+// e.g. t stays a string) and JSON.
+func parseCallValue(value string) interface{} {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+			return decoded
+		}
+	}
+	return value
+}
+
+// completionSources are the words the command line completes.
+type completionSources struct {
+	services  map[string]map[string]homeassistant.Service
+	entityIDs []string
+	names     []string // nicknames of entities and names of groups.
+}
+
+// serviceNames returns all services as domain.service.
+// Without services (not fetched yet) the domains of all
+// entities are returned with toggle, turn_on and turn_off.
+func (s completionSources) serviceNames() []string {
+	var names []string
+	if len(s.services) == 0 {
+		domains := map[string]bool{}
+		for _, entityID := range s.entityIDs {
+			domains[homeassistant.Domain(entityID)] = true
+		}
+		for domain := range domains {
+			for _, service := range []string{"toggle", "turn_on", "turn_off"} {
+				names = append(names, domain+"."+service)
+			}
+		}
+	}
+	for domain, services := range s.services {
+		for service := range services {
+			names = append(names, domain+"."+service)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeCommand completes the last word of a command line.
+// It returns the completed line and all candidates. A single
+// candidate is followed by a space (unless it is a key=).
+func completeCommand(line string, sources completionSources) (string, []string) {
+	words := strings.Split(line, " ")
+	last := len(words) - 1
+	word := words[last]
+
+	var candidates []string
+	prefix := "" // part of the word that is not completed.
+	switch {
+	case last == 0:
+		candidates = commandNames
+	case words[0] == "call" && last == 1:
+		candidates = sources.serviceNames()
+	case words[0] == "call":
+		key, value, ok := strings.Cut(word, "=")
+		if !ok {
+			for _, key := range targetKeys {
+				candidates = append(candidates, key+"=")
+			}
+			break
+		}
+		if key != "entity_id" {
+			return line, nil
+		}
+
+		// complete the last of the comma separated entity ids,
+		// only those of the service's domain (if it has entities):
+		done, current := "", value
+		if i := strings.LastIndex(value, ","); i >= 0 {
+			done, current = value[:i+1], value[i+1:]
+		}
+		domain, _, _ := strings.Cut(words[1], ".")
+		var entityIDs []string
+		for _, entityID := range sources.entityIDs {
+			if homeassistant.Domain(entityID) == domain {
+				entityIDs = append(entityIDs, entityID)
+			}
+		}
+		if len(entityIDs) == 0 {
+			entityIDs = sources.entityIDs
+		}
+		prefix, word, candidates = key+"="+done, current, entityIDs
+	case words[0] == "toggle":
+		candidates = append(append([]string(nil), sources.names...), sources.entityIDs...)
+	}
+
+	completed, matches := util.Complete(word, candidates)
+	words[last] = prefix + completed
+	line = strings.Join(words, " ")
+	if len(matches) == 1 && !strings.HasSuffix(completed, "=") {
+		line += " "
+	}
+	return line, matches
+}
+
+// findNamedNode returns the first node below root that is an
+// entity with name as nickname or entity id or a group of that
+// name. It returns nil if there is none.
+func findNamedNode(root *tview.TreeNode, name string) *tview.TreeNode {
+	var match *tview.TreeNode
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+		if match != nil {
+			return false
+		}
+		if entity := nodeEntity(node); entity != nil &&
+			(entity.NickName == name || entity.EntityID == name) {
+			match = node
+		}
+		if group := nodeGroup(node); group != nil && group.Name == name {
+			match = node
+		}
+		return true
+	})
+	return match
+}
+
+// nodeNames returns the nicknames of all entities and
+// the names of all groups below root.
+func nodeNames(root *tview.TreeNode) []string {
+	var names []string
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+		if entity := nodeEntity(node); entity != nil {
+			names = append(names, entity.NickName)
+		}
+		if group := nodeGroup(node); group != nil {
+			names = append(names, group.Name)
+		}
+		return true
+	})
+	return names
+}
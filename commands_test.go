@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/rivo/tview"
+)
+
+func TestParseCall(t *testing.T) {
+	call, err := parseCall([]string{
+		"light.turn_on",
+		"entity_id=light.a,light.b",
+		"area_id=office",
+		"brightness_pct=40",
+		"transition=1.5",
+		"flash=false",
+		"value=t",
+		"count=1",
+		"rgb_color=[255,0,0]",
+		"effect=Color Loop",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := homeassistant.ServiceCall{
+		Domain:  "light",
+		Service: "turn_on",
+		Target: homeassistant.Target{
+			EntityID: []string{"light.a", "light.b"},
+			AreaID:   []string{"office"},
+		},
+		Data: map[string]interface{}{
+			"brightness_pct": 40.0,
+			"transition":     1.5,
+			"flash":          false,
+			"value":          "t",
+			"count":          1.0,
+			"rgb_color":      []interface{}{255.0, 0.0, 0.0},
+			"effect":         "Color Loop",
+		},
+	}
+	if !reflect.DeepEqual(call, expected) {
+		t.Errorf("call should be '%+v', got '%+v'", expected, call)
+	}
+}
+
+func TestParseCallErrors(t *testing.T) {
+	tests := map[string][]string{
+		"usage: call domain.service [key=value ...]": nil,
+		"invalid service [light]":                    {"light"},
+		"invalid argument [bright] (use key=value)":  {"light.turn_on", "bright"},
+	}
+	for expected, args := range tests {
+		if _, err := parseCall(args); err == nil || err.Error() != expected {
+			t.Errorf("err should be '%v', got '%v'", expected, err)
+		}
+	}
+}
+
+func TestHistoryLine(t *testing.T) {
+	lines := map[string]string{
+		"call lock.unlock entity_id=lock.front code=1234":    "call lock.unlock entity_id=lock.front code=",
+		`call alarm_control_panel.alarm_disarm code="12 34"`: "call alarm_control_panel.alarm_disarm code=",
+		"call light.turn_on barcode=5":                       "call light.turn_on barcode=5",
+	}
+	for line, expected := range lines {
+		if got := historyLine(line); got != expected {
+			t.Errorf("history line should be '%v', got '%v'", expected, got)
+		}
+	}
+}
+
+func TestCompleteCommand(t *testing.T) {
+	sources := completionSources{
+		services: map[string]map[string]homeassistant.Service{
+			"light":  {"turn_on": {}, "turn_off": {}, "toggle": {}},
+			"switch": {"toggle": {}},
+		},
+		entityIDs: []string{"light.hue_go_1", "light.hue_play", "switch.fan"},
+		names:     []string{"fan", "upstairs"},
+	}
+	tests := []struct {
+		line     string
+		expected string
+		matches  int
+	}{
+		{"tog", "toggle ", 1},
+		{"", "", len(commandNames)},
+		{"call li", "call light.t", 3},
+		{"call sw", "call switch.toggle ", 1},
+		{"call light.turn_on en", "call light.turn_on entity_id=", 1},
+		{"call light.turn_on entity_id=", "call light.turn_on entity_id=light.hue_", 2},
+		{"call light.turn_on entity_id=light.hue_go_1,light.hue_p", "call light.turn_on entity_id=light.hue_go_1,light.hue_play ", 1},
+		{"call light.turn_on brightness=4", "call light.turn_on brightness=4", 0},
+		{"toggle up", "toggle upstairs ", 1},
+		{"toggle f", "toggle fan ", 1},
+		{"toggle switch.", "toggle switch.fan ", 1},
+		{"w lo", "w lo", 0},
+	}
+	for _, test := range tests {
+		line, matches := completeCommand(test.line, sources)
+		if line != test.expected || len(matches) != test.matches {
+			t.Errorf(
+				"'%v' should complete to '%v' (%v matches), got '%v' %v",
+				test.line,
+				test.expected,
+				test.matches,
+				line,
+				matches,
+			)
+		}
+	}
+
+	// without services, all entity domains are completed:
+	sources.services = nil
+	if line, _ := completeCommand("call sw", sources); line != "call switch.t" {
+		t.Errorf("'call sw' should complete to 'call switch.t', got '%v'", line)
+	}
+}
+
+func TestFindNamedNode(t *testing.T) {
+	root := tview.NewTreeNode(".")
+	matchNone := func(entityID string) bool { return false }
+	nickNames := map[string]string{"switch.tasmota_2": "fan"}
+	tree := newEntityTree(
+		root,
+		matchNone,
+		func(entityID string) []string { return []string{"upstairs"} },
+		func(entityID string) string { return nickNames[entityID] },
+		false,
+	)
+	tree.add("switch.tasmota_2")
+	fan := tree.nodes["switch.tasmota_2"]
+
+	if findNamedNode(root, "fan") != fan || findNamedNode(root, "switch.tasmota_2") != fan {
+		t.Errorf("fan should be found by nickname and entity id")
+	}
+	if group := nodeGroup(findNamedNode(root, "upstairs")); group == nil || group.Name != "upstairs" {
+		t.Errorf("the group upstairs should be found")
+	}
+	if findNamedNode(root, "edison") != nil {
+		t.Errorf("edison should not be found")
+	}
+	if names := nodeNames(root); !reflect.DeepEqual(names, []string{"upstairs", "fan"}) {
+		t.Errorf("names should be '[upstairs fan]', got '%v'", names)
+	}
+}
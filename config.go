@@ -0,0 +1,581 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/util"
+)
+
+// Config is the content of .bhdr.json, see the readme for all keys.
+type Config struct {
+	Scheme             string                       `json:"scheme"`
+	Server             string                       `json:"server"`
+	URL                string                       `json:"url"`
+	Token              string                       `json:"token"`
+	CAFile             string                       `json:"ca-file"`
+	CertFile           string                       `json:"cert-file"`
+	KeyFile            string                       `json:"key-file"`
+	ServerName         string                       `json:"server-name"`
+	InsecureSkipVerify bool                         `json:"insecure-skip-verify"`
+	HAEntities         []EntityConfig               `json:"ha-entities"`
+	Chordmap           map[string]map[string]string `json:"chordmap"`
+	Keymap             map[string]map[string]string `json:"keymap"`
+	Discovery          DiscoveryConfig              `json:"discovery"`
+	Layout             string                       `json:"layout"`
+	HistoryFile        string                       `json:"history-file"`
+}
+
+// layouts of the switches view:
+// * flat: configured entities as listed, discovered ones by domain
+// * areas: all entities grouped by area and device
+var layouts = []string{"flat", "areas"}
+
+// EntityConfig is an element of "ha-entities", either
+// * an entity: ID (its nickname) and EntityID or
+// * a group: Group (its name) and Entities (which may be groups)
+// Calls to Sensitive entities have to be confirmed (e.g. locks).
+type EntityConfig struct {
+	ID        string         `json:"id"`
+	EntityID  string         `json:"entity-id"`
+	Group     string         `json:"group"`
+	Entities  []EntityConfig `json:"entities"`
+	Sensitive bool           `json:"sensitive"`
+}
+
+// DiscoveryConfig is the "discovery" object. If enabled, all entities
+// of HA are shown grouped by domain, filtered by glob patterns:
+// * Include: entity ids to show (all if empty)
+// * Exclude: entity ids to hide, even if included
+type DiscoveryConfig struct {
+	Enabled bool     `json:"enabled"`
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// Matches reports whether an entity is shown by discovery.
+// Invalid patterns match nothing (validate reports them).
+func (d DiscoveryConfig) Matches(entityID string) bool {
+	included := len(d.Include) == 0
+	for _, pattern := range d.Include {
+		if match, _ := path.Match(pattern, entityID); match {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range d.Exclude {
+		if match, _ := path.Match(pattern, entityID); match {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfigProblem is a single invalid value, Path is its JSON path
+// (e.g. ha-entities[2].entity-id).
+type ConfigProblem struct {
+	Path    string
+	Problem string
+}
+
+func (p ConfigProblem) Error() string {
+	return fmt.Sprintf("%v: %v", p.Path, p.Problem)
+}
+
+// ConfigErrors lists every problem of a config file.
+type ConfigErrors []ConfigProblem
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, problem := range e {
+		lines[i] = problem.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chordVerbs are the verbs an action of the chordmap may start with.
+var chordVerbs = []string{
+	"toggle", "turn_on", "turn_off", "set", "copy", "paste",
+}
+
+// keymapActions are the named actions of each scope of the keymap,
+// those of the global scope can be bound in every scope.
+var keymapActions = map[string][]string{
+	util.GlobalScope: {
+		"quit", "focus-switches", "focus-logs", "command-line",
+	},
+	"switches": {
+		"down", "up", "top", "bottom",
+		"collapse", "expand", "collapse-all", "expand-all",
+		"toggle", "info", "refetch",
+		"search", "filter", "next-match", "previous-match",
+		"open-panel",
+	},
+	"panel": {
+		"next-control", "previous-control",
+		"decrease", "increase", "minimum", "maximum",
+		"raise", "lower", "close-panel",
+		"play-pause", "next-track", "previous-track",
+		"seek-forward", "seek-backward",
+		"open-cover", "close-cover", "stop-cover", "lock", "unlock",
+		"arm-home", "arm-away", "arm-night", "arm-vacation", "disarm",
+	},
+	"logs": {
+		"clear-logs", "write-logs",
+	},
+}
+
+// parseConfig decodes and validates a config file. The structure
+// is checked first (unknown keys, wrong types), then the values.
+// All problems are returned at once as ConfigErrors.
+func parseConfig(data []byte) (Config, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Config{}, fmt.Errorf("config file parsing error: %w", err)
+	}
+
+	var problems ConfigErrors
+	checkStructure("", raw, reflect.TypeOf(Config{}), &problems)
+	if len(problems) > 0 {
+		return Config{}, problems
+	}
+
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("config file parsing error: %w", err)
+	}
+	config.setDefaults()
+
+	if problems := config.validate(); len(problems) > 0 {
+		return Config{}, problems
+	}
+	return config, nil
+}
+
+// setDefaults fills in the optional keys:
+// * scheme and server point to a local HA (unless url is set)
+// * chordmap is the one of the template config
+// * keymap scopes are those of the template config
+// * layout is flat
+// * history-file is .bhdr_history in the home folder
+func (c *Config) setDefaults() {
+	if c.URL == "" && c.Scheme == "" {
+		c.Scheme = "ws"
+	}
+	if c.URL == "" && c.Server == "" {
+		c.Server = "127.0.0.1:8123"
+	}
+	if c.Layout == "" {
+		c.Layout = "flat"
+	}
+	if c.HistoryFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			c.HistoryFile = filepath.Join(home, ".bhdr_history")
+		}
+	}
+	if c.Chordmap == nil {
+		c.Chordmap = templateConfig().Chordmap
+	}
+	if c.Keymap == nil {
+		c.Keymap = map[string]map[string]string{}
+	}
+	for scope, bindings := range templateConfig().Keymap {
+		if _, ok := c.Keymap[scope]; !ok {
+			c.Keymap[scope] = bindings
+		}
+	}
+}
+
+// templateConfig decodes the template config.
+func templateConfig() Config {
+	var template Config
+	json.Unmarshal([]byte(bhdrJSON), &template)
+	return template
+}
+
+// validate checks the values of a structurally valid config.
+func (c Config) validate() ConfigErrors {
+	var problems ConfigErrors
+	add := func(path string, format string, a ...interface{}) {
+		problems = append(
+			problems,
+			ConfigProblem{Path: path, Problem: fmt.Sprintf(format, a...)},
+		)
+	}
+
+	if c.Token == "" {
+		add("token", "missing")
+	}
+
+	haConfig := c.HomeAssistant()
+	var configError *homeassistant.ConfigError
+	if _, err := haConfig.WebSocketURL(); errors.As(err, &configError) {
+		add(configError.Key, "%v", configError.Err)
+	}
+	if c.URL == "" {
+		switch c.Scheme {
+		case "ws", "wss":
+		default:
+			add("scheme", "unsupported scheme [%v] (use ws or wss)", c.Scheme)
+		}
+	}
+	if _, err := haConfig.TLSConfig(); errors.As(err, &configError) {
+		add(configError.Key, "%v", configError.Err)
+	}
+
+	ids := map[string]string{}
+	entityIDs := map[string]string{}
+	walkEntities(c.HAEntities, "ha-entities", nil, func(
+		entity EntityConfig,
+		path string,
+		groups []string,
+	) {
+		if entity.Group != "" {
+			if entity.ID != "" || entity.EntityID != "" {
+				add(path, "a group has no id or entity-id")
+			}
+			if len(entity.Entities) == 0 {
+				add(path+".entities", "missing")
+			}
+			if entity.Sensitive {
+				add(path+".sensitive", "only entities can be sensitive")
+			}
+			return
+		}
+		if len(entity.Entities) > 0 {
+			add(path+".group", "missing")
+			return
+		}
+
+		if entity.ID == "" {
+			add(path+".id", "missing")
+		} else if first, ok := ids[entity.ID]; ok {
+			add(path+".id", "duplicate of %v", first)
+		} else {
+			ids[entity.ID] = path + ".id"
+		}
+
+		domain, object, _ := strings.Cut(entity.EntityID, ".")
+		if entity.EntityID == "" {
+			add(path+".entity-id", "missing")
+		} else if domain == "" || object == "" {
+			add(path+".entity-id", "invalid entity id [%v]", entity.EntityID)
+		} else if first, ok := entityIDs[entity.EntityID]; ok {
+			add(path+".entity-id", "duplicate of %v", first)
+		} else {
+			entityIDs[entity.EntityID] = path + ".entity-id"
+		}
+	})
+
+	for _, nomen := range sortedKeys(c.Chordmap) {
+		path := "chordmap." + nomen
+		if len([]rune(nomen)) != 1 {
+			add(path, "nomen has to be a single key")
+		}
+		for _, verb := range sortedKeys(c.Chordmap[nomen]) {
+			path := path + "." + verb
+			if len([]rune(verb)) != 1 {
+				add(path, "verb has to be a single key")
+			}
+			action := c.Chordmap[nomen][verb]
+			name, _, _ := strings.Cut(action, ":")
+			if !contains(chordVerbs, name) {
+				add(path, "unknown action [%v]", action)
+			}
+		}
+	}
+
+	c.buildKeymap(func(path string, err error) {
+		add(path, "%v", err)
+	})
+
+	if !contains(layouts, c.Layout) {
+		add("layout", "unknown layout [%v] (use %v)", c.Layout, strings.Join(layouts, " or "))
+	}
+
+	patterns := map[string][]string{
+		"discovery.include": c.Discovery.Include,
+		"discovery.exclude": c.Discovery.Exclude,
+	}
+	for _, key := range sortedKeys(patterns) {
+		for i, pattern := range patterns[key] {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add(fmt.Sprintf("%v[%v]", key, i), "invalid pattern [%v]", pattern)
+			}
+		}
+	}
+
+	return problems
+}
+
+// buildKeymap binds the keymap of the config (global scope first),
+// bindings that cannot be made are passed to problem with their path:
+// * unknown scopes and actions
+// * invalid keys and conflicting sequences
+// * keys that start chords or counts (in the views that handle them)
+func (c Config) buildKeymap(problem func(path string, err error)) *util.Keymap {
+	keymap := util.NewKeymap()
+
+	// keys the views handle before the keymap, the switches view
+	// (and thus the global scope) starts chords and counts with
+	// them, the panel counts:
+	counts := map[rune]string{}
+	for r := '1'; r <= '9'; r++ {
+		counts[r] = "a count"
+	}
+	chords := map[rune]string{'"': "a register"}
+	for nomen := range c.Chordmap {
+		for _, r := range nomen {
+			chords[r] = "a chord"
+		}
+	}
+	for r, what := range counts {
+		chords[r] = what
+	}
+	reserved := map[string]map[rune]string{
+		util.GlobalScope: chords,
+		"switches":       chords,
+		"panel":          counts,
+	}
+
+	scopes := []string{util.GlobalScope}
+	for _, scope := range sortedKeys(c.Keymap) {
+		if scope != util.GlobalScope {
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, scope := range scopes {
+		actions, ok := keymapActions[scope]
+		if !ok {
+			problem("keymap."+scope, fmt.Errorf(
+				"unknown scope (use %v)", strings.Join(sortedKeys(keymapActions), ", "),
+			))
+			continue
+		}
+		actions = append(append([]string(nil), actions...), keymapActions[util.GlobalScope]...)
+
+		for _, keys := range sortedKeys(c.Keymap[scope]) {
+			path := "keymap." + scope + "." + keys
+			action := c.Keymap[scope][keys]
+			if !contains(actions, action) {
+				problem(path, fmt.Errorf("unknown action [%v]", action))
+				continue
+			}
+			first, _ := utf8.DecodeRuneInString(keys)
+			if what, ok := reserved[scope][first]; ok {
+				problem(path, fmt.Errorf("[%c] starts %v", first, what))
+				continue
+			}
+			if err := keymap.Bind(scope, keys, action); err != nil {
+				problem(path, err)
+			}
+		}
+	}
+	return keymap
+}
+
+// HomeAssistant returns the connection settings of the config.
+func (c Config) HomeAssistant() homeassistant.Config {
+	haConfig := homeassistant.Config{
+		Scheme:             c.Scheme,
+		Server:             c.Server,
+		URL:                c.URL,
+		Token:              c.Token,
+		CAFile:             c.CAFile,
+		CertFile:           c.CertFile,
+		KeyFile:            c.KeyFile,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	// discovery needs all entities, else only stream configured ones:
+	if !c.Discovery.Enabled {
+		walkEntities(c.HAEntities, "ha-entities", nil, func(
+			entity EntityConfig,
+			path string,
+			groups []string,
+		) {
+			if entity.EntityID != "" {
+				haConfig.EntityIDs = append(haConfig.EntityIDs, entity.EntityID)
+			}
+		})
+	}
+	return haConfig
+}
+
+// SensitiveEntities returns the entity ids of sensitive entities.
+func (c Config) SensitiveEntities() map[string]bool {
+	sensitive := map[string]bool{}
+	walkEntities(c.HAEntities, "ha-entities", nil, func(
+		entity EntityConfig,
+		path string,
+		groups []string,
+	) {
+		if entity.Sensitive && entity.EntityID != "" {
+			sensitive[entity.EntityID] = true
+		}
+	})
+	return sensitive
+}
+
+// walkEntities calls visit for all elements of "ha-entities",
+// groups are visited before their members:
+// * path: JSON path of the element
+// * groups: names of the groups the element is nested in
+func walkEntities(
+	entities []EntityConfig,
+	path string,
+	groups []string,
+	visit func(entity EntityConfig, path string, groups []string),
+) {
+	for i, entity := range entities {
+		elementPath := fmt.Sprintf("%v[%v]", path, i)
+		visit(entity, elementPath, groups)
+		if entity.Group != "" {
+			walkEntities(
+				entity.Entities,
+				elementPath+".entities",
+				append(append([]string(nil), groups...), entity.Group),
+				visit,
+			)
+		}
+	}
+}
+
+// checkStructure compares decoded JSON with the type it will be
+// decoded into (by their json tags) and reports unknown keys and
+// values of the wrong type.
+func checkStructure(
+	path string,
+	raw interface{},
+	t reflect.Type,
+	problems *ConfigErrors,
+) {
+	mismatch := func(expected string) {
+		*problems = append(*problems, ConfigProblem{
+			Path:    rootPath(path),
+			Problem: fmt.Sprintf("expected %v, got %v", expected, jsonType(raw)),
+		})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			mismatch("object")
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			fields[name] = t.Field(i).Type
+		}
+		for _, key := range sortedKeys(object) {
+			field, ok := fields[key]
+			if !ok {
+				*problems = append(*problems, ConfigProblem{
+					Path:    joinPath(path, key),
+					Problem: "unknown key",
+				})
+				continue
+			}
+			checkStructure(joinPath(path, key), object[key], field, problems)
+		}
+	case reflect.Map:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			mismatch("object")
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			checkStructure(joinPath(path, key), object[key], t.Elem(), problems)
+		}
+	case reflect.Slice:
+		array, ok := raw.([]interface{})
+		if !ok {
+			mismatch("array")
+			return
+		}
+		for i, element := range array {
+			checkStructure(fmt.Sprintf("%v[%v]", path, i), element, t.Elem(), problems)
+		}
+	case reflect.String:
+		if _, ok := raw.(string); !ok {
+			mismatch("string")
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			mismatch("boolean")
+		}
+	case reflect.Int, reflect.Float64:
+		if _, ok := raw.(float64); !ok {
+			mismatch("number")
+		}
+	}
+}
+
+// jsonType names the type of a decoded JSON value.
+func jsonType(raw interface{}) string {
+	switch raw.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", raw)
+}
+
+// joinPath appends an object key to a JSON path.
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// rootPath names the empty path of the top-level value.
+func rootPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+// sortedKeys returns the keys of a map in order (for stable messages).
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains reports whether a slice contains value.
+func contains(slice []string, value string) bool {
+	for _, element := range slice {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
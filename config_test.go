@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withToken returns the template config with a token filled in.
+func withToken() string {
+	return strings.Replace(bhdrJSON, `"token": ""`, `"token": "secret"`, 1)
+}
+
+func TestParseConfigTemplate(t *testing.T) {
+	config, err := parseConfig([]byte(withToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.HAEntities) != 3 {
+		t.Errorf("len(HAEntities) should be '3', got '%v'", len(config.HAEntities))
+	}
+	expected := EntityConfig{ID: "hue", EntityID: "light.hue_go_1"}
+	if !reflect.DeepEqual(config.HAEntities[2], expected) {
+		t.Errorf("HAEntities[2] should be '%+v', got '%+v'", expected, config.HAEntities[2])
+	}
+	if config.Chordmap["c"]["b"] != "set:brightness:#" {
+		t.Errorf("chordmap.c.b should be 'set:brightness:#', got '%v'", config.Chordmap["c"]["b"])
+	}
+
+	haConfig := config.HomeAssistant()
+	if len(haConfig.EntityIDs) != 3 || haConfig.EntityIDs[0] != "switch.tasmota_2" {
+		t.Errorf("EntityIDs should start with 'switch.tasmota_2', got '%v'", haConfig.EntityIDs)
+	}
+}
+
+func TestParseConfigDefaults(t *testing.T) {
+	config, err := parseConfig([]byte(`{"token": "secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Scheme != "ws" {
+		t.Errorf("Scheme should be 'ws', got '%v'", config.Scheme)
+	}
+	if config.Server != "127.0.0.1:8123" {
+		t.Errorf("Server should be '127.0.0.1:8123', got '%v'", config.Server)
+	}
+	if config.Chordmap["o"]["o"] != "turn_on:power" {
+		t.Errorf("chordmap.o.o should be 'turn_on:power', got '%v'", config.Chordmap["o"]["o"])
+	}
+}
+
+func TestParseConfigURLReplacesDefaults(t *testing.T) {
+	config, err := parseConfig(
+		[]byte(`{"token": "secret", "url": "https://ha.example.com"}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Scheme != "" || config.Server != "" {
+		t.Errorf("scheme and server should be empty, got '%v' '%v'", config.Scheme, config.Server)
+	}
+}
+
+func TestParseConfigReportsAllProblems(t *testing.T) {
+	_, err := parseConfig([]byte(`{
+		"token": "secret",
+		"sever": "127.0.0.1:8123",
+		"insecure-skip-verify": "yes",
+		"ha-entities": [
+			{"id": "fan", "entity-id": "switch.fan"},
+			{"id": "lamp", "entity_id": "light.lamp"},
+			{"id": 3}
+		],
+		"chordmap": {"c": {"c": ["toggle:power"]}}
+	}`))
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("err should be 'ConfigErrors', got '%v'", err)
+	}
+
+	expected := []string{
+		"chordmap.c.c: expected string, got array",
+		"ha-entities[1].entity_id: unknown key",
+		"ha-entities[2].id: expected string, got number",
+		"insecure-skip-verify: expected boolean, got string",
+		"sever: unknown key",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("err should be '%v', got '%v'", strings.Join(expected, "\n"), err)
+	}
+}
+
+func TestParseConfigValidatesValues(t *testing.T) {
+	_, err := parseConfig([]byte(`{
+		"scheme": "http",
+		"ha-entities": [
+			{"id": "fan", "entity-id": "switch.fan"},
+			{"id": "fan", "entity-id": "fan"},
+			{"id": ""}
+		],
+		"chordmap": {"cc": {"c": "toggle:power", "x": "explode"}},
+		"discovery": {"enabled": true, "exclude": ["sensor.*", "[a-"]}
+	}`))
+
+	expected := []string{
+		"token: missing",
+		"scheme: unsupported scheme [http] (use ws or wss)",
+		"ha-entities[1].id: duplicate of ha-entities[0].id",
+		"ha-entities[1].entity-id: invalid entity id [fan]",
+		"ha-entities[2].id: missing",
+		"ha-entities[2].entity-id: missing",
+		"chordmap.cc: nomen has to be a single key",
+		"chordmap.cc.x: unknown action [explode]",
+		"discovery.exclude[1]: invalid pattern [[a-]",
+	}
+	if err == nil || err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("err should be '%v', got '%v'", strings.Join(expected, "\n"), err)
+	}
+}
+
+func TestParseConfigSyntaxError(t *testing.T) {
+	_, err := parseConfig([]byte(`{"token": }`))
+	if err == nil || !strings.HasPrefix(err.Error(), "config file parsing error") {
+		t.Errorf("err should be a parsing error, got '%v'", err)
+	}
+}
+
+func TestParseConfigDiscoveryStreamsAllEntities(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+		"token": "secret",
+		"ha-entities": [{"id": "fan", "entity-id": "switch.fan"}],
+		"discovery": {"enabled": true}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := config.HomeAssistant().EntityIDs; len(ids) != 0 {
+		t.Errorf("EntityIDs should be empty, got '%v'", ids)
+	}
+}
+
+func TestParseConfigNestedGroups(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+		"token": "secret",
+		"ha-entities": [
+			{"id": "fan", "entity-id": "switch.fan"},
+			{"group": "upstairs", "entities": [
+				{"group": "office", "entities": [
+					{"id": "desk", "entity-id": "light.desk"}
+				]}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := config.HomeAssistant().EntityIDs
+	if !reflect.DeepEqual(ids, []string{"switch.fan", "light.desk"}) {
+		t.Errorf("EntityIDs should be '[switch.fan light.desk]', got '%v'", ids)
+	}
+
+	var paths []string
+	walkEntities(config.HAEntities, "ha-entities", nil, func(
+		entity EntityConfig,
+		path string,
+		groups []string,
+	) {
+		paths = append(paths, path+" "+strings.Join(groups, "/"))
+	})
+	expected := []string{
+		"ha-entities[0] ",
+		"ha-entities[1] ",
+		"ha-entities[1].entities[0] upstairs",
+		"ha-entities[1].entities[0].entities[0] upstairs/office",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("paths should be '%v', got '%v'", expected, paths)
+	}
+}
+
+func TestParseConfigValidatesGroups(t *testing.T) {
+	_, err := parseConfig([]byte(`{
+		"token": "secret",
+		"ha-entities": [
+			{"id": "fan", "entity-id": "switch.fan"},
+			{"group": "upstairs", "entities": [
+				{"group": "office", "id": "office", "entities": [
+					{"id": "fan2", "entity-id": "switch.fan"},
+					{"entity-id": "light.desk"}
+				]},
+				{"group": "empty"},
+				{"entities": [{"id": "lamp", "entity-id": "light.lamp"}]}
+			]}
+		]
+	}`))
+
+	expected := []string{
+		"ha-entities[1].entities[0]: a group has no id or entity-id",
+		"ha-entities[1].entities[0].entities[0].entity-id: duplicate of ha-entities[0].entity-id",
+		"ha-entities[1].entities[0].entities[1].id: missing",
+		"ha-entities[1].entities[1].entities: missing",
+		"ha-entities[1].entities[2].group: missing",
+	}
+	if err == nil || err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("err should be '%v', got '%v'", strings.Join(expected, "\n"), err)
+	}
+}
+
+func TestParseConfigSensitive(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+		"token": "secret",
+		"ha-entities": [
+			{"id": "fan", "entity-id": "switch.fan"},
+			{"group": "doors", "entities": [
+				{"id": "front", "entity-id": "lock.front_door", "sensitive": true}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sensitive := config.SensitiveEntities()
+	if !reflect.DeepEqual(sensitive, map[string]bool{"lock.front_door": true}) {
+		t.Errorf("sensitive entities should be 'lock.front_door', got '%v'", sensitive)
+	}
+
+	_, err = parseConfig([]byte(`{
+		"token": "secret",
+		"ha-entities": [
+			{"group": "doors", "sensitive": true, "entities": [
+				{"id": "front", "entity-id": "lock.front_door"}
+			]}
+		]
+	}`))
+	expected := "ha-entities[0].sensitive: only entities can be sensitive"
+	if err == nil || err.Error() != expected {
+		t.Errorf("err should be '%v', got '%v'", expected, err)
+	}
+}
+
+func TestParseConfigKeymap(t *testing.T) {
+	config, err := parseConfig([]byte(`{
+		"token": "secret",
+		"keymap": {"switches": {"gt": "toggle", "<ctrl-t>": "toggle", "Q": "quit"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// missing scopes are those of the template:
+	if config.Keymap["global"]["q"] != "quit" {
+		t.Errorf("keymap.global.q should be 'quit', got '%v'", config.Keymap["global"]["q"])
+	}
+	if _, ok := config.Keymap["switches"]["j"]; ok {
+		t.Errorf("keymap.switches should not be merged with the template")
+	}
+	if config.Keymap["logs"]["d"] != "clear-logs" {
+		t.Errorf("keymap.logs.d should be 'clear-logs', got '%v'", config.Keymap["logs"]["d"])
+	}
+}
+
+func TestParseConfigValidatesKeymap(t *testing.T) {
+	_, err := parseConfig([]byte(`{
+		"token": "secret",
+		"keymap": {
+			"global": {"q": "quit", "gq": "quit"},
+			"switches": {
+				"g": "top",
+				"z": "top",
+				"zz": "bottom",
+				"x": "explode",
+				"d": "toggle",
+				"5": "down",
+				"<ctrl-1>": "up",
+				"<foo": "up",
+				"<space>": "toggle",
+				" ": "info"
+			},
+			"logs": {"d": "clear-logs", "i": "info"},
+			"help": {"?": "help"}
+		}
+	}`))
+
+	expected := []string{
+		"keymap.help: unknown scope (use global, logs, panel, switches)",
+		"keymap.logs.i: unknown action [info]",
+		"keymap.switches.5: [5] starts a count",
+		"keymap.switches.<ctrl-1>: invalid key [<ctrl-1>] (ctrl only works with letters)",
+		"keymap.switches.<foo: unterminated key [<foo]",
+		"keymap.switches.<space>: [<space>] is already bound to info",
+		"keymap.switches.d: [d] starts a chord",
+		"keymap.switches.g: [g] conflicts with [gq] of global",
+		"keymap.switches.x: unknown action [explode]",
+		"keymap.switches.zz: [zz] conflicts with [z] of switches",
+	}
+	if err == nil || err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("err should be '%v', got '%v'", strings.Join(expected, "\n"), err)
+	}
+}
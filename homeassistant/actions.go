@@ -0,0 +1,138 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServiceCall holds everything needed for Client.Call. NeedsCode
+// tells that the user has to type a code first (e.g. to disarm an
+// alarm), it is sent as the data code.
+type ServiceCall struct {
+	Domain    string
+	Service   string
+	Target    Target
+	Data      map[string]interface{}
+	NeedsCode bool
+}
+
+// ParseAction turns a chordmap action into a service call for the
+// entity the state belongs to. Actions have the form verb:attribute:value:
+// * toggle:power, turn_on:power, turn_off:power (any entity)
+// * set:brightness:#, set:hue:#, set:saturation:#, set:effect:# (lights)
+// Brightness, hue and saturation are given in percent, the hue as
+// percent of the color wheel. An effect is selected by its index in
+// the light's effect_list. Values are taken as they are, the chord
+// engine replaces a # with the number typed after the chord.
+func ParseAction(action string, state State) (ServiceCall, error) {
+	call := ServiceCall{
+		Domain: Domain(state.EntityID),
+		Target: Target{EntityID: []string{state.EntityID}},
+		Data:   map[string]interface{}{},
+	}
+	if state.EntityID == "" {
+		return call, fmt.Errorf("no entity selected")
+	}
+
+	parts := strings.SplitN(action, ":", 3)
+	verb := parts[0]
+
+	switch verb {
+	case "toggle", "turn_on", "turn_off":
+		call.Service = verb
+		return call, nil
+	case "set":
+		if len(parts) != 3 {
+			return call, fmt.Errorf("invalid action [%v]", action)
+		}
+	default:
+		return call, fmt.Errorf("unknown verb [%v]", verb)
+	}
+
+	if call.Domain != "light" {
+		return call, fmt.Errorf("%v is only supported for lights", action)
+	}
+	call.Service = "turn_on"
+
+	attribute, value := parts[1], parts[2]
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return call, fmt.Errorf("invalid value [%v]", value)
+	}
+
+	switch attribute {
+	case "brightness":
+		call.Data["brightness_pct"] = clamp(number, 0, 100)
+	case "hue":
+		_, saturation := hsColor(state, 100)
+		call.Data["hs_color"] = []float64{clamp(number, 0, 100) * 3.6, saturation}
+	case "saturation":
+		hue, _ := hsColor(state, 100)
+		call.Data["hs_color"] = []float64{hue, clamp(number, 0, 100)}
+	case "effect":
+		effects, _ := state.Attributes["effect_list"].([]interface{})
+		index := int(number)
+		if index >= len(effects) {
+			return call, fmt.Errorf("%v has no effect number %v", state.EntityID, index)
+		}
+		call.Data["effect"] = effects[index]
+	default:
+		return call, fmt.Errorf("unknown attribute [%v]", attribute)
+	}
+	return call, nil
+}
+
+// DescribeAction explains a chordmap action in words (e.g. for a
+// help popup), a # stands for the number typed after the chord.
+func DescribeAction(action string) string {
+	parts := strings.SplitN(action, ":", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	verb, attribute, value := parts[0], parts[1], parts[2]
+	name := strings.ReplaceAll(attribute, "_", " ")
+
+	switch verb {
+	case "toggle", "turn_on", "turn_off":
+		return strings.ReplaceAll(verb, "_", " ")
+	case "set":
+		switch {
+		case attribute == "effect" && value == "#":
+			return "select effect by number"
+		case attribute == "effect":
+			return "select effect " + value
+		case value == "#":
+			return "set " + name + " to a number (percent)"
+		}
+		return fmt.Sprintf("set %v to %v%%", name, value)
+	case "copy", "paste":
+		if attribute == "all" {
+			name = "all attributes"
+		}
+		return verb + " " + name
+	}
+	return action
+}
+
+// hsColor returns the current hue and saturation of a light.
+// Lights that do not report a color fall back to fallback saturation.
+func hsColor(state State, fallback float64) (float64, float64) {
+	color, _ := state.Attributes["hs_color"].([]interface{})
+	if len(color) != 2 {
+		return 0, fallback
+	}
+	hue, _ := color[0].(float64)
+	saturation, _ := color[1].(float64)
+	return hue, saturation
+}
+
+func clamp(value, min, max float64) float64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func testLight() State {
+	var state State
+	json.Unmarshal([]byte(`{
+		"entity_id": "light.hue_go_1",
+		"state": "on",
+		"attributes": {
+			"hs_color": [30.0, 80.0],
+			"effect_list": ["none", "colorloop"]
+		}
+	}`), &state)
+	return state
+}
+
+func TestParseAction(t *testing.T) {
+	actions := map[string]string{
+		"toggle:power":       `toggle {}`,
+		"turn_off:power":     `turn_off {}`,
+		"set:brightness:50":  `turn_on {"brightness_pct":50}`,
+		"set:brightness:5":   `turn_on {"brightness_pct":5}`,
+		"set:brightness:0":   `turn_on {"brightness_pct":0}`,
+		"set:brightness:250": `turn_on {"brightness_pct":100}`,
+		"set:hue:100":        `turn_on {"hs_color":[360,80]}`,
+		"set:hue:50":         `turn_on {"hs_color":[180,80]}`,
+		"set:saturation:20":  `turn_on {"hs_color":[30,20]}`,
+		"set:effect:1":       `turn_on {"effect":"colorloop"}`,
+		"set:effect:0":       `turn_on {"effect":"none"}`,
+	}
+
+	for action, expected := range actions {
+		call, err := ParseAction(action, testLight())
+		if err != nil {
+			t.Errorf("%v: got unexpected error: '%v'", action, err)
+			continue
+		}
+
+		data, _ := json.Marshal(call.Data)
+		got := fmt.Sprintf("%v %s", call.Service, data)
+		if got != expected {
+			t.Errorf("%v: call should be '%v', got '%v'", action, expected, got)
+		}
+		if call.Domain != "light" || call.Target.EntityID[0] != "light.hue_go_1" {
+			t.Errorf("%v: wrong target %v.%v", action, call.Domain, call.Target)
+		}
+	}
+}
+
+func TestParseActionErrors(t *testing.T) {
+	light := testLight()
+	fan := State{EntityID: "switch.tasmota_2"}
+
+	errors := []struct {
+		action string
+		state  State
+		err    string
+	}{
+		{"toggle:power", State{}, "no entity selected"},
+		{"copy:all", light, "unknown verb [copy]"},
+		{"set:brightness", light, "invalid action [set:brightness]"},
+		{"set:brightness:50", fan, "set:brightness:50 is only supported for lights"},
+		{"set:brightness:x", light, "invalid value [x]"},
+		{"set:brightness:#5", light, "invalid value [#5]"},
+		{"set:brightness:-5", light, "invalid value [-5]"},
+		{"set:color:50", light, "unknown attribute [color]"},
+		{"set:effect:7", light, "light.hue_go_1 has no effect number 7"},
+	}
+
+	for _, e := range errors {
+		_, err := ParseAction(e.action, e.state)
+		if err == nil || err.Error() != e.err {
+			t.Errorf("%v: expected error: '%v', got '%v'", e.action, e.err, err)
+		}
+	}
+}
+
+func TestDescribeAction(t *testing.T) {
+	descriptions := map[string]string{
+		"toggle:power":       "toggle",
+		"turn_off:power":     "turn off",
+		"set:brightness:#":   "set brightness to a number (percent)",
+		"set:saturation:100": "set saturation to 100%",
+		"set:effect:#":       "select effect by number",
+		"set:effect:1":       "select effect 1",
+		"copy:all":           "copy all attributes",
+		"paste:color_temp":   "paste color temp",
+		"explode":            "explode",
+	}
+	for action, expected := range descriptions {
+		if description := DescribeAction(action); description != expected {
+			t.Errorf("%v: description should be '%v', got '%v'", action, expected, description)
+		}
+	}
+}
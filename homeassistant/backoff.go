@@ -0,0 +1,43 @@
+package homeassistant
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff calculates exponentially growing delays between
+// reconnection attempts. Each delay is randomized by up to
+// Jitter (0.0 - 1.0) so clients do not retry in lockstep.
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+	attempt int
+	random  *rand.Rand
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	if b.random == nil {
+		b.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	delay := float64(b.Min)
+	for i := 0; i < b.attempt && delay < float64(b.Max); i++ {
+		delay *= b.Factor
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	b.attempt++
+
+	// spread the delay by ±Jitter:
+	delay += delay * b.Jitter * (2*b.random.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// Reset starts over with the minimal delay.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
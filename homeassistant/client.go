@@ -0,0 +1,467 @@
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PingInterval is the time between two keepalive pings.
+const PingInterval time.Duration = 20 * time.Second
+
+// PongTimeout is the time after which a silent connection is considered dead.
+const PongTimeout time.Duration = 2 * PingInterval
+
+// WriteTimeout is the time a single message may take to be sent.
+const WriteTimeout time.Duration = 10 * time.Second
+
+// EventBuffer is the number of messages Events holds for a slow
+// reader, once it is full further messages are dropped.
+const EventBuffer = 256
+
+// reconnectBackoff is the delay policy between reconnection attempts:
+var reconnectBackoff = Backoff{
+	Min:    time.Second,
+	Max:    time.Minute,
+	Factor: 2,
+	Jitter: 0.2,
+}
+
+// Client is a supervised connection to Home Assistant.
+// Requests are tagged with message ids and matched with their results.
+type Client struct {
+	config        Config
+	store         *Store
+	events        chan string
+	states        chan ConnectionState
+	mutex         sync.Mutex
+	connection    *websocket.Conn // nil while offline.
+	messageID     uint            // keeps increasing across sessions.
+	pending       map[uint]pendingRequest
+	subscriptions map[uint]func([]byte)
+}
+
+// pendingRequest waits for its result:
+//   - response: receives the result message
+//   - handle: optional, run by the reader before any later message
+//   - subscription: optional, run by the reader for every event
+//     with the request's id (for as long as the connection lasts)
+type pendingRequest struct {
+	response     chan Message
+	handle       func(Message)
+	subscription func([]byte)
+}
+
+// NewClient creates a Client, call Run to connect it.
+func NewClient(config Config) *Client {
+	return &Client{
+		config:        config,
+		messageID:     1,
+		store:         NewStore(),
+		events:        make(chan string, EventBuffer),
+		states:        make(chan ConnectionState),
+		pending:       map[uint]pendingRequest{},
+		subscriptions: map[uint]func([]byte){},
+	}
+}
+
+// Store holds the states of all entities, it is kept up to date
+// by state_changed events and get_states results.
+func (c *Client) Store() *Store {
+	return c.store
+}
+
+// Events publishes every message received from HA (pretty printed JSON).
+// Messages are dropped while EventBuffer of them wait to be read.
+func (c *Client) Events() <-chan string {
+	return c.events
+}
+
+// States publishes changes of the connection state.
+func (c *Client) States() <-chan ConnectionState {
+	return c.states
+}
+
+// Run connects to Home Assistant and keeps the connection alive.
+// Broken connections are reestablished with exponential backoff.
+// After every reconnect the event subscription is renewed and all
+// states are fetched again. Run only returns if retrying would
+// not help, that is with an *AuthError, *VersionError or *ConfigError.
+func (c *Client) Run() error {
+	backoff := reconnectBackoff
+
+	for {
+		c.states <- ConnectionState{Status: Connecting}
+
+		connection, version, err := Dial(c.config)
+		if err == nil {
+			backoff.Reset()
+			c.states <- ConnectionState{Status: Online, Version: version}
+			err = c.session(connection)
+		}
+
+		var authError *AuthError
+		var versionError *VersionError
+		var configError *ConfigError
+		if errors.As(err, &authError) ||
+			errors.As(err, &versionError) ||
+			errors.As(err, &configError) {
+			c.states <- ConnectionState{Status: Failed, Err: err}
+			return err
+		}
+
+		delay := backoff.Next()
+		c.states <- ConnectionState{Status: Retrying, Retry: delay, Err: err}
+		time.Sleep(delay)
+	}
+}
+
+// Request sends a command (the id is added automatically) and
+// waits for its result. It returns a *ResultError if HA answered
+// with success:false and the context's error if it expired first.
+func (c *Client) Request(
+	ctx context.Context,
+	command map[string]interface{},
+) (Message, error) {
+	return c.request(ctx, command, nil, nil)
+}
+
+// request is Request with optional handlers that run on the reader:
+// * handle: receives the result before any later message is read
+// * subscription: receives the raw events of a subscription
+func (c *Client) request(
+	ctx context.Context,
+	command map[string]interface{},
+	handle func(Message),
+	subscription func([]byte),
+) (Message, error) {
+	response := make(chan Message, 1)
+	id, err := c.write(command, &pendingRequest{response, handle, subscription})
+	if err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.mutex.Lock()
+		delete(c.pending, id)
+		c.mutex.Unlock()
+		return Message{}, ctx.Err()
+	case message, ok := <-response:
+		if !ok {
+			return Message{}, ErrDisconnected
+		}
+		if !message.Success {
+			if message.Error == nil {
+				return message, &ResultError{Code: "unknown_error"}
+			}
+			return message, message.Error
+		}
+		return message, nil
+	}
+}
+
+// Call calls a service, e.g. light.turn_on:
+// * target: the entities, devices or areas to act upon
+// * data: the service data, e.g. {"brightness_pct": 50}
+func (c *Client) Call(
+	ctx context.Context,
+	domain string,
+	service string,
+	target Target,
+	data map[string]interface{},
+) (Result, error) {
+	command := map[string]interface{}{
+		"type":    "call_service",
+		"domain":  domain,
+		"service": service,
+	}
+	if !target.IsEmpty() {
+		command["target"] = target
+	}
+	if len(data) > 0 {
+		command["service_data"] = data
+	}
+
+	var result Result
+	message, err := c.Request(ctx, command)
+	if err != nil {
+		return result, err
+	}
+	if len(message.Result) > 0 {
+		err = json.Unmarshal(message.Result, &result)
+	}
+	return result, err
+}
+
+// GetStates fetches the states of the streamed entities (the
+// configured ones or all) and replaces the content of the store
+// with them. Others would never be updated.
+func (c *Client) GetStates(ctx context.Context) ([]State, error) {
+	var states []State
+	var err error
+
+	// replace the store on the reader, so later events are not lost:
+	_, requestErr := c.request(
+		ctx,
+		map[string]interface{}{"type": "get_states"},
+		func(message Message) {
+			if !message.Success {
+				return
+			}
+			if err = json.Unmarshal(message.Result, &states); err == nil {
+				states = c.streamed(states)
+				c.store.Replace(states)
+			}
+		},
+		nil,
+	)
+	if requestErr != nil {
+		return nil, requestErr
+	}
+	return states, err
+}
+
+// streamed returns the states of the entities that are streamed.
+func (c *Client) streamed(states []State) []State {
+	if len(c.config.EntityIDs) == 0 {
+		return states
+	}
+	configured := map[string]bool{}
+	for _, entityID := range c.config.EntityIDs {
+		configured[entityID] = true
+	}
+	var kept []State
+	for _, state := range states {
+		if configured[state.EntityID] {
+			kept = append(kept, state)
+		}
+	}
+	return kept
+}
+
+// GetServices fetches all services, by domain and service name.
+func (c *Client) GetServices(ctx context.Context) (map[string]map[string]Service, error) {
+	services := map[string]map[string]Service{}
+	message, err := c.Request(ctx, map[string]interface{}{"type": "get_services"})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(message.Result, &services)
+	return services, err
+}
+
+// session subscribes to events, fetches all states and then relays
+// messages until the connection breaks down. It always returns an error.
+func (c *Client) session(connection *websocket.Conn) error {
+	c.mutex.Lock()
+	c.connection = connection
+	c.mutex.Unlock()
+	defer c.disconnect()
+
+	// listen for messages from HA:
+	readErrors := make(chan error, 1)
+	go func() {
+		readErrors <- c.read(connection)
+	}()
+
+	// subscribe to the entities, this also (re)fetches their states.
+	// Without a subscription the session is useless, so it ends:
+	subscribeErrors := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), PongTimeout)
+		defer cancel()
+
+		err := c.subscribeEntities(ctx)
+		var resultError *ResultError
+		if errors.As(err, &resultError) {
+			// servers without subscribe_entities, fall back to all events:
+			_, err = c.write(map[string]interface{}{"type": "subscribe_events"}, nil)
+			if err == nil {
+				_, err = c.GetStates(ctx)
+			}
+		}
+		subscribeErrors <- err
+	}()
+
+	// ping regularly, so dead connections are detected by the read deadline:
+	pings := time.NewTicker(PingInterval)
+	defer pings.Stop()
+
+	for {
+		select {
+		case err := <-readErrors:
+			return &ConnectionError{Err: err}
+		case err := <-subscribeErrors:
+			if err != nil {
+				return fmt.Errorf("subscribing failed: %w", err)
+			}
+		case <-pings.C:
+			if _, err := c.write(map[string]interface{}{"type": "ping"}, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscribeEntities subscribes to the compressed state stream of the
+// configured entities (or all of them) and applies it to the store.
+func (c *Client) subscribeEntities(ctx context.Context) error {
+	command := map[string]interface{}{"type": "subscribe_entities"}
+	if len(c.config.EntityIDs) > 0 {
+		command["entity_ids"] = c.config.EntityIDs
+	}
+
+	first := true
+	subscription := func(raw []byte) {
+		var message struct {
+			Event EntitiesEvent `json:"event"`
+		}
+		if json.Unmarshal(raw, &message) == nil {
+			message.Event.ApplyTo(c.store, first)
+			first = false
+		}
+	}
+
+	_, err := c.request(ctx, command, nil, subscription)
+	return err
+}
+
+// write sends a command with a fresh message id. If pending is not nil
+// the matching result will be delivered to it.
+func (c *Client) write(
+	command map[string]interface{},
+	pending *pendingRequest,
+) (uint, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.connection == nil {
+		return 0, ErrNotConnected
+	}
+
+	// copy the command, so the caller's map is left untouched:
+	haCommand := map[string]interface{}{"id": c.messageID}
+	for key, value := range command {
+		if key != "id" {
+			haCommand[key] = value
+		}
+	}
+	id := c.messageID
+	c.messageID++
+
+	if pending != nil {
+		c.pending[id] = *pending
+		if pending.subscription != nil {
+			c.subscriptions[id] = pending.subscription
+		}
+	}
+
+	c.connection.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if err := c.connection.WriteJSON(haCommand); err != nil {
+		delete(c.pending, id)
+		delete(c.subscriptions, id)
+		return id, &ConnectionError{Err: err}
+	}
+	return id, nil
+}
+
+// read dispatches incoming messages until the connection fails
+// (or is replaced). Publishing on the events channel does not wait,
+// messages are dropped while it is full. Messages are handled by type:
+// * results: delivered to their pending request
+// * events: handed to their subscription
+// * state changes: applied to the store
+// * answers to our pings: swallowed
+// * everything but pongs: published on the events channel
+func (c *Client) read(connection *websocket.Conn) error {
+	for {
+		connection.SetReadDeadline(time.Now().Add(PongTimeout))
+		_, raw, err := connection.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		message := Message{}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			continue // not a message we understand.
+		}
+		if message.Type == "pong" {
+			continue
+		}
+
+		// a closed connection may still be drained while the next
+		// session runs, its messages must not reach the new one:
+		c.mutex.Lock()
+		current := c.connection == connection
+		c.mutex.Unlock()
+		if !current {
+			return ErrNotConnected
+		}
+
+		if message.Event.Type == "state_changed" {
+			data := message.Event.Data
+			if data.NewState.EntityID == "" {
+				c.store.Remove(data.EntityID)
+			} else {
+				c.store.Set(data.NewState)
+			}
+		}
+
+		if message.Type == "event" {
+			c.mutex.Lock()
+			subscription := c.subscriptions[message.ID]
+			c.mutex.Unlock()
+
+			if subscription != nil {
+				subscription(raw)
+			}
+		}
+
+		if message.Type == "result" {
+			c.mutex.Lock()
+			pending, ok := c.pending[message.ID]
+			delete(c.pending, message.ID)
+			if ok && !message.Success {
+				delete(c.subscriptions, message.ID)
+			}
+			c.mutex.Unlock()
+
+			if ok {
+				if pending.handle != nil {
+					pending.handle(message)
+				}
+				pending.response <- message
+			}
+		}
+
+		var pretty bytes.Buffer
+		json.Indent(&pretty, raw, "", "  ")
+		select {
+		case c.events <- pretty.String():
+		default: // the reader is too slow, results and pings must not wait.
+		}
+	}
+}
+
+// disconnect closes the connection and fails all pending requests.
+func (c *Client) disconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.connection.Close()
+	c.connection = nil
+	for id, pending := range c.pending {
+		close(pending.response)
+		delete(c.pending, id)
+	}
+	for id := range c.subscriptions {
+		delete(c.subscriptions, id)
+	}
+}
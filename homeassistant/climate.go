@@ -0,0 +1,87 @@
+package homeassistant
+
+// default setpoint range and step of thermostats that do not report them:
+const (
+	defaultMinTemp  = 7
+	defaultMaxTemp  = 35
+	defaultTempStep = 0.5
+)
+
+// Climate holds what a climate control panel shows of a thermostat:
+// * Current: the measured temperature (HasCurrent if there is one)
+// * Target: the setpoint, or TargetLow and TargetHigh for a range
+// * MinTemp, MaxTemp and Step limit the setpoints
+// * HVACMode (the state), Preset and FanMode with their options
+type Climate struct {
+	Current    float64
+	HasCurrent bool
+	Target     float64
+	TargetLow  float64
+	TargetHigh float64
+	HasRange   bool
+	MinTemp    float64
+	MaxTemp    float64
+	Step       float64
+	HVACMode   string
+	HVACModes  []string
+	HVACAction string
+	Preset     string
+	Presets    []string
+	FanMode    string
+	FanModes   []string
+}
+
+// NewClimate reads the attributes of a climate entity. A thermostat
+// has a range if it reports target_temp_low and target_temp_high
+// but no temperature (e.g. in heat_cool mode).
+func NewClimate(state State) Climate {
+	attributes := state.Attributes
+	climate := Climate{
+		HVACMode: state.State,
+		MinTemp:  defaultMinTemp,
+		MaxTemp:  defaultMaxTemp,
+		Step:     defaultTempStep,
+	}
+	climate.Current, climate.HasCurrent = attributes["current_temperature"].(float64)
+	if min, ok := attributes["min_temp"].(float64); ok {
+		climate.MinTemp = min
+	}
+	if max, ok := attributes["max_temp"].(float64); ok {
+		climate.MaxTemp = max
+	}
+	if step, ok := attributes["target_temp_step"].(float64); ok && step > 0 {
+		climate.Step = step
+	}
+
+	target, hasTarget := attributes["temperature"].(float64)
+	low, hasLow := attributes["target_temp_low"].(float64)
+	high, hasHigh := attributes["target_temp_high"].(float64)
+	switch {
+	case hasTarget:
+		climate.Target = target
+	case hasLow && hasHigh:
+		climate.TargetLow, climate.TargetHigh, climate.HasRange = low, high, true
+	default:
+		climate.Target = climate.MinTemp
+	}
+
+	climate.HVACModes = stringList(attributes["hvac_modes"])
+	climate.HVACAction, _ = attributes["hvac_action"].(string)
+	climate.Preset, _ = attributes["preset_mode"].(string)
+	climate.Presets = stringList(attributes["preset_modes"])
+	climate.FanMode, _ = attributes["fan_mode"].(string)
+	climate.FanModes = stringList(attributes["fan_modes"])
+	return climate
+}
+
+// stringList returns the strings of a list attribute.
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+	var strings []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			strings = append(strings, s)
+		}
+	}
+	return strings
+}
@@ -0,0 +1,65 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewClimate(t *testing.T) {
+	var state State
+	json.Unmarshal([]byte(`{
+		"entity_id": "climate.office",
+		"state": "heat",
+		"attributes": {
+			"current_temperature": 19.5,
+			"temperature": 21,
+			"min_temp": 5,
+			"max_temp": 30,
+			"target_temp_step": 1,
+			"hvac_modes": ["off", "heat"],
+			"hvac_action": "heating",
+			"preset_mode": "eco",
+			"preset_modes": ["eco", "comfort"],
+			"fan_mode": "auto",
+			"fan_modes": ["auto", "low"]
+		}
+	}`), &state)
+
+	expected := Climate{
+		Current:    19.5,
+		HasCurrent: true,
+		Target:     21,
+		MinTemp:    5,
+		MaxTemp:    30,
+		Step:       1,
+		HVACMode:   "heat",
+		HVACModes:  []string{"off", "heat"},
+		HVACAction: "heating",
+		Preset:     "eco",
+		Presets:    []string{"eco", "comfort"},
+		FanMode:    "auto",
+		FanModes:   []string{"auto", "low"},
+	}
+	if got := NewClimate(state); !reflect.DeepEqual(got, expected) {
+		t.Errorf("climate should be '%+v', got '%+v'", expected, got)
+	}
+}
+
+func TestNewClimateRange(t *testing.T) {
+	state := State{
+		EntityID: "climate.office",
+		State:    "heat_cool",
+		Attributes: map[string]interface{}{
+			"target_temp_low":  20.0,
+			"target_temp_high": 24.0,
+		},
+	}
+	climate := NewClimate(state)
+	if !climate.HasRange || climate.TargetLow != 20 || climate.TargetHigh != 24 {
+		t.Errorf("climate should have the range 20-24, got '%+v'", climate)
+	}
+	if climate.HasCurrent || climate.Step != 0.5 || climate.MinTemp != 7 || climate.MaxTemp != 35 {
+		t.Errorf("climate should fall back to the defaults, got '%+v'", climate)
+	}
+}
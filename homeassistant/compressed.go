@@ -0,0 +1,159 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// EntitiesEvent is sent for subscribe_entities subscriptions:
+// * Additions: complete (compressed) states of new entities
+// * Changes: differences to the previous state of an entity
+// * Removals: ids of entities that are gone
+type EntitiesEvent struct {
+	Additions map[string]CompressedState `json:"a"`
+	Changes   map[string]StateDiff       `json:"c"`
+	Removals  []string                   `json:"r"`
+}
+
+// CompressedState is a State with short keys. The context is
+// either just its id or an object, timestamps are unix seconds.
+// LastChanged is omitted if it equals LastUpdated.
+type CompressedState struct {
+	State       string                 `json:"s"`
+	Attributes  map[string]interface{} `json:"a"`
+	Context     json.RawMessage        `json:"c"`
+	LastChanged float64                `json:"lc"`
+	LastUpdated float64                `json:"lu"`
+}
+
+// StateDiff describes how a state changed:
+// * Additions: changed values, only set fields are part of the diff
+// * Removals: names of attributes that were removed
+type StateDiff struct {
+	Additions struct {
+		State       *string                `json:"s"`
+		Attributes  map[string]interface{} `json:"a"`
+		Context     json.RawMessage        `json:"c"`
+		LastChanged *float64               `json:"lc"`
+		LastUpdated *float64               `json:"lu"`
+	} `json:"+"`
+	Removals struct {
+		Attributes []string `json:"a"`
+	} `json:"-,"`
+}
+
+// Expand converts the compressed state to a State.
+func (c CompressedState) Expand(entityID string) State {
+	state := State{
+		EntityID:    entityID,
+		State:       c.State,
+		Attributes:  c.Attributes,
+		LastUpdated: unixTime(c.LastUpdated),
+		Context:     mergeContext(Context{}, c.Context),
+	}
+	if state.Attributes == nil {
+		state.Attributes = map[string]interface{}{}
+	}
+	state.LastChanged = state.LastUpdated
+	if c.LastChanged != 0 {
+		state.LastChanged = unixTime(c.LastChanged)
+	}
+	return state
+}
+
+// Apply returns the state with the diff applied,
+// the original state (and its attributes) is left untouched.
+func (d StateDiff) Apply(state State) State {
+	additions := d.Additions
+
+	attributes := make(map[string]interface{}, len(state.Attributes))
+	for key, value := range state.Attributes {
+		attributes[key] = value
+	}
+	for key, value := range additions.Attributes {
+		attributes[key] = value
+	}
+	for _, key := range d.Removals.Attributes {
+		delete(attributes, key)
+	}
+	state.Attributes = attributes
+
+	if additions.State != nil {
+		state.State = *additions.State
+	}
+	// a new last_changed implies an equal last_updated:
+	if additions.LastChanged != nil {
+		state.LastChanged = unixTime(*additions.LastChanged)
+		state.LastUpdated = state.LastChanged
+	} else if additions.LastUpdated != nil {
+		state.LastUpdated = unixTime(*additions.LastUpdated)
+	}
+	state.Context = mergeContext(state.Context, additions.Context)
+	return state
+}
+
+// ApplyTo updates the store with the event. The first event of a
+// subscription holds all entities, so it replaces the store's content.
+func (e EntitiesEvent) ApplyTo(store *Store, first bool) {
+	if first {
+		states := make([]State, 0, len(e.Additions))
+		for entityID, compressed := range e.Additions {
+			states = append(states, compressed.Expand(entityID))
+		}
+		store.Replace(states)
+	} else {
+		for entityID, compressed := range e.Additions {
+			store.Set(compressed.Expand(entityID))
+		}
+	}
+
+	for entityID, diff := range e.Changes {
+		state, _ := store.Get(entityID)
+		state.EntityID = entityID
+		store.Set(diff.Apply(state))
+	}
+
+	for _, entityID := range e.Removals {
+		store.Remove(entityID)
+	}
+}
+
+// mergeContext applies a compressed context (an id or a partial object).
+// Fields of the object that are missing are kept, null ones are cleared.
+func mergeContext(context Context, raw json.RawMessage) Context {
+	if len(raw) == 0 {
+		return context
+	}
+
+	// a bare id means parent and user did not change:
+	if json.Unmarshal(raw, &context.ID) == nil {
+		return context
+	}
+
+	var fields map[string]*string
+	if json.Unmarshal(raw, &fields) != nil {
+		return context
+	}
+	for key, field := range map[string]*string{
+		"id":        &context.ID,
+		"parent_id": &context.ParentID,
+		"user_id":   &context.UserID,
+	} {
+		value, ok := fields[key]
+		switch {
+		case !ok:
+		case value == nil:
+			*field = ""
+		default:
+			*field = *value
+		}
+	}
+	return context
+}
+
+// unixTime converts unix seconds (with fraction) to a time.Time.
+func unixTime(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)).UTC()
+}
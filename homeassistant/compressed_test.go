@@ -0,0 +1,80 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCompressedStateExpand(t *testing.T) {
+	var compressed CompressedState
+	json.Unmarshal([]byte(`{
+		"s": "21.5",
+		"a": {"unit_of_measurement": "°C"},
+		"c": {"id": "01G2", "parent_id": null, "user_id": "u1"},
+		"lu": 1651744800.25
+	}`), &compressed)
+
+	state := compressed.Expand("sensor.temperature")
+	if state.EntityID != "sensor.temperature" || state.State != "21.5" {
+		t.Errorf("unexpected state '%+v'", state)
+	}
+	if state.Unit() != "°C" || state.Context.UserID != "u1" {
+		t.Errorf("attributes and context should be kept, got '%+v'", state)
+	}
+
+	expectedUpdated := time.Unix(1651744800, 250000000)
+	if !state.LastUpdated.Equal(expectedUpdated) {
+		t.Errorf("LastUpdated should be '%v', got '%v'", expectedUpdated, state.LastUpdated)
+	}
+	if !state.LastChanged.Equal(state.LastUpdated) {
+		t.Errorf("LastChanged should default to LastUpdated, got '%v'", state.LastChanged)
+	}
+}
+
+func TestStateDiffApply(t *testing.T) {
+	original := State{
+		EntityID:   "light.hue_go_1",
+		State:      "on",
+		Attributes: map[string]interface{}{"brightness": 255.0, "effect": "none"},
+		Context:    Context{ID: "01G2", UserID: "u1"},
+	}
+
+	var diff StateDiff
+	json.Unmarshal([]byte(`{
+		"+": {"a": {"brightness": 128}, "c": "01G3", "lu": 1651744800},
+		"-": {"a": ["effect"]}
+	}`), &diff)
+
+	state := diff.Apply(original)
+	if state.State != "on" || state.Attributes["brightness"] != 128.0 {
+		t.Errorf("unexpected state '%+v'", state)
+	}
+	if _, ok := state.Attributes["effect"]; ok {
+		t.Errorf("effect should be removed, got '%+v'", state.Attributes)
+	}
+	if state.Context.ID != "01G3" || state.Context.UserID != "u1" {
+		t.Errorf("a bare context id should keep the user, got '%+v'", state.Context)
+	}
+
+	// null fields are cleared, missing ones kept:
+	diff = StateDiff{}
+	json.Unmarshal([]byte(`{
+		"+": {"c": {"id": "01G4", "user_id": null, "parent_id": null}}
+	}`), &diff)
+	withParent := original
+	withParent.Context.ParentID = "01G1"
+	state = diff.Apply(withParent)
+	if state.Context != (Context{ID: "01G4"}) {
+		t.Errorf("context should be '{ID:01G4}', got '%+v'", state.Context)
+	}
+	diff = StateDiff{}
+	json.Unmarshal([]byte(`{"+": {"c": {"id": "01G5", "parent_id": "01G4"}}}`), &diff)
+	state = diff.Apply(original)
+	if state.Context != (Context{ID: "01G5", ParentID: "01G4", UserID: "u1"}) {
+		t.Errorf("a missing user_id should be kept, got '%+v'", state.Context)
+	}
+	if original.Attributes["brightness"] != 255.0 || original.Attributes["effect"] != "none" {
+		t.Errorf("original attributes should be untouched, got '%+v'", original.Attributes)
+	}
+}
@@ -0,0 +1,97 @@
+package homeassistant
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// WebSocketURL returns the URL of the WebSocket API. If URL is set
+// (e.g. https://ha.example.com/) Scheme and Server are ignored and
+// the WebSocket scheme is derived from it: http → ws, https → wss.
+func (c Config) WebSocketURL() (string, error) {
+	if c.URL == "" {
+		haURL := url.URL{
+			Scheme: c.Scheme,
+			Host:   c.Server,
+			Path:   APIPath,
+		}
+		return haURL.String(), nil
+	}
+
+	haURL, err := url.Parse(c.URL)
+	if err != nil {
+		return "", &ConfigError{Key: "url", Err: err}
+	}
+
+	switch strings.ToLower(haURL.Scheme) {
+	case "http", "ws":
+		haURL.Scheme = "ws"
+	case "https", "wss":
+		haURL.Scheme = "wss"
+	default:
+		return "", &ConfigError{
+			Key: "url",
+			Err: fmt.Errorf("unsupported scheme [%v]", haURL.Scheme),
+		}
+	}
+	if haURL.Host == "" {
+		return "", &ConfigError{Key: "url", Err: fmt.Errorf("missing host")}
+	}
+
+	// keep the path of reverse proxies that serve HA in a subfolder:
+	if !strings.HasSuffix(haURL.Path, APIPath) {
+		haURL.Path = path.Join("/", haURL.Path, APIPath)
+	}
+	return haURL.String(), nil
+}
+
+// TLSConfig builds the TLS settings for wss connections:
+// * CAFile: PEM bundle trusted in addition to the system's CAs
+// * CertFile, KeyFile: client certificate (e.g. for a reverse proxy)
+// * ServerName: name to verify the server's certificate against
+// * InsecureSkipVerify: do not verify the server's certificate at all
+func (c Config) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, &ConfigError{Key: "ca-file", Err: err}
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, &ConfigError{
+				Key: "ca-file",
+				Err: fmt.Errorf("no certificates found in %v", c.CAFile),
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, &ConfigError{
+				Key: "cert-file",
+				Err: fmt.Errorf("cert-file and key-file have to be set together"),
+			}
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, &ConfigError{Key: "cert-file", Err: err}
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
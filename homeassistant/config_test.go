@@ -0,0 +1,262 @@
+package homeassistant
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testPKI is a throwaway certificate authority with
+// a server and a client certificate signed by it.
+type testPKI struct {
+	ca         *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	caFile     string
+	server     tls.Certificate
+	clientCert string
+	clientKey  string
+}
+
+// newTestPKI creates the certificates, the server's is
+// valid for serverName (a DNS name or an IP address).
+func newTestPKI(t *testing.T, serverName string) *testPKI {
+	dir := t.TempDir()
+	pki := &testPKI{}
+
+	pki.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bhdr test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &pki.caKey.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.ca, _ = x509.ParseCertificate(der)
+	pki.caFile = writePEM(t, dir, "ca.pem", "CERTIFICATE", der)
+
+	// server certificate:
+	serverTemplate := &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(serverName); ip != nil {
+		serverTemplate.IPAddresses = []net.IP{ip}
+	} else {
+		serverTemplate.DNSNames = []string{serverName}
+	}
+	serverDER, serverKey := pki.sign(t, 2, serverTemplate)
+	pki.server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	// client certificate:
+	clientDER, clientKey := pki.sign(t, 3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "bhdr"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	keyDER, _ := x509.MarshalECPrivateKey(clientKey)
+	pki.clientCert = writePEM(t, dir, "client.pem", "CERTIFICATE", clientDER)
+	pki.clientKey = writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+
+	return pki
+}
+
+func (pki *testPKI) sign(
+	t *testing.T,
+	serial int64,
+	template *x509.Certificate,
+) ([]byte, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, pki.ca, &key.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	file := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// newTLSAuthServer is a TLS stand-in for HA that runs the handshake.
+// With requireClientCert it only accepts clients signed by the test CA.
+func newTLSAuthServer(
+	t *testing.T,
+	pki *testPKI,
+	requireClientCert bool,
+) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != APIPath {
+				http.NotFound(w, r)
+				return
+			}
+			connection, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer connection.Close()
+			handshake(connection, "2022.5.0", "secret")
+			connection.ReadMessage()
+		}),
+	)
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // rejected handshakes.
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{pki.server}}
+	if requireClientCert {
+		pool := x509.NewCertPool()
+		pool.AddCert(pki.ca)
+		server.TLS.ClientCAs = pool
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebSocketURL(t *testing.T) {
+	urls := map[string]string{
+		"http://127.0.0.1:8123":              "ws://127.0.0.1:8123/api/websocket",
+		"https://ha.example.com":             "wss://ha.example.com/api/websocket",
+		"https://ha.example.com/":            "wss://ha.example.com/api/websocket",
+		"https://example.com/ha":             "wss://example.com/ha/api/websocket",
+		"wss://ha.example.com/api/websocket": "wss://ha.example.com/api/websocket",
+	}
+	for base, expected := range urls {
+		got, err := Config{URL: base}.WebSocketURL()
+		if err != nil || got != expected {
+			t.Errorf("%v: expected '%v', got '%v' (%v)", base, expected, got, err)
+		}
+	}
+
+	got, _ := Config{Scheme: "ws", Server: "127.0.0.1:8123"}.WebSocketURL()
+	if expected := "ws://127.0.0.1:8123/api/websocket"; got != expected {
+		t.Errorf("expected '%v', got '%v'", expected, got)
+	}
+
+	var configError *ConfigError
+	for _, base := range []string{"ftp://ha.example.com", "https://"} {
+		if _, err := (Config{URL: base}).WebSocketURL(); !errors.As(err, &configError) {
+			t.Errorf("%v: expected *ConfigError, got '%v'", base, err)
+		}
+	}
+}
+
+func TestDialTLSCustomCA(t *testing.T) {
+	pki := newTestPKI(t, "127.0.0.1")
+	server := newTLSAuthServer(t, pki, false)
+
+	config := Config{URL: server.URL, Token: "secret", CAFile: pki.caFile}
+	connection, _, err := Dial(config)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	connection.Close()
+}
+
+func TestDialTLSUnknownCA(t *testing.T) {
+	pki := newTestPKI(t, "ha.internal")
+	server := newTLSAuthServer(t, pki, false)
+
+	_, _, err := Dial(Config{URL: server.URL, Token: "secret", ServerName: "ha.internal"})
+	var connectionError *ConnectionError
+	if !errors.As(err, &connectionError) {
+		t.Fatalf("expected *ConnectionError, got '%v'", err)
+	}
+}
+
+func TestDialTLSServerName(t *testing.T) {
+	pki := newTestPKI(t, "ha.internal")
+	server := newTLSAuthServer(t, pki, false)
+	config := Config{URL: server.URL, Token: "secret", CAFile: pki.caFile}
+
+	if _, _, err := Dial(config); err == nil {
+		t.Fatalf("the certificate should not be valid for %v", server.URL)
+	}
+
+	config.ServerName = "ha.internal"
+	connection, _, err := Dial(config)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	connection.Close()
+}
+
+func TestDialTLSInsecureSkipVerify(t *testing.T) {
+	pki := newTestPKI(t, "ha.internal")
+	server := newTLSAuthServer(t, pki, false)
+
+	config := Config{URL: server.URL, Token: "secret", InsecureSkipVerify: true}
+	connection, _, err := Dial(config)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	connection.Close()
+}
+
+func TestDialTLSClientCertificate(t *testing.T) {
+	pki := newTestPKI(t, "ha.internal")
+	server := newTLSAuthServer(t, pki, true)
+	config := Config{
+		URL:        server.URL,
+		Token:      "secret",
+		CAFile:     pki.caFile,
+		ServerName: "ha.internal",
+	}
+
+	if _, _, err := Dial(config); err == nil {
+		t.Fatalf("the server should reject clients without certificate")
+	}
+
+	config.CertFile = pki.clientCert
+	config.KeyFile = pki.clientKey
+	connection, _, err := Dial(config)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	connection.Close()
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	configs := map[string]Config{
+		"ca-file":   {CAFile: "/nonexistent/ca.pem"},
+		"cert-file": {CertFile: "/nonexistent/cert.pem"},
+	}
+	for key, config := range configs {
+		_, err := config.TLSConfig()
+		var configError *ConfigError
+		if !errors.As(err, &configError) || configError.Key != key {
+			t.Errorf("expected *ConfigError for [%v], got '%v'", key, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strings"
+)
+
+// alarm modes by the bit of supported_features that enables them:
+var alarmModes = []struct {
+	Mode    string
+	Feature int
+}{
+	{"home", 1},
+	{"away", 2},
+	{"night", 4},
+	{"vacation", 32},
+}
+
+// ToggleCall returns the call that toggles an entity, by domain:
+// * covers: open_cover or close_cover (stop_cover while moving)
+// * locks: lock or unlock (with a code if the lock has a code_format)
+// * alarm panels: alarm_disarm (arming needs a mode, see AlarmCall)
+// * anything else: toggle
+func ToggleCall(state State) (ServiceCall, error) {
+	call := ServiceCall{
+		Domain:  Domain(state.EntityID),
+		Service: "toggle",
+		Target:  Target{EntityID: []string{state.EntityID}},
+	}
+	switch call.Domain {
+	case "cover":
+		switch state.State {
+		case "opening", "closing":
+			call.Service = "stop_cover"
+		case "open":
+			call.Service = "close_cover"
+		default:
+			call.Service = "open_cover"
+		}
+	case "lock":
+		switch state.State {
+		case "locked":
+			return LockCall(state, false), nil
+		case "unlocked":
+			return LockCall(state, true), nil
+		}
+		return call, fmt.Errorf("%v is %v", state.EntityID, stateOrUnknown(state))
+	case "alarm_control_panel":
+		if state.State == "disarmed" || state.State == "" {
+			return call, fmt.Errorf(
+				"%v is %v, arm it from its panel", state.EntityID, stateOrUnknown(state),
+			)
+		}
+		return AlarmCall(state, ""), nil
+	}
+	return call, nil
+}
+
+// LockCall returns the call that locks (or unlocks) a lock.
+func LockCall(state State, lock bool) ServiceCall {
+	call := ServiceCall{
+		Domain:  "lock",
+		Service: "unlock",
+		Target:  Target{EntityID: []string{state.EntityID}},
+	}
+	if lock {
+		call.Service = "lock"
+	}
+	format, _ := state.Attributes["code_format"].(string)
+	call.NeedsCode = format != ""
+	return call
+}
+
+// AlarmCall returns the call that arms an alarm panel in a mode
+// (home, away, night or vacation) or disarms it (mode ""). A code
+// is needed if the panel has a code_format, for arming only if
+// code_arm_required is not false.
+func AlarmCall(state State, mode string) ServiceCall {
+	call := ServiceCall{
+		Domain:  "alarm_control_panel",
+		Service: "alarm_disarm",
+		Target:  Target{EntityID: []string{state.EntityID}},
+	}
+	format, _ := state.Attributes["code_format"].(string)
+	call.NeedsCode = format != ""
+	if mode != "" {
+		call.Service = "alarm_arm_" + mode
+		if required, ok := state.Attributes["code_arm_required"].(bool); ok && !required {
+			call.NeedsCode = false
+		}
+	}
+	return call
+}
+
+// AlarmModes returns the modes an alarm panel can be armed in,
+// all of them if it does not report its supported_features.
+func AlarmModes(state State) []string {
+	features, ok := state.Attributes["supported_features"].(float64)
+	var modes []string
+	for _, mode := range alarmModes {
+		if !ok || int(features)&mode.Feature != 0 {
+			modes = append(modes, mode.Mode)
+		}
+	}
+	return modes
+}
+
+// stateOrUnknown returns the state in words ("unknown" without one).
+func stateOrUnknown(state State) string {
+	if state.State == "" {
+		return "unknown"
+	}
+	return strings.ReplaceAll(state.State, "_", " ")
+}
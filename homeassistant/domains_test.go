@@ -0,0 +1,85 @@
+package homeassistant
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToggleCall(t *testing.T) {
+	states := map[string]string{
+		"switch.fan=on":                       "toggle",
+		"cover.garage=open":                   "close_cover",
+		"cover.garage=closed":                 "open_cover",
+		"cover.garage=opening":                "stop_cover",
+		"lock.front_door=locked":              "unlock",
+		"lock.front_door=unlocked":            "lock",
+		"alarm_control_panel.home=armed_away": "alarm_disarm",
+		"alarm_control_panel.home=triggered":  "alarm_disarm",
+	}
+	for entityState, expected := range states {
+		state := State{}
+		state.EntityID, state.State, _ = strings.Cut(entityState, "=")
+		call, err := ToggleCall(state)
+		if err != nil {
+			t.Errorf("%v: got unexpected error: '%v'", entityState, err)
+			continue
+		}
+		if call.Service != expected || call.Domain != Domain(state.EntityID) {
+			t.Errorf("%v should call '%v', got '%v.%v'", entityState, expected, call.Domain, call.Service)
+		}
+	}
+
+	failing := map[string]string{
+		"lock.front_door":          "jammed",
+		"alarm_control_panel.home": "disarmed",
+	}
+	for entityID, value := range failing {
+		if _, err := ToggleCall(State{EntityID: entityID, State: value}); err == nil {
+			t.Errorf("toggling %v (%v) should fail", entityID, value)
+		}
+	}
+}
+
+func TestCodes(t *testing.T) {
+	lock := State{
+		EntityID:   "lock.front_door",
+		State:      "locked",
+		Attributes: map[string]interface{}{"code_format": "^\\d{4}$"},
+	}
+	if !LockCall(lock, false).NeedsCode {
+		t.Errorf("lock with a code_format should need a code")
+	}
+	if LockCall(State{EntityID: "lock.shed"}, true).NeedsCode {
+		t.Errorf("lock without a code_format should not need a code")
+	}
+
+	alarm := State{
+		EntityID: "alarm_control_panel.home",
+		State:    "disarmed",
+		Attributes: map[string]interface{}{
+			"code_format":       "number",
+			"code_arm_required": false,
+		},
+	}
+	if call := AlarmCall(alarm, "away"); call.Service != "alarm_arm_away" || call.NeedsCode {
+		t.Errorf("arming should not need a code, got '%+v'", call)
+	}
+	if call := AlarmCall(alarm, ""); call.Service != "alarm_disarm" || !call.NeedsCode {
+		t.Errorf("disarming should need a code, got '%+v'", call)
+	}
+}
+
+func TestAlarmModes(t *testing.T) {
+	alarm := State{
+		EntityID:   "alarm_control_panel.home",
+		Attributes: map[string]interface{}{"supported_features": 3.0},
+	}
+	if got := AlarmModes(alarm); !reflect.DeepEqual(got, []string{"home", "away"}) {
+		t.Errorf("modes should be '[home away]', got '%v'", got)
+	}
+	alarm.Attributes = nil
+	if got := AlarmModes(alarm); len(got) != 4 {
+		t.Errorf("all modes should be supported without features, got '%v'", got)
+	}
+}
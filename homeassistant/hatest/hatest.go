@@ -0,0 +1,592 @@
+// Package hatest provides an in-process fake Home Assistant for tests.
+// It speaks enough of the WebSocket API for bhdr:
+// * the authentication handshake
+// * get_states, subscribe_events and subscribe_entities
+// * call_service for turn_on, turn_off and toggle (plus light data)
+// * call_service for the domainServices (their data is applied)
+// * the area, device and entity registries
+// * get_services (turn_on, turn_off, toggle and the domainServices)
+// * ping
+package hatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/gorilla/websocket"
+)
+
+// Version is reported as ha_version.
+const Version string = "2022.5.0"
+
+// domainServices are the services of a domain besides turn_on,
+// turn_off and toggle. Their data becomes attributes of the
+// targets (renamed by dataAttributes), except for stateData
+// (which becomes their state) and code (which is dropped).
+// media_play_pause switches between playing and paused, the
+// serviceStates set the state.
+var domainServices = map[string][]string{
+	"climate": {"set_temperature", "set_hvac_mode", "set_preset_mode", "set_fan_mode"},
+	"media_player": {
+		"media_play_pause", "media_next_track", "media_previous_track",
+		"media_seek", "volume_set", "select_source", "shuffle_set", "repeat_set",
+	},
+	"cover": {
+		"open_cover", "close_cover", "stop_cover",
+		"set_cover_position", "set_cover_tilt_position",
+	},
+	"lock": {"lock", "unlock"},
+	"alarm_control_panel": {
+		"alarm_arm_home", "alarm_arm_away", "alarm_arm_night", "alarm_arm_vacation",
+		"alarm_disarm",
+	},
+}
+
+// serviceStates are the states set by domainServices.
+var serviceStates = map[string]string{
+	"open_cover":         "open",
+	"close_cover":        "closed",
+	"lock":               "locked",
+	"unlock":             "unlocked",
+	"alarm_arm_home":     "armed_home",
+	"alarm_arm_away":     "armed_away",
+	"alarm_arm_night":    "armed_night",
+	"alarm_arm_vacation": "armed_vacation",
+	"alarm_disarm":       "disarmed",
+}
+
+// stateData are the keys of service data that set the state.
+var stateData = []string{"hvac_mode"}
+
+// dataAttributes are the attributes set by keys of service data.
+var dataAttributes = map[string]string{
+	"seek_position": "media_position",
+	"position":      "current_position",
+	"tilt_position": "current_tilt_position",
+}
+
+// Call is a recorded call_service request.
+type Call struct {
+	Domain  string
+	Service string
+	Target  homeassistant.Target
+	Data    map[string]interface{}
+}
+
+// Server is a fake Home Assistant. Create it with NewServer.
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mutex    sync.Mutex
+	states   map[string]homeassistant.State
+	calls    []Call
+	clients  map[*client]bool
+	areas    []homeassistant.Area
+	devices  []homeassistant.Device
+	registry []homeassistant.RegistryEntry
+}
+
+// client is a connected (and authenticated) WebSocket client.
+type client struct {
+	mutex      sync.Mutex // guards writes.
+	connection *websocket.Conn
+	events     []uint          // ids of subscribe_events subscriptions.
+	entities   map[uint]filter // ids of subscribe_entities subscriptions.
+}
+
+// filter of a subscribe_entities subscription (empty for all).
+type filter map[string]bool
+
+func (f filter) matches(entityID string) bool {
+	return len(f) == 0 || f[entityID]
+}
+
+// NewServer starts a fake HA that accepts token and knows states.
+func NewServer(token string, states ...homeassistant.State) *Server {
+	s := &Server{
+		Token:   token,
+		states:  map[string]homeassistant.State{},
+		clients: map[*client]bool{},
+	}
+	for _, state := range states {
+		s.states[state.EntityID] = complete(state)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Config returns a client configuration for the server.
+func (s *Server) Config() homeassistant.Config {
+	return homeassistant.Config{URL: s.URL, Token: s.Token}
+}
+
+// State returns the current state of an entity.
+func (s *Server) State(entityID string) (homeassistant.State, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.states[entityID]
+	return state, ok
+}
+
+// SetState changes (or adds) an entity and notifies all subscribers.
+func (s *Server) SetState(state homeassistant.State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setState(state)
+}
+
+// SetRegistry replaces the area, device and entity registries.
+func (s *Server) SetRegistry(
+	areas []homeassistant.Area,
+	devices []homeassistant.Device,
+	entries []homeassistant.RegistryEntry,
+) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.areas = areas
+	s.devices = devices
+	s.registry = entries
+}
+
+// Calls returns all service calls received so far.
+func (s *Server) Calls() []Call {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// DropConnections disconnects all clients (like a restart of HA).
+func (s *Server) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.clients {
+		c.connection.Close()
+		delete(s.clients, c)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != homeassistant.APIPath {
+		http.NotFound(w, r)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+
+	c := &client{connection: connection, entities: map[uint]filter{}}
+	if !s.authenticate(c) {
+		return
+	}
+
+	s.mutex.Lock()
+	s.clients[c] = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.clients, c)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		command := map[string]interface{}{}
+		if err := connection.ReadJSON(&command); err != nil {
+			return
+		}
+		s.handle(c, command)
+	}
+}
+
+// authenticate runs the server side of the handshake.
+func (s *Server) authenticate(c *client) bool {
+	c.write(map[string]interface{}{"type": "auth_required", "ha_version": Version})
+
+	auth := map[string]string{}
+	if err := c.connection.ReadJSON(&auth); err != nil {
+		return false
+	}
+	if auth["type"] != "auth" || auth["access_token"] != s.Token {
+		c.write(map[string]interface{}{
+			"type":    "auth_invalid",
+			"message": "Invalid access token or password",
+		})
+		return false
+	}
+	c.write(map[string]interface{}{"type": "auth_ok", "ha_version": Version})
+	return true
+}
+
+// handle answers a single command.
+func (s *Server) handle(c *client, command map[string]interface{}) {
+	id, _ := command["id"].(float64)
+	messageID := uint(id)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch command["type"] {
+	case "ping":
+		c.write(map[string]interface{}{"id": messageID, "type": "pong"})
+	case "get_states":
+		c.result(messageID, s.sortedStates())
+	case "subscribe_events":
+		c.events = append(c.events, messageID)
+		c.result(messageID, nil)
+	case "subscribe_entities":
+		entities := filter{}
+		ids, _ := command["entity_ids"].([]interface{})
+		for _, entityID := range ids {
+			entities[fmt.Sprint(entityID)] = true
+		}
+		c.entities[messageID] = entities
+		c.result(messageID, nil)
+
+		additions := map[string]interface{}{}
+		for _, state := range s.states {
+			if entities.matches(state.EntityID) {
+				additions[state.EntityID] = compress(state)
+			}
+		}
+		c.event(messageID, map[string]interface{}{"a": additions})
+	case "call_service":
+		s.callService(c, messageID, command)
+	case "get_services":
+		c.result(messageID, s.services())
+	case "config/area_registry/list":
+		c.result(messageID, nonNil(s.areas))
+	case "config/device_registry/list":
+		c.result(messageID, nonNil(s.devices))
+	case "config/entity_registry/list":
+		c.result(messageID, nonNil(s.registry))
+	default:
+		c.error(messageID, "unknown_command", "Unknown command.")
+	}
+}
+
+// callService applies turn_on, turn_off and toggle to the targets.
+func (s *Server) callService(
+	c *client,
+	messageID uint,
+	command map[string]interface{},
+) {
+	var call Call
+	raw, _ := json.Marshal(command)
+	json.Unmarshal(raw, &struct {
+		Domain  *string                 `json:"domain"`
+		Service *string                 `json:"service"`
+		Target  *homeassistant.Target   `json:"target"`
+		Data    *map[string]interface{} `json:"service_data"`
+	}{&call.Domain, &call.Service, &call.Target, &call.Data})
+	s.calls = append(s.calls, call)
+
+	switch {
+	case call.Service == "turn_on", call.Service == "turn_off", call.Service == "toggle":
+	case contains(domainServices[call.Domain], call.Service):
+	default:
+		c.error(messageID, "not_found", fmt.Sprintf(
+			"Service %v.%v not found.", call.Domain, call.Service,
+		))
+		return
+	}
+
+	for _, entityID := range call.Target.EntityID {
+		state, ok := s.states[entityID]
+		if !ok {
+			continue
+		}
+		if call.Domain != "homeassistant" && call.Domain != homeassistant.Domain(entityID) {
+			continue
+		}
+		s.setState(serviceState(state, call))
+	}
+	c.result(messageID, map[string]interface{}{
+		"context": map[string]interface{}{"id": newContextID(), "parent_id": nil, "user_id": nil},
+	})
+}
+
+// serviceState returns the state an entity has after a service call.
+func serviceState(state homeassistant.State, call Call) homeassistant.State {
+	service := call.Service
+	if service == "toggle" {
+		service = "turn_on"
+		if state.State == "on" {
+			service = "turn_off"
+		}
+	}
+
+	attributes := map[string]interface{}{}
+	for key, value := range state.Attributes {
+		attributes[key] = value
+	}
+	state.Attributes = attributes
+
+	if contains(domainServices[call.Domain], service) {
+		for key, value := range call.Data {
+			if attribute, ok := dataAttributes[key]; ok {
+				key = attribute
+			}
+			if key == "code" {
+				continue
+			}
+			if contains(stateData, key) {
+				state.State = fmt.Sprint(value)
+			} else {
+				state.Attributes[key] = value
+			}
+		}
+		if service == "media_play_pause" && state.State == "playing" {
+			state.State = "paused"
+		} else if service == "media_play_pause" {
+			state.State = "playing"
+		}
+		if serviceState, ok := serviceStates[service]; ok {
+			state.State = serviceState
+		}
+		return state
+	}
+
+	if service == "turn_off" {
+		state.State = "off"
+		delete(state.Attributes, "brightness")
+		return state
+	}
+
+	state.State = "on"
+	for key, value := range call.Data {
+		switch key {
+		case "brightness_pct":
+			percent, _ := value.(float64)
+			state.Attributes["brightness"] = float64(int(percent*255/100 + 0.5))
+		default:
+			state.Attributes[key] = value
+		}
+	}
+	if _, ok := state.Attributes["brightness"]; !ok && homeassistant.Domain(state.EntityID) == "light" {
+		state.Attributes["brightness"] = 255.0
+	}
+	if brightness, ok := state.Attributes["brightness"].(float64); ok && brightness == 0 {
+		state.State = "off"
+	}
+	return state
+}
+
+// setState updates an entity and notifies subscribers, s.mutex is held.
+func (s *Server) setState(state homeassistant.State) {
+	old, known := s.states[state.EntityID]
+	state = complete(state)
+	if known && old.State == state.State {
+		state.LastChanged = old.LastChanged
+	}
+	s.states[state.EntityID] = state
+
+	for c := range s.clients {
+		for _, id := range c.events {
+			data := map[string]interface{}{
+				"entity_id": state.EntityID,
+				"new_state": state,
+				"old_state": nil,
+			}
+			if known {
+				data["old_state"] = old
+			}
+			c.event(id, map[string]interface{}{
+				"event_type": "state_changed",
+				"data":       data,
+				"origin":     "LOCAL",
+				"time_fired": state.LastUpdated,
+				"context":    state.Context,
+			})
+		}
+		for id, entities := range c.entities {
+			if !entities.matches(state.EntityID) {
+				continue
+			}
+			if !known {
+				c.event(id, map[string]interface{}{
+					"a": map[string]interface{}{state.EntityID: compress(state)},
+				})
+				continue
+			}
+			c.event(id, map[string]interface{}{
+				"c": map[string]interface{}{state.EntityID: diff(old, state)},
+			})
+		}
+	}
+}
+
+// services are the services the fake server implements,
+// for every domain of its entities and the homeassistant domain.
+func (s *Server) services() map[string]map[string]homeassistant.Service {
+	services := map[string]map[string]homeassistant.Service{}
+	domains := []string{"homeassistant"}
+	for entityID := range s.states {
+		domains = append(domains, homeassistant.Domain(entityID))
+	}
+	for _, domain := range domains {
+		services[domain] = map[string]homeassistant.Service{
+			"turn_on":  {Name: "Turn on"},
+			"turn_off": {Name: "Turn off"},
+			"toggle":   {Name: "Toggle"},
+		}
+		for _, service := range domainServices[domain] {
+			services[domain][service] = homeassistant.Service{
+				Name: strings.ReplaceAll(service, "_", " "),
+			}
+		}
+	}
+	return services
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) sortedStates() []homeassistant.State {
+	states := make([]homeassistant.State, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	sortStates(states)
+	return states
+}
+
+func (c *client) write(message interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connection.WriteJSON(message)
+}
+
+func (c *client) result(id uint, result interface{}) {
+	c.write(map[string]interface{}{
+		"id":      id,
+		"type":    "result",
+		"success": true,
+		"result":  result,
+	})
+}
+
+func (c *client) error(id uint, code string, message string) {
+	c.write(map[string]interface{}{
+		"id":      id,
+		"type":    "result",
+		"success": false,
+		"error":   map[string]string{"code": code, "message": message},
+	})
+}
+
+func (c *client) event(id uint, event interface{}) {
+	c.write(map[string]interface{}{"id": id, "type": "event", "event": event})
+}
+
+// complete fills in what HA always sets: attributes, timestamps and context.
+func complete(state homeassistant.State) homeassistant.State {
+	now := time.Now().UTC()
+	if state.Attributes == nil {
+		state.Attributes = map[string]interface{}{}
+	}
+	if _, ok := state.Attributes["friendly_name"]; !ok {
+		name := strings.SplitN(state.EntityID, ".", 2)
+		state.Attributes["friendly_name"] = strings.ReplaceAll(name[len(name)-1], "_", " ")
+	}
+	state.LastUpdated = now
+	state.LastChanged = now
+	state.Context = homeassistant.Context{ID: newContextID()}
+	return state
+}
+
+// compress converts a state to the format of subscribe_entities.
+func compress(state homeassistant.State) map[string]interface{} {
+	compressed := map[string]interface{}{
+		"s":  state.State,
+		"a":  state.Attributes,
+		"c":  state.Context.ID,
+		"lu": unixSeconds(state.LastUpdated),
+	}
+	if !state.LastChanged.Equal(state.LastUpdated) {
+		compressed["lc"] = unixSeconds(state.LastChanged)
+	}
+	return compressed
+}
+
+// diff describes the change from old to state like subscribe_entities.
+func diff(old, state homeassistant.State) map[string]interface{} {
+	additions := map[string]interface{}{"c": state.Context.ID}
+	if old.State != state.State {
+		additions["s"] = state.State
+	}
+	if !old.LastChanged.Equal(state.LastChanged) {
+		additions["lc"] = unixSeconds(state.LastChanged)
+	} else {
+		additions["lu"] = unixSeconds(state.LastUpdated)
+	}
+
+	changed := map[string]interface{}{}
+	for key, value := range state.Attributes {
+		if fmt.Sprint(old.Attributes[key]) != fmt.Sprint(value) {
+			changed[key] = value
+		}
+	}
+	if len(changed) > 0 {
+		additions["a"] = changed
+	}
+
+	result := map[string]interface{}{"+": additions}
+	var removed []string
+	for key := range old.Attributes {
+		if _, ok := state.Attributes[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) > 0 {
+		result["-"] = map[string]interface{}{"a": removed}
+	}
+	return result
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+var contextCounter struct {
+	sync.Mutex
+	n int
+}
+
+func newContextID() string {
+	contextCounter.Lock()
+	defer contextCounter.Unlock()
+	contextCounter.n++
+	return fmt.Sprintf("01HATEST%06d", contextCounter.n)
+}
+
+func sortStates(states []homeassistant.State) {
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].EntityID < states[j].EntityID
+	})
+}
+
+// nonNil turns nil slices into empty ones, HA sends [] for empty lists.
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
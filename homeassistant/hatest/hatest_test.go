@@ -0,0 +1,351 @@
+package hatest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/homeassistant/hatest"
+)
+
+// runClient connects a client to server and waits until it is online.
+// Events and later connection states are drained in the background.
+func runClient(t *testing.T, server *hatest.Server) *homeassistant.Client {
+	return runClientWith(t, server.Config())
+}
+
+// runClientWith is runClient with a modified config.
+func runClientWith(t *testing.T, config homeassistant.Config) *homeassistant.Client {
+	client := homeassistant.NewClient(config)
+	go client.Run()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case state := <-client.States():
+			if state.Status != homeassistant.Online {
+				continue
+			}
+			go func() {
+				for range client.States() {
+				}
+			}()
+			go func() {
+				for range client.Events() {
+				}
+			}()
+			return client
+		case <-timeout:
+			t.Fatal("client did not come online")
+		}
+	}
+}
+
+// waitForState fails the test if entityID does not reach state.
+func waitForState(
+	t *testing.T,
+	store *homeassistant.Store,
+	entityID string,
+	state string,
+) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := store.Get(entityID); got.State == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got, _ := store.Get(entityID)
+	t.Fatalf("%v should be '%v', got '%v'", entityID, state, got.State)
+}
+
+func TestServerStreamsStates(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "switch.fan", State: "off"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	waitForState(t, client.Store(), "switch.fan", "off")
+
+	server.SetState(homeassistant.State{EntityID: "switch.fan", State: "on"})
+	waitForState(t, client.Store(), "switch.fan", "on")
+
+	server.SetState(homeassistant.State{EntityID: "switch.lamp", State: "on"})
+	waitForState(t, client.Store(), "switch.lamp", "on")
+}
+
+func TestServerCallService(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "light.hue", State: "off"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := client.Call(
+		ctx,
+		"light",
+		"turn_on",
+		homeassistant.Target{EntityID: []string{"light.hue"}},
+		map[string]interface{}{"brightness_pct": 50},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "light.hue", "on")
+
+	state, _ := server.State("light.hue")
+	if state.Attributes["brightness"] != 128.0 {
+		t.Errorf("brightness should be '128', got '%v'", state.Attributes["brightness"])
+	}
+
+	calls := server.Calls()
+	if len(calls) != 1 || calls[0].Service != "turn_on" {
+		t.Errorf("calls should be '[turn_on]', got '%+v'", calls)
+	}
+
+	_, err = client.Call(ctx, "light", "flash", homeassistant.Target{}, nil)
+	var resultErr *homeassistant.ResultError
+	if !errors.As(err, &resultErr) {
+		t.Errorf("err should be '*ResultError', got '%v'", err)
+	}
+}
+
+func TestServerCallDomainService(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "climate.office", State: "off"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	target := homeassistant.Target{EntityID: []string{"climate.office"}}
+	_, err := client.Call(ctx, "climate", "set_temperature", target,
+		map[string]interface{}{"temperature": 21.5},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Call(ctx, "climate", "set_hvac_mode", target,
+		map[string]interface{}{"hvac_mode": "heat"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "climate.office", "heat")
+
+	state, _ := server.State("climate.office")
+	if state.Attributes["temperature"] != 21.5 {
+		t.Errorf("temperature should be '21.5', got '%v'", state.Attributes["temperature"])
+	}
+}
+
+func TestServerRejectsToken(t *testing.T) {
+	server := hatest.NewServer("secret")
+	defer server.Close()
+	config := server.Config()
+	config.Token = "wrong"
+
+	_, _, err := homeassistant.Dial(config)
+	var authErr *homeassistant.AuthError
+	if !errors.As(err, &authErr) {
+		t.Errorf("err should be '*AuthError', got '%v'", err)
+	}
+}
+
+func TestServerRegistry(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "light.hue", State: "on"},
+	)
+	defer server.Close()
+	server.SetRegistry(
+		[]homeassistant.Area{{AreaID: "kitchen", Name: "Kitchen"}},
+		[]homeassistant.Device{{ID: "d1", Name: "Hue Go", AreaID: "kitchen"}},
+		[]homeassistant.RegistryEntry{{EntityID: "light.hue", DeviceID: "d1"}},
+	)
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	registry, err := client.Registry(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	area, _ := registry.Area("light.hue")
+	if area.Name != "Kitchen" {
+		t.Errorf("area should be 'Kitchen', got '%v'", area.Name)
+	}
+	device, _ := registry.Device("light.hue")
+	if device.DisplayName() != "Hue Go" {
+		t.Errorf("device should be 'Hue Go', got '%v'", device.DisplayName())
+	}
+}
+
+func TestServerServices(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "light.hue", State: "on"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	services, err := client.GetServices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if services["light"]["turn_on"].Name != "Turn on" {
+		t.Errorf("light.turn_on should be known, got '%v'", services)
+	}
+	if _, ok := services["homeassistant"]["toggle"]; !ok {
+		t.Errorf("homeassistant.toggle should be known, got '%v'", services)
+	}
+}
+
+func TestServerPlayPause(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "media_player.kitchen", State: "playing"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	target := homeassistant.Target{EntityID: []string{"media_player.kitchen"}}
+	if _, err := client.Call(ctx, "media_player", "media_play_pause", target, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "media_player.kitchen", "paused")
+
+	_, err := client.Call(ctx, "media_player", "media_seek", target,
+		map[string]interface{}{"seek_position": 42},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Call(ctx, "media_player", "media_play_pause", target, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "media_player.kitchen", "playing")
+	state, _ := server.State("media_player.kitchen")
+	if state.Attributes["media_position"] != 42.0 {
+		t.Errorf("media_position should be '42', got '%v'", state.Attributes["media_position"])
+	}
+}
+
+func TestServerLockAndCover(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "lock.front_door", State: "locked"},
+		homeassistant.State{EntityID: "cover.garage", State: "closed"},
+	)
+	defer server.Close()
+	client := runClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	lock := homeassistant.Target{EntityID: []string{"lock.front_door"}}
+	_, err := client.Call(ctx, "lock", "unlock", lock, map[string]interface{}{"code": "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "lock.front_door", "unlocked")
+	state, _ := server.State("lock.front_door")
+	if _, ok := state.Attributes["code"]; ok {
+		t.Errorf("code should not be stored, got '%v'", state.Attributes)
+	}
+
+	cover := homeassistant.Target{EntityID: []string{"cover.garage"}}
+	_, err = client.Call(ctx, "cover", "set_cover_position", cover,
+		map[string]interface{}{"position": 30},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Call(ctx, "cover", "open_cover", cover, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, client.Store(), "cover.garage", "open")
+	state, _ = server.State("cover.garage")
+	if state.Attributes["current_position"] != 30.0 {
+		t.Errorf("current_position should be '30', got '%v'", state.Attributes["current_position"])
+	}
+}
+
+func TestClientWithoutEventReader(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "switch.fan", State: "off"},
+	)
+	defer server.Close()
+
+	// nobody reads the events, results have to arrive anyway:
+	client := homeassistant.NewClient(server.Config())
+	go client.Run()
+	for state := range client.States() {
+		if state.Status == homeassistant.Online {
+			break
+		}
+	}
+	go func() {
+		for range client.States() {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	target := homeassistant.Target{EntityID: []string{"switch.fan"}}
+	for i := 0; i < homeassistant.EventBuffer; i++ {
+		if _, err := client.Call(ctx, "switch", "toggle", target, nil); err != nil {
+			t.Fatalf("call %v failed: %v", i, err)
+		}
+	}
+}
+
+func TestServerRefetchConfiguredEntities(t *testing.T) {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "switch.fan", State: "off"},
+		homeassistant.State{EntityID: "light.desk", State: "on"},
+	)
+	defer server.Close()
+	config := server.Config()
+	config.EntityIDs = []string{"switch.fan"}
+	client := runClientWith(t, config)
+	waitForState(t, client.Store(), "switch.fan", "off")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	states, err := client.GetStates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].EntityID != "switch.fan" {
+		t.Errorf("states should be 'switch.fan', got '%v'", states)
+	}
+	var entityIDs []string
+	for _, state := range client.Store().All() {
+		entityIDs = append(entityIDs, state.EntityID)
+	}
+	if len(entityIDs) != 1 || entityIDs[0] != "switch.fan" {
+		t.Errorf("the store should only hold 'switch.fan', got '%v'", entityIDs)
+	}
+}
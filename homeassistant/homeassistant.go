@@ -1,93 +1,142 @@
 package homeassistant
 
 import (
-	"bytes"
-	"encoding/json"
-	"log"
-	"net/url"
+	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/websocket"
 )
 
-// Connect connects to Home Assistant and communicates with two channels:
-// * events: events from HA will be published here
-// * commands: commands will be sent to HA
-func Connect(config Config, events chan string, commands chan Command) {
-	// TODO add proper error handling.
-	var messageID uint = 1
-	const APIPath string = "/api/websocket"
-	haURL := url.URL{
-		Scheme: config.Scheme,
-		Host:   config.Server,
-		Path:   APIPath,
-	}
+// MinVersion is the oldest Home Assistant release bhdr supports.
+const MinVersion string = "2021.3.0"
+
+// APIPath is the endpoint of the Home Assistant WebSocket API.
+const APIPath string = "/api/websocket"
+
+// states of the authentication handshake:
+const (
+	awaitingAuthRequired = iota
+	awaitingAuthResult
+	authenticated
+)
 
-	// connect:
-	connection, _, err := websocket.DefaultDialer.Dial(haURL.String(), nil)
-	defer connection.Close()
+// Dial opens a WebSocket connection to Home Assistant and
+// authenticates with the configured token.
+// It also returns the version reported by the server.
+func Dial(config Config) (*websocket.Conn, string, error) {
+	haURL, err := config.WebSocketURL()
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", err
+	}
+	tlsConfig, err := config.TLSConfig()
+	if err != nil {
+		return nil, "", err
 	}
 
-	// authenticate:
-	connection.WriteJSON(
-		map[string]string{
-			"type":         "auth",
-			"access_token": config.Token,
-		},
-	)
-
-	// subscribe to all:
-	connection.WriteJSON(
-		map[string]interface{}{
-			"id":   messageID,
-			"type": "subscribe_events",
-		},
-	)
-	messageID++
-
-	// listen for messages from HA and publish them on the events channel:
-	go func(events chan string, connection *websocket.Conn) {
-		for {
-			events <- getMessage(connection)
-		}
-	}(events, connection)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
 
-	// listen for commands and send them to HA:
-	for {
-		command := <-commands
-		haCommand := map[string]interface{}{}
+	connection, _, err := dialer.Dial(haURL, nil)
+	if err != nil {
+		return nil, "", &ConnectionError{URL: haURL, Err: err}
+	}
 
-		if command.EntityID != "" {
-			haCommand["target"] = map[string]string{
-				"entity_id": command.EntityID,
-			}
+	version, err := authenticate(connection, config.Token)
+	if err != nil {
+		connection.Close()
+		var connectionError *ConnectionError
+		if errors.As(err, &connectionError) {
+			connectionError.URL = haURL
 		}
+		return nil, version, err
+	}
+	return connection, version, nil
+}
 
-		if command.Service != "" {
-			haCommand["service"] = command.Service
+// authenticate runs the handshake of the WebSocket API:
+// * the server greets us with auth_required (and its ha_version),
+// * we answer with our access token,
+// * the server replies with either auth_ok or auth_invalid.
+// It returns the version reported by the server.
+func authenticate(connection *websocket.Conn, token string) (string, error) {
+	var version string
+	state := awaitingAuthRequired
+
+	for state != authenticated {
+		message := AuthMessage{}
+		if err := connection.ReadJSON(&message); err != nil {
+			return version, &ConnectionError{Err: err}
 		}
 
-		if command.Domain {
-			haCommand["domain"] = strings.Split(command.EntityID, ".")[0]
+		switch state {
+		case awaitingAuthRequired:
+			if message.Type != "auth_required" {
+				return version, &ProtocolError{Expected: "auth_required", Got: message.Type}
+			}
+			version = message.Version
+			if !SupportedVersion(version) {
+				return version, &VersionError{Version: version}
+			}
+			err := connection.WriteJSON(
+				map[string]string{
+					"type":         "auth",
+					"access_token": token,
+				},
+			)
+			if err != nil {
+				return version, &ConnectionError{Err: err}
+			}
+			state = awaitingAuthResult
+		case awaitingAuthResult:
+			switch message.Type {
+			case "auth_ok":
+				state = authenticated
+			case "auth_invalid":
+				return version, &AuthError{Message: message.Message}
+			default:
+				return version, &ProtocolError{Expected: "auth_ok", Got: message.Type}
+			}
 		}
+	}
+	return version, nil
+}
 
-		haCommand["type"] = command.Type
-		haCommand["id"] = messageID
+// SupportedVersion reports whether a ha_version string
+// (e.g. 2022.5.0 or 2022.5.0b3) is at least MinVersion.
+func SupportedVersion(version string) bool {
+	if version == "" {
+		return false
+	}
+	have := parseVersion(version)
+	need := parseVersion(MinVersion)
 
-		connection.WriteJSON(haCommand)
-		messageID++
+	for i := range need {
+		if have[i] != need[i] {
+			return have[i] > need[i]
+		}
 	}
+	return true
 }
 
-// synchronous message fetching:
-func getMessage(connnection *websocket.Conn) string {
-	message := make(map[string]interface{})
-	connnection.ReadJSON(&message)
-	bytestring, _ := json.Marshal(message)
+// parseVersion turns the leading digits of the first
+// three dot separated version components into integers.
+func parseVersion(version string) [3]int {
+	var parsed [3]int
+	for i, component := range strings.SplitN(version, ".", 3) {
+		digits := strings.IndexFunc(component, func(r rune) bool {
+			return r < '0' || r > '9'
+		})
+		if digits != -1 {
+			component = component[:digits]
+		}
+		parsed[i], _ = strconv.Atoi(component)
+	}
+	return parsed
+}
 
-	var pretty bytes.Buffer
-	json.Indent(&pretty, bytestring, "", "  ")
-	return string(pretty.Bytes())
+// Domain returns the domain part of an entity id,
+// e.g. light for light.hue_go_1.
+func Domain(entityID string) string {
+	return strings.SplitN(entityID, ".", 2)[0]
 }
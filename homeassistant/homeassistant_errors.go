@@ -0,0 +1,99 @@
+package homeassistant
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConnectionError is returned if the server could not be reached
+// or the connection broke down. URL is only set for failed dials.
+type ConnectionError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.URL == "" {
+		return fmt.Sprintf("connection lost: %v", e.Err)
+	}
+	return fmt.Sprintf("server unreachable (%v): %v", e.URL, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError is returned if the connection settings are invalid.
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config [%v]: %v", e.Key, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned if Home Assistant rejected the access token.
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("authentication failed: %v", e.Message)
+}
+
+// VersionError is returned if the server runs a Home Assistant release
+// older than MinVersion (or does not report its version at all).
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	if e.Version == "" {
+		return "unsupported Home Assistant version: not reported by server"
+	}
+	return fmt.Sprintf(
+		"unsupported Home Assistant version %v (need %v or newer)",
+		e.Version,
+		MinVersion,
+	)
+}
+
+// ProtocolError is returned if the server sent a message
+// that is not valid in the current phase of the handshake.
+type ProtocolError struct {
+	Expected string
+	Got      string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf(
+		"protocol error: expected %v, got [%v]",
+		e.Expected,
+		e.Got,
+	)
+}
+
+// ErrNotConnected is returned by requests made while offline.
+var ErrNotConnected = errors.New("not connected to Home Assistant")
+
+// ErrDisconnected is returned by requests whose connection
+// broke down before their result arrived.
+var ErrDisconnected = errors.New("disconnected before result arrived")
+
+// ResultError is returned if HA answered a request with success:false.
+type ResultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
@@ -1,42 +1,143 @@
 package homeassistant
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 // Config for the connection:
+// * URL: base URL of HA, replaces Scheme and Server if set
+// * CAFile, CertFile, KeyFile, ServerName, InsecureSkipVerify: TLS settings
+// * EntityIDs: limits the state stream to these entities (empty for all)
 type Config struct {
-	Scheme string `json:"scheme"`
-	Server string `json:"server"`
-	Token  string `json:"token"`
+	Scheme             string   `json:"scheme"`
+	Server             string   `json:"server"`
+	URL                string   `json:"url"`
+	Token              string   `json:"token"`
+	CAFile             string   `json:"ca-file"`
+	CertFile           string   `json:"cert-file"`
+	KeyFile            string   `json:"key-file"`
+	ServerName         string   `json:"server-name"`
+	InsecureSkipVerify bool     `json:"insecure-skip-verify"`
+	EntityIDs          []string `json:"entity-ids"`
 }
 
-// Command that can be sent to the commands channel.
-type Command struct {
-	EntityID string
-	Service  string
-	Type     string
-	Domain   bool
+// ConnectionStatus describes the state of the supervised connection.
+type ConnectionStatus int
+
+// possible values of ConnectionStatus:
+const (
+	Connecting ConnectionStatus = iota
+	Online
+	Retrying
+	Failed
+)
+
+// ConnectionState is published whenever the connection changes:
+// * Version: ha_version of the server (Online only)
+// * Retry: delay until the next attempt (Retrying only)
+// * Err: why the last attempt failed (Retrying and Failed)
+type ConnectionState struct {
+	Status  ConnectionStatus
+	Version string
+	Retry   time.Duration
+	Err     error
+}
+
+func (s ConnectionState) String() string {
+	switch s.Status {
+	case Connecting:
+		return "connecting"
+	case Online:
+		return fmt.Sprintf("online (%v)", s.Version)
+	case Retrying:
+		return fmt.Sprintf("retrying in %v: %v", s.Retry.Round(time.Second), s.Err)
+	case Failed:
+		return fmt.Sprint(s.Err)
+	}
+	return "unknown"
+}
+
+// AuthMessage is sent by HA during the authentication phase.
+type AuthMessage struct {
+	Type    string `json:"type"`
+	Version string `json:"ha_version"`
+	Message string `json:"message"`
 }
 
 // Message is the top level JSON object of a HA WS response.
+// Result stays raw, its layout depends on the request.
 type Message struct {
-	Type   string   `json:"type"`
-	Result []Result `json:"result"`
-	Event  Event    `json:"event"`
+	ID      uint            `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Error   *ResultError    `json:"error"`
+	Event   Event           `json:"event"`
 }
 
-// State is attached to Result and Data.
+// State of an entity, part of get_states results and Data.
 type State struct {
-	State string `json:"state"`
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+	LastUpdated time.Time              `json:"last_updated"`
+	Context     Context                `json:"context"`
+}
+
+// FriendlyName returns the friendly_name attribute or the entity id.
+func (s State) FriendlyName() string {
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return s.EntityID
+}
+
+// Unit returns the unit_of_measurement attribute (if any).
+func (s State) Unit() string {
+	unit, _ := s.Attributes["unit_of_measurement"].(string)
+	return unit
 }
 
-// Result is an optional JSON object for Message.
+// Service describes a service of the get_services result.
+type Service struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Result of a successful call_service request.
 type Result struct {
-	State    string `json:"state"`
-	EntityID string `json:"entity_id"`
+	Context Context `json:"context"`
+}
+
+// Context identifies the origin of a state change or service call.
+type Context struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	UserID   string `json:"user_id"`
+}
+
+// Target selects what a service call acts upon.
+type Target struct {
+	EntityID []string `json:"entity_id,omitempty"`
+	DeviceID []string `json:"device_id,omitempty"`
+	AreaID   []string `json:"area_id,omitempty"`
+}
+
+// IsEmpty is true if nothing is targeted.
+func (t Target) IsEmpty() bool {
+	return len(t.EntityID) == 0 && len(t.DeviceID) == 0 && len(t.AreaID) == 0
 }
 
 // Event is an optional JSON object for Message.
 type Event struct {
-	Type string `json:"event_type"`
-	Data Data   `json:"data"`
+	Type      string    `json:"event_type"`
+	Data      Data      `json:"data"`
+	Origin    string    `json:"origin"`
+	TimeFired time.Time `json:"time_fired"`
+	Context   Context   `json:"context"`
 }
 
 // Data is part of Event responses.
@@ -44,5 +145,11 @@ type Data struct {
 	EntityID string `json:"entity_id"`
 	NewState State  `json:"new_state"`
 	OldState State  `json:"old_state"`
+}
+
+// Entity is a configured entity, it is attached as reference
+// to the TUI's tree nodes. Its state is kept in the Store.
+type Entity struct {
 	NickName string
+	EntityID string
 }
@@ -0,0 +1,688 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// handshake runs the server side of the authentication phase.
+func handshake(connection *websocket.Conn, version string, token string) bool {
+	connection.WriteJSON(map[string]string{
+		"type":       "auth_required",
+		"ha_version": version,
+	})
+
+	auth := map[string]string{}
+	if err := connection.ReadJSON(&auth); err != nil {
+		return false // client hung up (e.g. unsupported version).
+	}
+
+	if auth["access_token"] != token {
+		connection.WriteJSON(map[string]string{
+			"type":    "auth_invalid",
+			"message": "Invalid access token or password",
+		})
+		return false
+	}
+
+	connection.WriteJSON(map[string]string{
+		"type":       "auth_ok",
+		"ha_version": version,
+	})
+	return true
+}
+
+// newAuthServer starts a server that runs the server side of the
+// authentication handshake with the given version and token.
+func newAuthServer(t *testing.T, version string, token string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			connection, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer connection.Close()
+
+			handshake(connection, version, token)
+			connection.ReadMessage() // wait for the client to close.
+		}),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testConfig(server *httptest.Server, token string) Config {
+	return Config{
+		Scheme: "ws",
+		Server: strings.TrimPrefix(server.URL, "http://"),
+		Token:  token,
+	}
+}
+
+func TestDialAuthOK(t *testing.T) {
+	server := newAuthServer(t, "2022.5.0", "secret")
+
+	connection, version, err := Dial(testConfig(server, "secret"))
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	connection.Close()
+
+	expectedVersion := "2022.5.0"
+	if version != expectedVersion {
+		t.Errorf("version should be '%v', got '%v'", expectedVersion, version)
+	}
+}
+
+func TestDialAuthInvalid(t *testing.T) {
+	server := newAuthServer(t, "2022.5.0", "secret")
+
+	_, _, err := Dial(testConfig(server, "wrong"))
+	var authError *AuthError
+	if !errors.As(err, &authError) {
+		t.Fatalf("expected *AuthError, got '%v'", err)
+	}
+
+	expectedMessage := "Invalid access token or password"
+	if authError.Message != expectedMessage {
+		t.Errorf(
+			"authError.Message should be '%v', got '%v'",
+			expectedMessage,
+			authError.Message,
+		)
+	}
+}
+
+func TestDialUnsupportedVersion(t *testing.T) {
+	server := newAuthServer(t, "0.118.5", "secret")
+
+	_, _, err := Dial(testConfig(server, "secret"))
+	var versionError *VersionError
+	if !errors.As(err, &versionError) {
+		t.Fatalf("expected *VersionError, got '%v'", err)
+	}
+
+	expectedVersion := "0.118.5"
+	if versionError.Version != expectedVersion {
+		t.Errorf(
+			"versionError.Version should be '%v', got '%v'",
+			expectedVersion,
+			versionError.Version,
+		)
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	server := newAuthServer(t, "2022.5.0", "secret")
+	config := testConfig(server, "secret")
+	server.Close()
+
+	_, _, err := Dial(config)
+	var connectionError *ConnectionError
+	if !errors.As(err, &connectionError) {
+		t.Fatalf("expected *ConnectionError, got '%v'", err)
+	}
+}
+
+func TestSupportedVersion(t *testing.T) {
+	versions := map[string]bool{
+		"":                    false,
+		"0.118.5":             false,
+		"2021.2.3":            false,
+		"2021.3.0":            true,
+		"2021.12.10":          true,
+		"2022.5.0b3":          true,
+		"2022.6.0.dev2022050": true,
+	}
+
+	for version, expected := range versions {
+		if got := SupportedVersion(version); got != expected {
+			t.Errorf(
+				"SupportedVersion(%q) should be '%v', got '%v'",
+				version,
+				expected,
+				got,
+			)
+		}
+	}
+}
+
+// newSessionServer starts a server that authenticates every
+// client and then hands the connection over to session.
+func newSessionServer(
+	t *testing.T,
+	session func(connection *websocket.Conn),
+) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			connection, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer connection.Close()
+			if handshake(connection, "2022.5.0", "secret") {
+				session(connection)
+			}
+		}),
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runClient connects a client and waits until it is online.
+func runClient(t *testing.T, server *httptest.Server) *Client {
+	return runClientWith(t, NewClient(testConfig(server, "secret")))
+}
+
+// runClientWith runs a prepared client and waits until it is online.
+func runClientWith(t *testing.T, client *Client) *Client {
+	go client.Run()
+	go func() {
+		for range client.Events() {
+		}
+	}()
+
+	for state := range client.States() {
+		if state.Status == Online {
+			break
+		}
+		if state.Status != Connecting {
+			t.Fatalf("could not connect: %v", state)
+		}
+	}
+	go func() {
+		for range client.States() {
+		}
+	}()
+	return client
+}
+
+// answerCalls replies to every call_service request with reply
+// and publishes the requests on the returned channel.
+func answerCalls(
+	reply func(id float64) map[string]interface{},
+) (func(connection *websocket.Conn), chan map[string]interface{}) {
+	requests := make(chan map[string]interface{}, 8)
+	return func(connection *websocket.Conn) {
+		for {
+			command := map[string]interface{}{}
+			if err := connection.ReadJSON(&command); err != nil {
+				return
+			}
+			if command["type"] != "call_service" {
+				continue
+			}
+			requests <- command
+			if answer := reply(command["id"].(float64)); answer != nil {
+				connection.WriteJSON(answer)
+			}
+		}
+	}, requests
+}
+
+func TestClientReconnects(t *testing.T) {
+	reconnectBackoff.Min = time.Millisecond
+	reconnectBackoff.Max = 10 * time.Millisecond
+
+	var mutex sync.Mutex
+	var sessions []string
+	var ids []float64
+	received := make(chan []string, 1)
+	server := newSessionServer(t, func(connection *websocket.Conn) {
+		// record the initial command of every session:
+		var types []string
+		for len(types) < 1 {
+			command := map[string]interface{}{}
+			if err := connection.ReadJSON(&command); err != nil {
+				return
+			}
+			types = append(types, command["type"].(string))
+			id, _ := command["id"].(float64)
+			mutex.Lock()
+			ids = append(ids, id)
+			mutex.Unlock()
+		}
+
+		mutex.Lock()
+		sessions = append(sessions, types...)
+		complete := len(sessions) == 2
+		mutex.Unlock()
+
+		if complete {
+			received <- sessions
+			connection.ReadMessage() // keep the second session open.
+		}
+		// returning drops the first session.
+	})
+
+	client := NewClient(testConfig(server, "secret"))
+	go client.Run()
+
+	var statuses []ConnectionStatus
+	for len(statuses) < 5 {
+		select {
+		case state := <-client.States():
+			statuses = append(statuses, state.Status)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out, statuses so far: %v", statuses)
+		}
+	}
+
+	expectedStatuses := []ConnectionStatus{
+		Connecting, Online, Retrying, Connecting, Online,
+	}
+	for i := range expectedStatuses {
+		if statuses[i] != expectedStatuses[i] {
+			t.Fatalf("statuses should be %v, got %v", expectedStatuses, statuses)
+		}
+	}
+
+	expectedSessions := "subscribe_entities subscribe_entities"
+	if got := strings.Join(<-received, " "); got != expectedSessions {
+		t.Errorf("commands should be '%v', got '%v'", expectedSessions, got)
+	}
+
+	// ids are not reused, late results of the first session cannot match:
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(ids) != 2 || ids[1] <= ids[0] {
+		t.Errorf("ids should keep increasing, got '%v'", ids)
+	}
+}
+
+func TestClientReconnectsWithoutSubscription(t *testing.T) {
+	// a server that keeps the connection but fails every command:
+	server := newSessionServer(t, func(connection *websocket.Conn) {
+		for {
+			command := map[string]interface{}{}
+			if err := connection.ReadJSON(&command); err != nil {
+				return
+			}
+			connection.WriteJSON(map[string]interface{}{
+				"id":      command["id"],
+				"type":    "result",
+				"success": false,
+				"error":   map[string]string{"code": "unknown_command"},
+			})
+		}
+	})
+	client := NewClient(testConfig(server, "secret"))
+	go client.Run()
+
+	var states []ConnectionState
+	for len(states) < 3 {
+		select {
+		case state := <-client.States():
+			states = append(states, state)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out, states so far: %v", states)
+		}
+	}
+	if states[2].Status != Retrying || !strings.Contains(fmt.Sprint(states[2].Err), "subscribing failed") {
+		t.Errorf("the client should retry after subscribing failed, got '%v'", states[2])
+	}
+}
+
+func TestClientRunFailsOnInvalidToken(t *testing.T) {
+	server := newAuthServer(t, "2022.5.0", "secret")
+	client := NewClient(testConfig(server, "wrong"))
+
+	errs := make(chan error)
+	go func() {
+		errs <- client.Run()
+	}()
+
+	<-client.States() // connecting.
+	if state := <-client.States(); state.Status != Failed {
+		t.Errorf("last state should be Failed, got '%v'", state)
+	}
+
+	var authError *AuthError
+	if err := <-errs; !errors.As(err, &authError) {
+		t.Fatalf("expected *AuthError, got '%v'", err)
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	session, requests := answerCalls(func(id float64) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      id,
+			"type":    "result",
+			"success": true,
+			"result":  map[string]interface{}{"context": map[string]string{"id": "ctx"}},
+		}
+	})
+	client := runClient(t, newSessionServer(t, session))
+
+	result, err := client.Call(
+		context.Background(),
+		"light",
+		"turn_on",
+		Target{EntityID: []string{"light.hue_go_1"}},
+		map[string]interface{}{"brightness_pct": 50},
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+
+	expectedContext := "ctx"
+	if result.Context.ID != expectedContext {
+		t.Errorf(
+			"result.Context.ID should be '%v', got '%v'",
+			expectedContext,
+			result.Context.ID,
+		)
+	}
+
+	request := <-requests
+	delete(request, "id")
+	expectedRequest := `{"domain":"light",` +
+		`"service":"turn_on","service_data":{"brightness_pct":50},` +
+		`"target":{"entity_id":["light.hue_go_1"]},"type":"call_service"}`
+	got, _ := json.Marshal(request)
+	if string(got) != expectedRequest {
+		t.Errorf("request should be '%v', got '%v'", expectedRequest, string(got))
+	}
+}
+
+func TestClientCallError(t *testing.T) {
+	session, _ := answerCalls(func(id float64) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      id,
+			"type":    "result",
+			"success": false,
+			"error": map[string]string{
+				"code":    "not_found",
+				"message": "Service not found.",
+			},
+		}
+	})
+	client := runClient(t, newSessionServer(t, session))
+
+	_, err := client.Call(context.Background(), "light", "blink", Target{}, nil)
+	var resultError *ResultError
+	if !errors.As(err, &resultError) {
+		t.Fatalf("expected *ResultError, got '%v'", err)
+	}
+
+	expectedError := "not_found: Service not found."
+	if err.Error() != expectedError {
+		t.Errorf("expected error: '%v', got '%v'", expectedError, err)
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	session, _ := answerCalls(func(id float64) map[string]interface{} {
+		return nil // never answer.
+	})
+	client := runClient(t, newSessionServer(t, session))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Call(ctx, "light", "toggle", Target{}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got '%v'", err)
+	}
+}
+
+func TestClientNotConnected(t *testing.T) {
+	client := NewClient(Config{})
+
+	_, err := client.Call(context.Background(), "light", "toggle", Target{}, nil)
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got '%v'", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := Backoff{
+		Min:    time.Second,
+		Max:    8 * time.Second,
+		Factor: 2,
+	}
+
+	expectedDelays := []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		8 * time.Second,
+	}
+	for _, expected := range expectedDelays {
+		if delay := backoff.Next(); delay != expected {
+			t.Errorf("delay should be '%v', got '%v'", expected, delay)
+		}
+	}
+
+	backoff.Reset()
+	if delay := backoff.Next(); delay != time.Second {
+		t.Errorf("delay after Reset should be '%v', got '%v'", time.Second, delay)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	backoff := Backoff{
+		Min:    time.Second,
+		Max:    time.Second,
+		Factor: 2,
+		Jitter: 0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := backoff.Next()
+		if delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("delay '%v' is outside of the jitter range", delay)
+		}
+	}
+}
+
+func TestStateChangedDecoding(t *testing.T) {
+	message := Message{}
+	err := json.Unmarshal([]byte(`{
+		"id": 1,
+		"type": "event",
+		"event": {
+			"event_type": "state_changed",
+			"data": {
+				"entity_id": "sensor.temperature",
+				"old_state": null,
+				"new_state": {
+					"entity_id": "sensor.temperature",
+					"state": "21.5",
+					"attributes": {
+						"unit_of_measurement": "°C",
+						"friendly_name": "Living Room"
+					},
+					"last_changed": "2022-05-05T10:00:00.000000+00:00",
+					"last_updated": "2022-05-05T10:00:01.500000+00:00",
+					"context": {
+						"id": "01G2BFX",
+						"parent_id": null,
+						"user_id": "a1b2c3"
+					}
+				}
+			},
+			"origin": "LOCAL",
+			"time_fired": "2022-05-05T10:00:01.500000+00:00",
+			"context": {"id": "01G2BFX", "parent_id": null, "user_id": "a1b2c3"}
+		}
+	}`), &message)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+
+	state := message.Event.Data.NewState
+	if state.FriendlyName() != "Living Room" {
+		t.Errorf("FriendlyName() should be 'Living Room', got '%v'", state.FriendlyName())
+	}
+	if state.Unit() != "°C" {
+		t.Errorf("Unit() should be '°C', got '%v'", state.Unit())
+	}
+	if state.Context.UserID != "a1b2c3" {
+		t.Errorf("Context.UserID should be 'a1b2c3', got '%v'", state.Context.UserID)
+	}
+
+	expectedUpdate := 1500 * time.Millisecond
+	if update := state.LastUpdated.Sub(state.LastChanged); update != expectedUpdate {
+		t.Errorf(
+			"LastUpdated should be %v after LastChanged, got %v",
+			expectedUpdate,
+			update,
+		)
+	}
+
+	if message.Event.Data.OldState.EntityID != "" {
+		t.Errorf("OldState should be empty, got '%+v'", message.Event.Data.OldState)
+	}
+	if (State{EntityID: "light.x"}).FriendlyName() != "light.x" {
+		t.Errorf("FriendlyName() should fall back to the entity id")
+	}
+}
+
+func TestClientSubscribeEntities(t *testing.T) {
+	subscriptions := make(chan map[string]interface{}, 1)
+	server := newSessionServer(t, func(connection *websocket.Conn) {
+		command := map[string]interface{}{}
+		if err := connection.ReadJSON(&command); err != nil {
+			return
+		}
+		subscriptions <- command
+
+		id := command["id"]
+		connection.WriteJSON(map[string]interface{}{
+			"id": id, "type": "result", "success": true, "result": nil,
+		})
+		for _, event := range []string{
+			`{"a": {
+				"switch.fan": {"s": "off", "a": {}, "c": "01G2", "lu": 1651744800.5},
+				"light.hue_go_1": {"s": "on", "a": {"brightness": 255}, "c": "01G3", "lu": 1651744800}
+			}}`,
+			`{"c": {"light.hue_go_1": {
+				"+": {"s": "off", "lc": 1651744900, "c": {"id": "01G4", "user_id": "u1"}},
+				"-": {"a": ["brightness"]}
+			}}}`,
+			`{"r": ["switch.fan"]}`,
+		} {
+			connection.WriteMessage(
+				websocket.TextMessage,
+				[]byte(fmt.Sprintf(`{"id": %v, "type": "event", "event": %v}`, id, event)),
+			)
+		}
+		connection.ReadMessage()
+	})
+
+	config := testConfig(server, "secret")
+	config.EntityIDs = []string{"switch.fan", "light.hue_go_1"}
+	client := NewClient(config)
+	removed := make(chan string, 1)
+	client.Store().Subscribe(func(change Change) {
+		if change.Removed() {
+			removed <- change.EntityID
+		}
+	})
+	runClientWith(t, client)
+
+	subscription := <-subscriptions
+	expectedEntities := "[switch.fan light.hue_go_1]"
+	if got := fmt.Sprint(subscription["entity_ids"]); got != expectedEntities {
+		t.Errorf("entity_ids should be '%v', got '%v'", expectedEntities, got)
+	}
+
+	select {
+	case entityID := <-removed:
+		if entityID != "switch.fan" {
+			t.Errorf("switch.fan should be removed, got '%v'", entityID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the removal")
+	}
+
+	light, _ := client.Store().Get("light.hue_go_1")
+	if light.State != "off" || len(light.Attributes) != 0 {
+		t.Errorf("light should be off without attributes, got '%+v'", light)
+	}
+	if light.Context.ID != "01G4" || light.Context.UserID != "u1" {
+		t.Errorf("context should be updated, got '%+v'", light.Context)
+	}
+	if !light.LastUpdated.Equal(time.Unix(1651744900, 0)) {
+		t.Errorf("last_updated should follow last_changed, got '%v'", light.LastUpdated)
+	}
+}
+
+func TestClientFallsBackToEvents(t *testing.T) {
+	server := newSessionServer(t, func(connection *websocket.Conn) {
+		for {
+			command := map[string]interface{}{}
+			if err := connection.ReadJSON(&command); err != nil {
+				return
+			}
+			switch command["type"] {
+			case "subscribe_entities": // unknown to old servers.
+				connection.WriteJSON(map[string]interface{}{
+					"id":      command["id"],
+					"type":    "result",
+					"success": false,
+					"error": map[string]string{
+						"code":    "unknown_command",
+						"message": "Unknown command.",
+					},
+				})
+			case "get_states":
+				connection.WriteJSON(map[string]interface{}{
+					"id":      command["id"],
+					"type":    "result",
+					"success": true,
+					"result": []map[string]string{
+						{"entity_id": "switch.fan", "state": "off"},
+					},
+				})
+				connection.WriteJSON(map[string]interface{}{
+					"id":   1,
+					"type": "event",
+					"event": map[string]interface{}{
+						"event_type": "state_changed",
+						"data": map[string]interface{}{
+							"entity_id": "switch.fan",
+							"new_state": map[string]string{
+								"entity_id": "switch.fan",
+								"state":     "on",
+							},
+						},
+					},
+				})
+			}
+		}
+	})
+
+	client := NewClient(testConfig(server, "secret"))
+	changes := make(chan Change, 2)
+	client.Store().Subscribe(func(change Change) {
+		changes <- change
+	})
+	runClientWith(t, client)
+
+	for _, expected := range []string{"off", "on"} {
+		select {
+		case change := <-changes:
+			if change.New.State != expected {
+				t.Errorf("state should be '%v', got '%v'", expected, change.New.State)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for state '%v'", expected)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package homeassistant
+
+import (
+	"math"
+)
+
+// default color temperature range of lights that do not report one:
+const (
+	defaultMinMireds = 153 // 6500 K.
+	defaultMaxMireds = 500 // 2000 K.
+)
+
+// Light holds what a light control panel shows of a light:
+// * Brightness and Saturation in percent, Hue in degrees
+// * ColorTemp (and its range) in mireds
+// * Effects from the effect_list (the current one is Effect)
+// * Supports* tell which of them the light can change
+type Light struct {
+	On                bool
+	Brightness        float64
+	ColorTemp         float64
+	MinMireds         float64
+	MaxMireds         float64
+	Hue               float64
+	Saturation        float64
+	Effect            string
+	Effects           []string
+	ColorMode         string
+	SupportsDimming   bool
+	SupportsColorTemp bool
+	SupportsColor     bool
+}
+
+// NewLight reads the attributes of a light. Lights that are off report
+// no brightness or color, those fall back to 0 and the middle of the
+// color temperature range. What a light supports is read from its
+// supported_color_modes, or guessed from its attributes without them.
+func NewLight(state State) Light {
+	light := Light{
+		On:        state.State == "on",
+		MinMireds: defaultMinMireds,
+		MaxMireds: defaultMaxMireds,
+	}
+	attributes := state.Attributes
+
+	if brightness, ok := attributes["brightness"].(float64); ok {
+		light.Brightness = math.Round(brightness / 255 * 100)
+	}
+	if min, ok := attributes["min_mireds"].(float64); ok {
+		light.MinMireds = min
+	}
+	if max, ok := attributes["max_mireds"].(float64); ok {
+		light.MaxMireds = max
+	}
+	light.ColorTemp = math.Round((light.MinMireds + light.MaxMireds) / 2)
+	if colorTemp, ok := attributes["color_temp"].(float64); ok {
+		light.ColorTemp = colorTemp
+	}
+	light.Hue, light.Saturation = hsColor(state, 0)
+	light.Effect, _ = attributes["effect"].(string)
+	light.Effects = stringList(attributes["effect_list"])
+	light.ColorMode, _ = attributes["color_mode"].(string)
+
+	modes, ok := attributes["supported_color_modes"].([]interface{})
+	if !ok {
+		_, light.SupportsDimming = attributes["brightness"]
+		_, light.SupportsColorTemp = attributes["min_mireds"]
+		_, light.SupportsColor = attributes["hs_color"]
+		return light
+	}
+	for _, mode := range modes {
+		switch mode {
+		case "onoff":
+		case "color_temp":
+			light.SupportsColorTemp = true
+			light.SupportsDimming = true
+		case "hs", "xy", "rgb", "rgbw", "rgbww":
+			light.SupportsColor = true
+			light.SupportsDimming = true
+		default: // brightness and white.
+			light.SupportsDimming = true
+		}
+	}
+	return light
+}
+
+// HSVToRGB converts a color (hue in degrees, saturation
+// and value in percent) to red, green and blue (0-255).
+func HSVToRGB(hue, saturation, value float64) (int32, int32, int32) {
+	h := math.Mod(hue, 360) / 60
+	if h < 0 {
+		h += 6
+	}
+	s := clamp(saturation, 0, 100) / 100
+	v := clamp(value, 0, 100) / 100
+
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	m := v - c
+	return channel(r + m), channel(g + m), channel(b + m)
+}
+
+// MiredsToRGB approximates the color of white light of a color
+// temperature (in mireds), following Tanner Helland's fit of
+// the blackbody spectrum.
+func MiredsToRGB(mireds float64) (int32, int32, int32) {
+	if mireds <= 0 {
+		return 255, 255, 255
+	}
+	kelvin := clamp(1e6/mireds, 1000, 40000) / 100
+
+	r, g, b := 255.0, 255.0, 255.0
+	if kelvin <= 66 {
+		g = 99.4708025861*math.Log(kelvin) - 161.1195681661
+		b = 0
+		if kelvin > 19 {
+			b = 138.5177312231*math.Log(kelvin-10) - 305.0447927307
+		}
+	} else {
+		r = 329.698727446 * math.Pow(kelvin-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(kelvin-60, -0.0755148492)
+	}
+	return channel(r / 255), channel(g / 255), channel(b / 255)
+}
+
+// channel converts a color channel from 0-1 to 0-255.
+func channel(value float64) int32 {
+	return int32(math.Round(clamp(value, 0, 1) * 255))
+}
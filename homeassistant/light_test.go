@@ -0,0 +1,98 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewLight(t *testing.T) {
+	var state State
+	json.Unmarshal([]byte(`{
+		"entity_id": "light.hue_go_1",
+		"state": "on",
+		"attributes": {
+			"brightness": 128,
+			"color_temp": 300,
+			"min_mireds": 150,
+			"max_mireds": 450,
+			"hs_color": [30.0, 80.0],
+			"effect": "colorloop",
+			"effect_list": ["none", "colorloop"],
+			"color_mode": "hs",
+			"supported_color_modes": ["color_temp", "hs"]
+		}
+	}`), &state)
+
+	expected := Light{
+		On:                true,
+		Brightness:        50,
+		ColorTemp:         300,
+		MinMireds:         150,
+		MaxMireds:         450,
+		Hue:               30,
+		Saturation:        80,
+		Effect:            "colorloop",
+		Effects:           []string{"none", "colorloop"},
+		ColorMode:         "hs",
+		SupportsDimming:   true,
+		SupportsColorTemp: true,
+		SupportsColor:     true,
+	}
+	if got := NewLight(state); !reflect.DeepEqual(got, expected) {
+		t.Errorf("light should be '%+v', got '%+v'", expected, got)
+	}
+}
+
+func TestNewLightOff(t *testing.T) {
+	var state State
+	json.Unmarshal([]byte(`{
+		"entity_id": "light.plug",
+		"state": "off",
+		"attributes": {"supported_color_modes": ["onoff"]}
+	}`), &state)
+
+	light := NewLight(state)
+	if light.On || light.Brightness != 0 || light.ColorTemp != 327 {
+		t.Errorf("off light should fall back to 0%% and 327 mireds, got '%+v'", light)
+	}
+	if light.SupportsDimming || light.SupportsColorTemp || light.SupportsColor {
+		t.Errorf("onoff light should support nothing, got '%+v'", light)
+	}
+
+	// without supported_color_modes the attributes tell:
+	state.Attributes = map[string]interface{}{"brightness": 255.0}
+	light = NewLight(state)
+	if !light.SupportsDimming || light.SupportsColor {
+		t.Errorf("light with brightness should only support dimming, got '%+v'", light)
+	}
+}
+
+func TestHSVToRGB(t *testing.T) {
+	colors := map[[3]float64][3]int32{
+		{0, 100, 100}:   {255, 0, 0},
+		{120, 100, 100}: {0, 255, 0},
+		{240, 100, 100}: {0, 0, 255},
+		{360, 100, 100}: {255, 0, 0},
+		{60, 50, 100}:   {255, 255, 128},
+		{0, 0, 50}:      {128, 128, 128},
+		{200, 100, 0}:   {0, 0, 0},
+	}
+	for hsv, expected := range colors {
+		r, g, b := HSVToRGB(hsv[0], hsv[1], hsv[2])
+		if got := [3]int32{r, g, b}; got != expected {
+			t.Errorf("%v should be '%v', got '%v'", hsv, expected, got)
+		}
+	}
+}
+
+func TestMiredsToRGB(t *testing.T) {
+	warm, _, warmBlue := MiredsToRGB(500) // 2000 K.
+	_, _, coldBlue := MiredsToRGB(153)    // 6500 K.
+	if warm != 255 || warmBlue >= coldBlue {
+		t.Errorf("2000 K should be red and less blue than 6500 K, got '%v' and '%v'", warm, warmBlue)
+	}
+	if r, g, b := MiredsToRGB(0); r != 255 || g != 255 || b != 255 {
+		t.Errorf("0 mireds should be white, got '%v %v %v'", r, g, b)
+	}
+}
@@ -0,0 +1,61 @@
+package homeassistant
+
+import (
+	"math"
+	"time"
+)
+
+// MediaPlayer holds what a media control panel shows of a player:
+// * Title, Artist and Album of what is playing
+// * Position and Duration in seconds (HasDuration if there is one)
+// * Volume in percent (HasVolume if the player reports it)
+// * Source with its options, Shuffle and Repeat (Has* if reported)
+type MediaPlayer struct {
+	State       string
+	Title       string
+	Artist      string
+	Album       string
+	Position    float64
+	Duration    float64
+	HasDuration bool
+	Volume      float64
+	HasVolume   bool
+	Muted       bool
+	Source      string
+	Sources     []string
+	Shuffle     bool
+	HasShuffle  bool
+	Repeat      string
+}
+
+// NewMediaPlayer reads the attributes of a media player. HA only
+// updates media_position now and then, so while playing the time
+// since media_position_updated_at is added (up to the duration).
+func NewMediaPlayer(state State, now time.Time) MediaPlayer {
+	attributes := state.Attributes
+	player := MediaPlayer{State: state.State}
+	player.Title, _ = attributes["media_title"].(string)
+	player.Artist, _ = attributes["media_artist"].(string)
+	player.Album, _ = attributes["media_album_name"].(string)
+
+	player.Duration, player.HasDuration = attributes["media_duration"].(float64)
+	player.Position, _ = attributes["media_position"].(float64)
+	updated, _ := attributes["media_position_updated_at"].(string)
+	if at, err := time.Parse(time.RFC3339, updated); err == nil && state.State == "playing" {
+		player.Position += now.Sub(at).Seconds()
+	}
+	player.Position = math.Floor(clamp(player.Position, 0, math.Inf(1)))
+	if player.HasDuration {
+		player.Position = math.Min(player.Position, player.Duration)
+	}
+
+	if volume, ok := attributes["volume_level"].(float64); ok {
+		player.Volume, player.HasVolume = math.Round(volume*100), true
+	}
+	player.Muted, _ = attributes["is_volume_muted"].(bool)
+	player.Source, _ = attributes["source"].(string)
+	player.Sources = stringList(attributes["source_list"])
+	player.Shuffle, player.HasShuffle = attributes["shuffle"].(bool)
+	player.Repeat, _ = attributes["repeat"].(string)
+	return player
+}
@@ -0,0 +1,60 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewMediaPlayer(t *testing.T) {
+	var state State
+	json.Unmarshal([]byte(`{
+		"entity_id": "media_player.kitchen",
+		"state": "playing",
+		"attributes": {
+			"media_title": "So What",
+			"media_artist": "Miles Davis",
+			"media_album_name": "Kind of Blue",
+			"media_duration": 562,
+			"media_position": 100,
+			"media_position_updated_at": "2022-03-07T12:00:00+00:00",
+			"volume_level": 0.35,
+			"is_volume_muted": false,
+			"source": "Spotify",
+			"source_list": ["Spotify", "TV"],
+			"shuffle": true,
+			"repeat": "all"
+		}
+	}`), &state)
+	now := time.Date(2022, 3, 7, 12, 0, 30, 500, time.UTC)
+
+	expected := MediaPlayer{
+		State:       "playing",
+		Title:       "So What",
+		Artist:      "Miles Davis",
+		Album:       "Kind of Blue",
+		Position:    130, // 30s after the update.
+		Duration:    562,
+		HasDuration: true,
+		Volume:      35,
+		HasVolume:   true,
+		Source:      "Spotify",
+		Sources:     []string{"Spotify", "TV"},
+		Shuffle:     true,
+		HasShuffle:  true,
+		Repeat:      "all",
+	}
+	if got := NewMediaPlayer(state, now); !reflect.DeepEqual(got, expected) {
+		t.Errorf("player should be '%+v', got '%+v'", expected, got)
+	}
+
+	// the position stops at the duration, and while paused:
+	if got := NewMediaPlayer(state, now.Add(time.Hour)).Position; got != 562 {
+		t.Errorf("position should stop at '562', got '%v'", got)
+	}
+	state.State = "paused"
+	if got := NewMediaPlayer(state, now).Position; got != 100 {
+		t.Errorf("paused position should be '100', got '%v'", got)
+	}
+}
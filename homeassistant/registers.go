@@ -0,0 +1,145 @@
+package homeassistant
+
+import (
+	"fmt"
+	"sync"
+)
+
+// UnnamedRegister is used if no register was named, like "" in vi.
+const UnnamedRegister rune = '"'
+
+// registerAttributes lists what copy:all captures, in paste order.
+var registerAttributes = []string{
+	"brightness",
+	"hue",
+	"saturation",
+	"color_temp",
+	"effect",
+}
+
+// Registers hold copied entity attributes, just like vi's registers.
+// Every copy also fills the unnamed register.
+type Registers struct {
+	mutex  sync.Mutex
+	values map[rune]map[string]interface{}
+}
+
+// NewRegisters creates empty registers.
+func NewRegisters() *Registers {
+	return &Registers{values: map[rune]map[string]interface{}{}}
+}
+
+// Copy stores an attribute of the state in a register:
+// * all: the on/off state and every light attribute that is set
+// * brightness, hue, saturation, color_temp, effect: just this one
+func (r *Registers) Copy(register rune, attribute string, state State) error {
+	values := map[string]interface{}{}
+	hue, saturation, hasColor := stateColor(state)
+
+	read := func(attribute string) (interface{}, bool) {
+		switch attribute {
+		case "hue":
+			return hue, hasColor
+		case "saturation":
+			return saturation, hasColor
+		}
+		value, ok := state.Attributes[attribute]
+		return value, ok && value != nil
+	}
+
+	if attribute == "all" {
+		if state.State == "" {
+			return fmt.Errorf("%v has no state yet", state.EntityID)
+		}
+		values["state"] = state.State
+		for _, attribute := range registerAttributes {
+			if value, ok := read(attribute); ok {
+				values[attribute] = value
+			}
+		}
+	} else if value, ok := read(attribute); ok {
+		values[attribute] = value
+	} else {
+		return fmt.Errorf("%v has no %v", state.EntityID, attribute)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[register] = values
+	r.values[UnnamedRegister] = values
+	return nil
+}
+
+// Paste creates a service call that applies an attribute
+// (or all of them) from a register to the entity of the state.
+func (r *Registers) Paste(
+	register rune,
+	attribute string,
+	state State,
+) (ServiceCall, error) {
+	call := ServiceCall{
+		Domain:  Domain(state.EntityID),
+		Service: "turn_on",
+		Target:  Target{EntityID: []string{state.EntityID}},
+		Data:    map[string]interface{}{},
+	}
+
+	r.mutex.Lock()
+	values, ok := r.values[register]
+	r.mutex.Unlock()
+
+	if state.EntityID == "" {
+		return call, fmt.Errorf("no entity selected")
+	}
+	if !ok {
+		return call, fmt.Errorf("register [%c] is empty", register)
+	}
+
+	attributes := []string{attribute}
+	if attribute == "all" {
+		if values["state"] == "off" {
+			call.Service = "turn_off"
+			return call, nil
+		}
+		attributes = registerAttributes
+	} else if _, ok := values[attribute]; !ok {
+		return call, fmt.Errorf("register [%c] holds no %v", register, attribute)
+	}
+
+	if call.Domain != "light" {
+		if attribute == "all" {
+			return call, nil // only the power state applies.
+		}
+		return call, fmt.Errorf("paste:%v is only supported for lights", attribute)
+	}
+
+	hue, saturation, _ := stateColor(state)
+	for _, attribute := range attributes {
+		value, ok := values[attribute]
+		if !ok {
+			continue
+		}
+		switch attribute {
+		case "hue":
+			hue = value.(float64)
+			call.Data["hs_color"] = []float64{hue, saturation}
+		case "saturation":
+			saturation = value.(float64)
+			call.Data["hs_color"] = []float64{hue, saturation}
+		case "color_temp":
+			if _, ok := call.Data["hs_color"]; !ok {
+				call.Data["color_temp"] = value
+			}
+		default:
+			call.Data[attribute] = value
+		}
+	}
+	return call, nil
+}
+
+// stateColor returns hue and saturation of a light and whether it has any.
+func stateColor(state State) (float64, float64, bool) {
+	_, hasColor := state.Attributes["hs_color"].([]interface{})
+	hue, saturation := hsColor(state, 100)
+	return hue, saturation, hasColor
+}
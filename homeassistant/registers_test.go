@@ -0,0 +1,92 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistersCopyPasteAll(t *testing.T) {
+	registers := NewRegisters()
+	source := testLight()
+	source.Attributes["brightness"] = 128.0
+	source.Attributes["effect"] = "none"
+
+	if err := registers.Copy('a', "all", source); err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+
+	target := State{EntityID: "light.bedroom", State: "off"}
+	for _, register := range []rune{'a', UnnamedRegister} {
+		call, err := registers.Paste(register, "all", target)
+		if err != nil {
+			t.Fatalf("got unexpected error: '%v'", err)
+		}
+
+		expectedData := `{"brightness":128,"effect":"none","hs_color":[30,80]}`
+		data, _ := json.Marshal(call.Data)
+		if string(data) != expectedData {
+			t.Errorf("data should be '%v', got '%s'", expectedData, data)
+		}
+		if call.Service != "turn_on" || call.Target.EntityID[0] != "light.bedroom" {
+			t.Errorf("wrong call: %+v", call)
+		}
+	}
+}
+
+func TestRegistersPasteHueKeepsSaturation(t *testing.T) {
+	registers := NewRegisters()
+	registers.Copy('b', "hue", testLight())
+
+	target := testLight()
+	target.EntityID = "light.bedroom"
+	target.Attributes["hs_color"] = []interface{}{200.0, 40.0}
+
+	call, err := registers.Paste('b', "hue", target)
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+
+	expectedData := `{"hs_color":[30,40]}`
+	data, _ := json.Marshal(call.Data)
+	if string(data) != expectedData {
+		t.Errorf("data should be '%v', got '%s'", expectedData, data)
+	}
+}
+
+func TestRegistersPasteOff(t *testing.T) {
+	registers := NewRegisters()
+	registers.Copy('a', "all", State{EntityID: "switch.fan", State: "off"})
+
+	call, err := registers.Paste('a', "all", testLight())
+	if err != nil {
+		t.Fatalf("got unexpected error: '%v'", err)
+	}
+	if call.Service != "turn_off" {
+		t.Errorf("call.Service should be 'turn_off', got '%v'", call.Service)
+	}
+}
+
+func TestRegistersErrors(t *testing.T) {
+	registers := NewRegisters()
+	fan := State{EntityID: "switch.fan", State: "on"}
+
+	if err := registers.Copy('a', "brightness", fan); err == nil ||
+		err.Error() != "switch.fan has no brightness" {
+		t.Errorf("unexpected error: '%v'", err)
+	}
+
+	if _, err := registers.Paste('z', "all", fan); err == nil ||
+		err.Error() != "register [z] is empty" {
+		t.Errorf("unexpected error: '%v'", err)
+	}
+
+	registers.Copy('a', "hue", testLight())
+	if _, err := registers.Paste('a', "effect", testLight()); err == nil ||
+		err.Error() != "register [a] holds no effect" {
+		t.Errorf("unexpected error: '%v'", err)
+	}
+	if _, err := registers.Paste('a', "hue", fan); err == nil ||
+		err.Error() != "paste:hue is only supported for lights" {
+		t.Errorf("unexpected error: '%v'", err)
+	}
+}
@@ -0,0 +1,141 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Area is an entry of the area registry (e.g. a room).
+type Area struct {
+	AreaID string `json:"area_id"`
+	Name   string `json:"name"`
+}
+
+// Device is an entry of the device registry.
+// NameByUser is set if the device was renamed in HA.
+type Device struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	NameByUser   string `json:"name_by_user"`
+	AreaID       string `json:"area_id"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+}
+
+// DisplayName is the name a device is shown with in HA.
+func (d Device) DisplayName() string {
+	if d.NameByUser != "" {
+		return d.NameByUser
+	}
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.ID
+}
+
+// RegistryEntry is an entry of the entity registry.
+// Its AreaID overrides the area of its device.
+type RegistryEntry struct {
+	EntityID   string `json:"entity_id"`
+	Name       string `json:"name"`
+	DeviceID   string `json:"device_id"`
+	AreaID     string `json:"area_id"`
+	Platform   string `json:"platform"`
+	DisabledBy string `json:"disabled_by"`
+}
+
+// Registry combines the area, device and entity registries.
+type Registry struct {
+	Areas    map[string]Area          // by area_id.
+	Devices  map[string]Device        // by id.
+	Entities map[string]RegistryEntry // by entity_id.
+}
+
+// Area returns the area of an entity: its own or that of its device.
+func (r Registry) Area(entityID string) (Area, bool) {
+	entry, ok := r.Entities[entityID]
+	if !ok {
+		return Area{}, false
+	}
+	areaID := entry.AreaID
+	if areaID == "" {
+		areaID = r.Devices[entry.DeviceID].AreaID
+	}
+	area, ok := r.Areas[areaID]
+	return area, ok
+}
+
+// Device returns the device an entity belongs to.
+func (r Registry) Device(entityID string) (Device, bool) {
+	entry, ok := r.Entities[entityID]
+	if !ok || entry.DeviceID == "" {
+		return Device{}, false
+	}
+	device, ok := r.Devices[entry.DeviceID]
+	return device, ok
+}
+
+// Areas fetches the area registry (config/area_registry/list).
+func (c *Client) Areas(ctx context.Context) ([]Area, error) {
+	var areas []Area
+	err := c.list(ctx, "config/area_registry/list", &areas)
+	return areas, err
+}
+
+// Devices fetches the device registry (config/device_registry/list).
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var devices []Device
+	err := c.list(ctx, "config/device_registry/list", &devices)
+	return devices, err
+}
+
+// Entities fetches the entity registry (config/entity_registry/list).
+func (c *Client) Entities(ctx context.Context) ([]RegistryEntry, error) {
+	var entries []RegistryEntry
+	err := c.list(ctx, "config/entity_registry/list", &entries)
+	return entries, err
+}
+
+// Registry fetches all three registries.
+func (c *Client) Registry(ctx context.Context) (Registry, error) {
+	registry := Registry{
+		Areas:    map[string]Area{},
+		Devices:  map[string]Device{},
+		Entities: map[string]RegistryEntry{},
+	}
+
+	areas, err := c.Areas(ctx)
+	if err != nil {
+		return registry, err
+	}
+	for _, area := range areas {
+		registry.Areas[area.AreaID] = area
+	}
+
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		return registry, err
+	}
+	for _, device := range devices {
+		registry.Devices[device.ID] = device
+	}
+
+	entries, err := c.Entities(ctx)
+	if err != nil {
+		return registry, err
+	}
+	for _, entry := range entries {
+		registry.Entities[entry.EntityID] = entry
+	}
+
+	return registry, nil
+}
+
+// list sends a command without arguments and decodes its result.
+func (c *Client) list(ctx context.Context, command string, result interface{}) error {
+	message, err := c.Request(ctx, map[string]interface{}{"type": command})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(message.Result, result)
+}
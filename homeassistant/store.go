@@ -0,0 +1,141 @@
+package homeassistant
+
+import (
+	"sort"
+	"sync"
+)
+
+// Change is published by the Store whenever an entity changes:
+// * Old is the zero State for entities that were added
+// * New is the zero State for entities that were removed
+type Change struct {
+	EntityID string
+	Old      State
+	New      State
+}
+
+// Removed is true if the entity is gone from the store.
+func (c Change) Removed() bool {
+	return c.New.EntityID == ""
+}
+
+// Store is a concurrency-safe cache of entity states.
+// It is updated by the Client and shared by every view.
+type Store struct {
+	mutex       sync.RWMutex
+	states      map[string]State
+	subscribers map[int]func(Change)
+	nextID      int
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{
+		states:      map[string]State{},
+		subscribers: map[int]func(Change){},
+	}
+}
+
+// Get returns the state of an entity and whether it is known.
+func (s *Store) Get(entityID string) (State, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state, ok := s.states[entityID]
+	return state, ok
+}
+
+// All returns all states, sorted by entity id.
+func (s *Store) All() []State {
+	s.mutex.RLock()
+	states := make([]State, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].EntityID < states[j].EntityID
+	})
+	return states
+}
+
+// Set adds or updates the state of an entity.
+func (s *Store) Set(state State) {
+	s.mutex.Lock()
+	change := Change{EntityID: state.EntityID, Old: s.states[state.EntityID], New: state}
+	s.states[state.EntityID] = state
+	s.mutex.Unlock()
+
+	s.notify([]Change{change})
+}
+
+// Remove deletes an entity.
+func (s *Store) Remove(entityID string) {
+	s.mutex.Lock()
+	old, ok := s.states[entityID]
+	delete(s.states, entityID)
+	s.mutex.Unlock()
+
+	if ok {
+		s.notify([]Change{{EntityID: entityID, Old: old}})
+	}
+}
+
+// Replace sets all states at once (e.g. from a get_states result),
+// entities that are not part of states are removed.
+func (s *Store) Replace(states []State) {
+	var changes []Change
+
+	s.mutex.Lock()
+	replaced := s.states
+	s.states = make(map[string]State, len(states))
+	for _, state := range states {
+		s.states[state.EntityID] = state
+		changes = append(changes, Change{
+			EntityID: state.EntityID,
+			Old:      replaced[state.EntityID],
+			New:      state,
+		})
+		delete(replaced, state.EntityID)
+	}
+	for entityID, old := range replaced {
+		changes = append(changes, Change{EntityID: entityID, Old: old})
+	}
+	s.mutex.Unlock()
+
+	s.notify(changes)
+}
+
+// Subscribe registers a callback for changes and returns a function
+// that unsubscribes it again. Callbacks are run synchronously by the
+// goroutine that changed the store, so they must not block.
+func (s *Store) Subscribe(callback func(Change)) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = callback
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		delete(s.subscribers, id)
+	}
+}
+
+// notify runs all callbacks (outside of the lock, so they can read).
+func (s *Store) notify(changes []Change) {
+	s.mutex.RLock()
+	callbacks := make([]func(Change), 0, len(s.subscribers))
+	for _, callback := range s.subscribers {
+		callbacks = append(callbacks, callback)
+	}
+	s.mutex.RUnlock()
+
+	for _, change := range changes {
+		for _, callback := range callbacks {
+			callback(change)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package homeassistant
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStoreSetGet(t *testing.T) {
+	store := NewStore()
+	store.Set(State{EntityID: "light.hue_go_1", State: "on"})
+
+	state, ok := store.Get("light.hue_go_1")
+	if !ok || state.State != "on" {
+		t.Errorf("expected state 'on', got '%v' (known: %v)", state.State, ok)
+	}
+
+	if _, ok := store.Get("light.unknown"); ok {
+		t.Errorf("light.unknown should not be known")
+	}
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	store := NewStore()
+	var changes []Change
+	unsubscribe := store.Subscribe(func(change Change) {
+		changes = append(changes, change)
+	})
+
+	store.Set(State{EntityID: "switch.fan", State: "off"})
+	store.Set(State{EntityID: "switch.fan", State: "on"})
+	store.Remove("switch.fan")
+	store.Remove("switch.fan") // unknown entities do not notify.
+	unsubscribe()
+	store.Set(State{EntityID: "switch.fan", State: "on"})
+
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", len(changes))
+	}
+	if changes[0].Old.EntityID != "" || changes[0].New.State != "off" {
+		t.Errorf("first change should add the entity, got '%+v'", changes[0])
+	}
+	if changes[1].Old.State != "off" || changes[1].New.State != "on" {
+		t.Errorf("second change should switch it on, got '%+v'", changes[1])
+	}
+	if !changes[2].Removed() || changes[2].Old.State != "on" {
+		t.Errorf("third change should remove it, got '%+v'", changes[2])
+	}
+}
+
+func TestStoreReplace(t *testing.T) {
+	store := NewStore()
+	store.Set(State{EntityID: "switch.fan", State: "on"})
+	store.Set(State{EntityID: "switch.edison", State: "on"})
+
+	removed := map[string]bool{}
+	store.Subscribe(func(change Change) {
+		removed[change.EntityID] = change.Removed()
+	})
+	store.Replace([]State{
+		{EntityID: "switch.fan", State: "off"},
+		{EntityID: "light.hue_go_1", State: "on"},
+	})
+
+	expected := map[string]bool{
+		"switch.fan":     false,
+		"light.hue_go_1": false,
+		"switch.edison":  true,
+	}
+	for entityID, expectedRemoved := range expected {
+		if got, ok := removed[entityID]; !ok || got != expectedRemoved {
+			t.Errorf("%v: removed should be %v, got %v", entityID, expectedRemoved, got)
+		}
+	}
+
+	all := store.All()
+	if len(all) != 2 || all[0].EntityID != "light.hue_go_1" {
+		t.Errorf("All() should return the sorted states, got '%+v'", all)
+	}
+}
+
+func TestStoreConcurrency(t *testing.T) {
+	store := NewStore()
+	store.Subscribe(func(change Change) {
+		store.Get(change.EntityID) // reading from callbacks is allowed.
+	})
+
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				store.Set(State{EntityID: "sensor.counter"})
+				store.All()
+			}
+		}()
+	}
+	wait.Wait()
+}
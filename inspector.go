@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/rivo/tview"
+)
+
+// inspect describes the selected node for the inspector view:
+// * entities: name, state, unit, timestamps, context and attributes
+// * groups: the state of every member
+// Other nodes (and no node) are described by an empty string.
+func inspect(
+	node *tview.TreeNode,
+	store *homeassistant.Store,
+	now time.Time,
+) string {
+	if entity := nodeEntity(node); entity != nil {
+		state, ok := store.Get(entity.EntityID)
+		if !ok {
+			return entity.EntityID + "\n\nno state received yet"
+		}
+		return inspectState(state, now)
+	}
+	if group := nodeGroup(node); group != nil {
+		return inspectGroup(group, node, store)
+	}
+	return ""
+}
+
+// inspectState lists everything HA reports about an entity.
+func inspectState(state homeassistant.State, now time.Time) string {
+	lines := []string{
+		state.FriendlyName(),
+		state.EntityID,
+		"",
+		"state: " + state.State,
+	}
+	if unit := state.Unit(); unit != "" {
+		lines = append(lines, "unit: "+unit)
+	}
+	if !state.LastChanged.IsZero() {
+		lines = append(lines, "last changed: "+timestamp(state.LastChanged, now))
+	}
+	if !state.LastUpdated.IsZero() {
+		lines = append(lines, "last updated: "+timestamp(state.LastUpdated, now))
+	}
+
+	// the context tells who (or what) caused the last change:
+	context := state.Context
+	if context.ID != "" {
+		lines = append(lines, "context: "+context.ID)
+	}
+	if context.ParentID != "" {
+		lines = append(lines, "  parent: "+context.ParentID)
+	}
+	if context.UserID != "" {
+		lines = append(lines, "  user: "+context.UserID)
+	}
+
+	if len(state.Attributes) > 0 {
+		lines = append(lines, "", "attributes:")
+		keys := make([]string, 0, len(state.Attributes))
+		for key := range state.Attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("  %v: %v", key, attributeValue(state.Attributes[key])))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inspectGroup lists the members of a group with their states.
+func inspectGroup(
+	group *entityGroup,
+	node *tview.TreeNode,
+	store *homeassistant.Store,
+) string {
+	members := groupMembers(node)
+	on, off := switchable(members, store)
+	lines := []string{
+		group.Name,
+		fmt.Sprintf("group of %v (%v on, %v off)", len(members), len(on), len(off)),
+		"",
+	}
+	for _, entityID := range members {
+		state, ok := store.Get(entityID)
+		value := "no state received yet"
+		if ok {
+			value = strings.TrimSpace(state.State + " " + state.Unit())
+		}
+		lines = append(lines, fmt.Sprintf("%v: %v", entityID, value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// attributeValue formats an attribute, lists and objects as JSON.
+func attributeValue(value interface{}) string {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		encoded, err := json.Marshal(value)
+		if err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// timestamp shows a time relative to now and absolute.
+func timestamp(t time.Time, now time.Time) string {
+	return relativeTime(t, now) + " (" + t.Local().Format(time.RFC1123) + ")"
+}
+
+// relativeTime shows how long ago t was, in its largest unit.
+func relativeTime(t time.Time, now time.Time) string {
+	elapsed := now.Sub(t)
+	switch {
+	case elapsed < time.Second:
+		return "just now"
+	case elapsed < time.Minute:
+		return fmt.Sprintf("%vs ago", int(elapsed.Seconds()))
+	case elapsed < time.Hour:
+		return fmt.Sprintf("%vm ago", int(elapsed.Minutes()))
+	case elapsed < 24*time.Hour:
+		return fmt.Sprintf("%vh ago", int(elapsed.Hours()))
+	}
+	return fmt.Sprintf("%vd ago", int(elapsed.Hours()/24))
+}
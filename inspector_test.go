@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/rivo/tview"
+)
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC)
+	tests := map[time.Duration]string{
+		0:                 "just now",
+		-time.Second:      "just now", // the clocks of HA and bhdr differ.
+		42 * time.Second:  "42s ago",
+		6*time.Minute - 1: "5m ago",
+		3 * time.Hour:     "3h ago",
+		50 * time.Hour:    "2d ago",
+	}
+	for elapsed, expected := range tests {
+		got := relativeTime(now.Add(-elapsed), now)
+		if got != expected {
+			t.Errorf("%v should be '%v', got '%v'", elapsed, expected, got)
+		}
+	}
+}
+
+func TestInspectState(t *testing.T) {
+	now := time.Date(2022, 3, 7, 12, 0, 0, 0, time.UTC)
+	state := homeassistant.State{
+		EntityID: "sensor.temperature",
+		State:    "21.5",
+		Attributes: map[string]interface{}{
+			"friendly_name":       "Temperature",
+			"unit_of_measurement": "°C",
+			"history":             []interface{}{20.0, 21.5},
+		},
+		LastChanged: now.Add(-5 * time.Minute),
+		LastUpdated: now.Add(-30 * time.Second),
+		Context:     homeassistant.Context{ID: "c1", UserID: "u1"},
+	}
+
+	expected := strings.Join([]string{
+		"Temperature",
+		"sensor.temperature",
+		"",
+		"state: 21.5",
+		"unit: °C",
+		"last changed: 5m ago (" + state.LastChanged.Local().Format(time.RFC1123) + ")",
+		"last updated: 30s ago (" + state.LastUpdated.Local().Format(time.RFC1123) + ")",
+		"context: c1",
+		"  user: u1",
+		"",
+		"attributes:",
+		"  friendly_name: Temperature",
+		"  history: [20,21.5]",
+		"  unit_of_measurement: °C",
+	}, "\n")
+	if got := inspectState(state, now); got != expected {
+		t.Errorf("inspector should be '%v', got '%v'", expected, got)
+	}
+}
+
+func TestInspectNodes(t *testing.T) {
+	store := homeassistant.NewStore()
+	store.Set(homeassistant.State{EntityID: "switch.a", State: "on"})
+	store.Set(homeassistant.State{EntityID: "sensor.b", State: "3", Attributes: map[string]interface{}{
+		"unit_of_measurement": "W",
+	}})
+
+	group := tview.NewTreeNode("group").SetReference(&entityGroup{Name: "group"})
+	for _, entityID := range []string{"switch.a", "sensor.b", "switch.c"} {
+		group.AddChild(tview.NewTreeNode(entityID).SetReference(
+			&homeassistant.Entity{EntityID: entityID},
+		))
+	}
+
+	expected := "group\ngroup of 3 (1 on, 0 off)\n\n" +
+		"switch.a: on\nsensor.b: 3 W\nswitch.c: no state received yet"
+	if got := inspect(group, store, time.Now()); got != expected {
+		t.Errorf("group should be '%v', got '%v'", expected, got)
+	}
+
+	missing := group.GetChildren()[2]
+	expected = "switch.c\n\nno state received yet"
+	if got := inspect(missing, store, time.Now()); got != expected {
+		t.Errorf("entity without state should be '%v', got '%v'", expected, got)
+	}
+
+	if got := inspect(tview.NewTreeNode("."), store, time.Now()); got != "" {
+		t.Errorf("other nodes should be '', got '%v'", got)
+	}
+	if got := inspect(nil, store, time.Now()); got != "" {
+		t.Errorf("no node should be '', got '%v'", got)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	_ "embed"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -27,6 +26,11 @@ func main() {
 		false,
 		"displays a log viewer",
 	)
+	checkConfig := flag.Bool(
+		"check-config",
+		false,
+		"validate the config file and exit",
+	)
 	customConfig := flag.String(
 		"config",
 		"",
@@ -57,17 +61,22 @@ func main() {
 	}
 
 	// read config file:
-	haConfigFile, err := os.Open(configFile)
+	data, err := os.ReadFile(configFile)
 	if err != nil {
 		log.Fatal(err, ". you can create one with: bhdr --create-config")
 	}
 
-	// unmarshal config:
-	var config map[string]interface{}
-	jsonParser := json.NewDecoder(haConfigFile)
-	err = jsonParser.Decode(&config)
+	// parse and validate config, report all problems before starting:
+	config, err := parseConfig(data)
 	if err != nil {
-		log.Fatal("config file parsing error: ", err)
+		fmt.Fprintf(os.Stderr, "%v:\n%v\n", configFile, err)
+		os.Exit(1)
+	}
+
+	// handle --check-config flag:
+	if *checkConfig {
+		fmt.Printf("%v is valid\n", configFile)
+		os.Exit(0)
 	}
 
 	spawnTUI(config, *showLogs)
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/util"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// panelDebounce is how long a control has to rest before
+// its service is called (e.g. while a slider is moved).
+const panelDebounce = 300 * time.Millisecond
+
+// panelWidth is the width of a panel, including its border.
+const panelWidth = 64
+
+// control is a row of a panel:
+// * sliders have a Value between Min and Max, changed by Step
+// * choices select one of Options (Value is its index, -1 for none)
+type control struct {
+	Name    string
+	Value   float64
+	Min     float64
+	Max     float64
+	Step    float64
+	Options []string
+
+	// Format shows the value (defaults to the number).
+	Format func(value float64) string
+
+	// Group names controls that share a call (e.g. hue and
+	// saturation), moving several of them sends it once.
+	Group string
+
+	// Call returns the service call that applies the control,
+	// values are those of all controls (including moved ones).
+	Call func(values map[string]float64) homeassistant.ServiceCall
+}
+
+// callKey identifies the call of a control.
+func (c control) callKey() string {
+	if c.Group != "" {
+		return c.Group
+	}
+	return c.Name
+}
+
+// text shows the value of a control.
+func (c control) text() string {
+	if c.Options != nil {
+		if index := int(c.Value); c.Value >= 0 && index < len(c.Options) {
+			return fmt.Sprintf("%v (%v/%v)", c.Options[index], index+1, len(c.Options))
+		}
+		return "none"
+	}
+	if c.Format != nil {
+		return c.Format(c.Value)
+	}
+	return fmt.Sprint(c.Value)
+}
+
+// panelContent is what a panel shows of a state:
+// * Lines: a summary above the controls
+// * Controls: the rows that can be changed
+// * Swatch: a color preview (nil for none)
+// * Commands: what the panel's named commands (e.g. raise) do
+// Swatch gets the name of the last moved control ("" for none).
+type panelContent struct {
+	Lines    []string
+	Controls []control
+	Swatch   func(values map[string]float64, moved string) tcell.Color
+	Commands map[string]panelCommand
+}
+
+// panelCommand is what a command does (whichever control is selected):
+// * Controls: moves these controls by Steps (times the count)
+// * Call: sends this call right away (if Controls is empty)
+type panelCommand struct {
+	Controls []string
+	Steps    float64
+	Call     homeassistant.ServiceCall
+}
+
+// panelBuilders create the content of a panel, by domain.
+var panelBuilders = map[string]func(state homeassistant.State) panelContent{
+	"light":               lightPanel,
+	"climate":             climatePanel,
+	"media_player":        mediaPanel,
+	"cover":               coverPanel,
+	"lock":                lockPanel,
+	"alarm_control_panel": alarmPanel,
+}
+
+// movedValue is the value of a moved control until HA confirms it.
+type movedValue struct {
+	Value float64
+	Sent  bool // its service call was sent.
+}
+
+// panel controls an entity with the controls of its domain.
+// It shows the entity's stored state, but moved controls keep
+// their value until a state change after their call arrives.
+type panel struct {
+	*tview.Box
+	entityID  string
+	build     func(state homeassistant.State) panelContent
+	store     *homeassistant.Store
+	send      func(description string, call homeassistant.ServiceCall)
+	queue     func(f func()) // runs f on the app's goroutine.
+	debouncer *util.Debouncer
+	selected  int
+	moved     map[string]*movedValue
+	lastMoved string
+
+	// keys returns the keys of a command for the footer (if set).
+	keys func(command string) string
+}
+
+// newPanel creates the panel of an entity. It returns nil if
+// there are no controls for the entity's domain.
+func newPanel(
+	entityID string,
+	store *homeassistant.Store,
+	send func(description string, call homeassistant.ServiceCall),
+	queue func(f func()),
+) *panel {
+	build, ok := panelBuilders[homeassistant.Domain(entityID)]
+	if !ok {
+		return nil
+	}
+	p := &panel{
+		Box:       tview.NewBox(),
+		entityID:  entityID,
+		build:     build,
+		store:     store,
+		send:      send,
+		queue:     queue,
+		debouncer: util.NewDebouncer(panelDebounce),
+		moved:     map[string]*movedValue{},
+	}
+	p.SetBorder(true)
+	return p
+}
+
+// content builds the panel of the stored state, with moved controls.
+func (p *panel) content() (homeassistant.State, panelContent) {
+	state, ok := p.store.Get(p.entityID)
+	if !ok {
+		state.EntityID = p.entityID
+	}
+	content := p.build(state)
+	for i, control := range content.Controls {
+		if moved, ok := p.moved[control.Name]; ok {
+			content.Controls[i].Value = moved.Value
+		}
+	}
+	if p.selected >= len(content.Controls) {
+		p.selected = len(content.Controls) - 1
+	}
+	if p.selected < 0 {
+		p.selected = 0
+	}
+	return state, content
+}
+
+// values returns the values of all controls by name.
+func values(controls []control) map[string]float64 {
+	values := map[string]float64{}
+	for _, control := range controls {
+		values[control.Name] = control.Value
+	}
+	return values
+}
+
+// height is the number of rows the panel needs, including its border.
+func (p *panel) height() int {
+	_, content := p.content()
+	rows := len(content.Lines) + 1 + len(content.Controls) + 2
+	if content.Swatch != nil {
+		rows += 2
+	}
+	if footer := p.footer(content); len(footer) > 0 {
+		rows += 1 + len(footer)
+	}
+	return rows
+}
+
+// footer lists the commands (with their keys), wrapped to the panel.
+func (p *panel) footer(content panelContent) []string {
+	var commands []string
+	for _, name := range sortedKeys(content.Commands) {
+		if p.keys != nil && p.keys(name) != "" {
+			name += " " + p.keys(name)
+		}
+		commands = append(commands, name)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	return tview.WordWrap(strings.Join(commands, ", "), panelWidth-4)
+}
+
+// selectControl moves the selection by offset (wrapping around).
+func (p *panel) selectControl(offset int) {
+	_, content := p.content()
+	if len(content.Controls) == 0 {
+		return
+	}
+	count := len(content.Controls)
+	p.selected = ((p.selected+offset)%count + count) % count
+}
+
+// change moves the selected control by steps (a slider
+// by its Step, a choice by options). Sliders stop at their
+// ends, choices wrap around.
+func (p *panel) change(steps float64) {
+	_, content := p.content()
+	if len(content.Controls) == 0 {
+		return
+	}
+	control := content.Controls[p.selected]
+	value := control.Value + steps*control.Step
+	if count := len(control.Options); count > 0 {
+		current := int(control.Value)
+		if control.Value < 0 && steps < 0 { // from none backwards.
+			current = count
+		}
+		value = float64(((current+int(steps))%count + count) % count)
+	}
+	p.set(control, value)
+}
+
+// extreme sets the selected control to its minimum or maximum.
+func (p *panel) extreme(maximum bool) {
+	_, content := p.content()
+	if len(content.Controls) == 0 {
+		return
+	}
+	control := content.Controls[p.selected]
+	if maximum {
+		p.set(control, control.Max)
+	} else {
+		p.set(control, control.Min)
+	}
+}
+
+// command runs a command count times. It returns an
+// error if the panel has no such command.
+func (p *panel) command(name string, count int) error {
+	state, content := p.content()
+	command, ok := content.Commands[name]
+	if !ok {
+		return fmt.Errorf("%v does not support %v", state.FriendlyName(), name)
+	}
+	if len(command.Controls) == 0 {
+		p.send(fmt.Sprintf("%v %v", name, p.entityID), command.Call)
+		return nil
+	}
+	for _, name := range command.Controls {
+		for _, control := range content.Controls {
+			if control.Name == name {
+				p.set(control, control.Value+command.Steps*float64(count)*control.Step)
+			}
+		}
+	}
+	return nil
+}
+
+// set moves a control and calls its service once it rests.
+// Values are rounded to hundredths (steps may be fractions).
+func (p *panel) set(control control, value float64) {
+	value = math.Round(value*100) / 100
+	if value < control.Min {
+		value = control.Min
+	}
+	if value > control.Max {
+		value = control.Max
+	}
+	if control.Call == nil || value == control.Value {
+		return
+	}
+	p.moved[control.Name] = &movedValue{Value: value}
+	p.lastMoved = control.Name
+
+	// send the call of the control (or its group) once:
+	key := control.callKey()
+	p.debouncer.Call(p.entityID+" "+key, func() {
+		p.queue(func() {
+			_, content := p.content()
+			var call *homeassistant.ServiceCall
+			for _, control := range content.Controls {
+				moved, ok := p.moved[control.Name]
+				if !ok || control.callKey() != key {
+					continue
+				}
+				moved.Sent = true
+				if call == nil {
+					c := control.Call(values(content.Controls))
+					call = &c
+				}
+			}
+			if call != nil {
+				p.send(fmt.Sprintf("%v %v", key, p.entityID), *call)
+			}
+		})
+	})
+}
+
+// stateChanged drops the moved values that HA has received,
+// call it on the app's goroutine for every state change.
+func (p *panel) stateChanged(entityID string) {
+	if entityID != p.entityID {
+		return
+	}
+	for name, moved := range p.moved {
+		if moved.Sent {
+			delete(p.moved, name)
+		}
+	}
+}
+
+// Draw draws the summary, the swatch and the controls.
+func (p *panel) Draw(screen tcell.Screen) {
+	state, content := p.content()
+	p.SetTitle(state.FriendlyName())
+	p.Box.DrawForSubclass(screen, p)
+	x, y, width, height := p.GetInnerRect()
+	bottom := y + height
+
+	for _, line := range content.Lines {
+		if y >= bottom {
+			return
+		}
+		tview.Print(screen, tview.Escape(line), x+1, y, width-2, tview.AlignLeft, tcell.ColorWhite)
+		y++
+	}
+	y++
+
+	if content.Swatch != nil && y < bottom {
+		color := content.Swatch(values(content.Controls), p.lastMoved)
+		tview.Print(screen, "color", x+1, y, width-2, tview.AlignLeft, tcell.ColorWhite)
+		style := tcell.StyleDefault.Background(color)
+		for column := x + 14; column < x+width-1; column++ {
+			screen.SetContent(column, y, ' ', nil, style)
+		}
+		y += 2
+	}
+
+	// name, bar (for sliders) and value of every control:
+	for i, control := range content.Controls {
+		if y >= bottom {
+			return
+		}
+		name, color := "  "+control.Name, tcell.ColorWhite
+		if i == p.selected {
+			name, color = "▸ "+control.Name, tcell.ColorYellow
+		}
+		tview.Print(screen, tview.Escape(name), x, y, 14, tview.AlignLeft, color)
+
+		text := control.text()
+		barWidth := width - 14 - 18
+		if control.Options == nil && control.Max > control.Min && barWidth > 0 {
+			// HA may report values outside the range, the text shows them:
+			filled := int(math.Round(
+				(control.Value - control.Min) / (control.Max - control.Min) * float64(barWidth),
+			))
+			if filled < 0 {
+				filled = 0
+			}
+			if filled > barWidth {
+				filled = barWidth
+			}
+			bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+			tview.Print(screen, bar, x+14, y, barWidth, tview.AlignLeft, color)
+			tview.Print(screen, tview.Escape(text), x+15+barWidth, y, 17, tview.AlignLeft, color)
+		} else {
+			tview.Print(screen, tview.Escape("‹ "+text+" ›"), x+14, y, width-15, tview.AlignLeft, color)
+		}
+		y++
+	}
+
+	y++
+	for _, line := range p.footer(content) {
+		if y >= bottom {
+			return
+		}
+		tview.Print(screen, tview.Escape(line), x+1, y, width-2, tview.AlignLeft, tcell.ColorGray)
+		y++
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/gdamore/tcell/v2"
+)
+
+// testLightState is a light that supports every control.
+func testLightState() homeassistant.State {
+	var state homeassistant.State
+	json.Unmarshal([]byte(`{
+		"entity_id": "light.hue_go_1",
+		"state": "on",
+		"attributes": {
+			"brightness": 128,
+			"color_temp": 300,
+			"min_mireds": 150,
+			"max_mireds": 450,
+			"hs_color": [30.0, 80.0],
+			"effect": "colorloop",
+			"effect_list": ["none", "colorloop"],
+			"color_mode": "hs",
+			"supported_color_modes": ["color_temp", "hs"]
+		}
+	}`), &state)
+	return state
+}
+
+// testPanel is a panel of testLightState, its calls are sent to calls
+// and the functions it queues for the app are sent to queued.
+func testPanel(t *testing.T) (*panel, *homeassistant.Store, chan string, chan func()) {
+	store := homeassistant.NewStore()
+	store.Set(testLightState())
+	calls := make(chan string, 10)
+	queued := make(chan func(), 10)
+	p := newPanel(
+		"light.hue_go_1",
+		store,
+		func(description string, call homeassistant.ServiceCall) {
+			data, _ := json.Marshal(call.Data)
+			calls <- fmt.Sprintf("%v: %v %s", description, call.Service, data)
+		},
+		func(f func()) { queued <- f },
+	)
+	if p == nil {
+		t.Fatal("lights should have a panel")
+	}
+	return p, store, calls, queued
+}
+
+// controlValues returns the values of a panel's controls.
+func controlValues(p *panel) map[string]float64 {
+	_, content := p.content()
+	return values(content.Controls)
+}
+
+func TestLightPanelControls(t *testing.T) {
+	content := lightPanel(testLightState())
+
+	var names []string
+	for _, control := range content.Controls {
+		names = append(names, control.Name+" "+control.text())
+	}
+	expected := []string{
+		"brightness 50%",
+		"color temp 3330 K",
+		"hue 30°",
+		"saturation 80%",
+		"effect colorloop (2/2)",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("controls should be '%v', got '%v'", expected, names)
+	}
+
+	// lights that only dim just get a brightness slider:
+	state := homeassistant.State{
+		EntityID:   "light.bulb",
+		State:      "on",
+		Attributes: map[string]interface{}{"brightness": 255.0},
+	}
+	content = lightPanel(state)
+	if len(content.Controls) != 1 || content.Controls[0].Name != "brightness" {
+		t.Errorf("dimmable light should only have brightness, got '%+v'", content.Controls)
+	}
+
+	if newPanel("switch.fan", homeassistant.NewStore(), nil, nil) != nil {
+		t.Errorf("switches should have no panel")
+	}
+}
+
+func TestPanelDebouncesChanges(t *testing.T) {
+	p, store, calls, queued := testPanel(t)
+
+	// the value moves at once, the call waits until it rests:
+	p.change(1)
+	p.change(1)
+	p.change(1)
+	if got := controlValues(p)["brightness"]; got != 65 {
+		t.Errorf("brightness should be '65', got '%v'", got)
+	}
+	(<-queued)()
+	expected := `brightness light.hue_go_1: turn_on {"brightness_pct":65}`
+	if got := <-calls; got != expected {
+		t.Errorf("call should be '%v', got '%v'", expected, got)
+	}
+	select {
+	case f := <-queued:
+		f()
+		t.Errorf("only the last change should be sent, got '%v'", <-calls)
+	case <-time.After(2 * panelDebounce):
+	}
+
+	// the sent value is kept until HA reports a change:
+	if got := controlValues(p)["brightness"]; got != 65 {
+		t.Errorf("brightness should still be '65', got '%v'", got)
+	}
+	store.Set(testLightState())
+	p.stateChanged("light.hue_go_1")
+	if got := controlValues(p)["brightness"]; got != 50 {
+		t.Errorf("brightness should be the stored '50', got '%v'", got)
+	}
+}
+
+func TestPanelSendsGroupsOnce(t *testing.T) {
+	p, _, calls, queued := testPanel(t)
+	p.selectControl(3)
+	p.change(-1) // saturation.
+	p.selectControl(-1)
+	p.change(-2) // hue.
+	(<-queued)()
+	expected := `color light.hue_go_1: turn_on {"hs_color":[20,75]}`
+	if got := <-calls; got != expected {
+		t.Errorf("call should be '%v', got '%v'", expected, got)
+	}
+	select {
+	case <-queued:
+		t.Errorf("hue and saturation should be sent in one call")
+	case <-time.After(2 * panelDebounce):
+	}
+}
+
+func TestPanelLimits(t *testing.T) {
+	p, _, _, _ := testPanel(t)
+
+	p.change(20) // sliders stop at their maximum.
+	if got := controlValues(p)["brightness"]; got != 100 {
+		t.Errorf("brightness should stop at '100', got '%v'", got)
+	}
+	p.selectControl(1)
+	p.extreme(false)
+	if got := controlValues(p)["color temp"]; got != 150 {
+		t.Errorf("color temp should be the minimum '150', got '%v'", got)
+	}
+
+	p.selectControl(-2) // the selection wraps around.
+	p.change(1)         // choices wrap around.
+	if got := controlValues(p)["effect"]; got != 0 {
+		t.Errorf("effect should wrap to '0', got '%v'", got)
+	}
+	p.change(-1)
+	if got := controlValues(p)["effect"]; got != 1 {
+		t.Errorf("effect should wrap back to '1', got '%v'", got)
+	}
+}
+
+func TestPanelDrawsValuesOutOfRange(t *testing.T) {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	screen.SetSize(panelWidth, 20)
+
+	for _, temperature := range []float64{5, 40} {
+		state := testClimateState(false)
+		state.Attributes["temperature"] = temperature
+		p, _, _ := panelOf(state)
+		p.SetRect(0, 0, panelWidth, 20)
+		p.Draw(screen) // must not panic.
+		screen.Show()
+
+		cells, _, _ := screen.GetContents()
+		var text strings.Builder
+		for _, cell := range cells {
+			text.WriteString(string(cell.Runes))
+		}
+		expected := degrees(temperature)
+		if !strings.Contains(text.String(), expected) {
+			t.Errorf("panel should show the value '%v'", expected)
+		}
+	}
+}
+
+// testClimateState is a thermostat with a setpoint (or a range).
+func testClimateState(hasRange bool) homeassistant.State {
+	var state homeassistant.State
+	json.Unmarshal([]byte(`{
+		"entity_id": "climate.office",
+		"state": "heat",
+		"attributes": {
+			"current_temperature": 19.5,
+			"temperature": 21,
+			"min_temp": 7,
+			"max_temp": 30,
+			"hvac_modes": ["off", "heat", "heat_cool"],
+			"hvac_action": "heating",
+			"preset_modes": ["home", "away"],
+			"preset_mode": "home"
+		}
+	}`), &state)
+	if hasRange {
+		state.State = "heat_cool"
+		delete(state.Attributes, "temperature")
+		state.Attributes["target_temp_low"] = 20.0
+		state.Attributes["target_temp_high"] = 24.0
+	}
+	return state
+}
+
+// panelOf is a panel of a state, its calls are sent to calls.
+func panelOf(state homeassistant.State) (*panel, chan string, chan func()) {
+	store := homeassistant.NewStore()
+	store.Set(state)
+	calls := make(chan string, 10)
+	queued := make(chan func(), 10)
+	p := newPanel(
+		state.EntityID,
+		store,
+		func(description string, call homeassistant.ServiceCall) {
+			data, _ := json.Marshal(call.Data)
+			calls <- fmt.Sprintf("%v %s", call.Service, data)
+		},
+		func(f func()) { queued <- f },
+	)
+	return p, calls, queued
+}
+
+func TestClimatePanel(t *testing.T) {
+	content := climatePanel(testClimateState(false))
+	expectedLines := []string{"current: 19.5°", "action: heating"}
+	if !reflect.DeepEqual(content.Lines, expectedLines) {
+		t.Errorf("lines should be '%v', got '%v'", expectedLines, content.Lines)
+	}
+	var names []string
+	for _, control := range content.Controls {
+		names = append(names, control.Name+" "+control.text())
+	}
+	expected := []string{"target 21°", "hvac mode heat (2/3)", "preset home (1/2)"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("controls should be '%v', got '%v'", expected, names)
+	}
+
+	// raise and lower move the setpoint, whatever is selected:
+	p, calls, queued := panelOf(testClimateState(false))
+	p.selectControl(1)
+	p.command("raise", 3)
+	p.command("lower", 1)
+	(<-queued)()
+	if got := <-calls; got != `set_temperature {"temperature":22}` {
+		t.Errorf("call should set the temperature to 22, got '%v'", got)
+	}
+
+	// modes are cycled:
+	p.change(1)
+	(<-queued)()
+	if got := <-calls; got != `set_hvac_mode {"hvac_mode":"heat_cool"}` {
+		t.Errorf("call should set the hvac mode to heat_cool, got '%v'", got)
+	}
+}
+
+func TestClimatePanelRange(t *testing.T) {
+	p, calls, queued := panelOf(testClimateState(true))
+	p.command("raise", 1) // moves both ends, in one call.
+	(<-queued)()
+	expected := `set_temperature {"target_temp_high":24.5,"target_temp_low":20.5}`
+	if got := <-calls; got != expected {
+		t.Errorf("call should be '%v', got '%v'", expected, got)
+	}
+}
+
+func TestMediaPanel(t *testing.T) {
+	state := homeassistant.State{
+		EntityID: "media_player.kitchen",
+		State:    "paused",
+		Attributes: map[string]interface{}{
+			"media_title":    "So What",
+			"media_artist":   "Miles Davis",
+			"media_duration": 562.0,
+			"media_position": 65.0,
+			"volume_level":   0.35,
+			"shuffle":        false,
+		},
+	}
+	content := mediaPanel(state)
+	expectedLines := []string{"state: paused", "title: So What", "artist: Miles Davis"}
+	if !reflect.DeepEqual(content.Lines, expectedLines) {
+		t.Errorf("lines should be '%v', got '%v'", expectedLines, content.Lines)
+	}
+	var names []string
+	for _, control := range content.Controls {
+		names = append(names, control.Name+" "+control.text())
+	}
+	expected := []string{"position 1:05 / 9:22", "volume 35%", "shuffle off (1/2)"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("controls should be '%v', got '%v'", expected, names)
+	}
+
+	p, calls, queued := panelOf(state)
+	p.command("play-pause", 1) // sent right away.
+	if got := <-calls; got != "media_play_pause null" {
+		t.Errorf("call should be 'media_play_pause null', got '%v'", got)
+	}
+	p.command("seek-forward", 3)
+	(<-queued)()
+	if got := <-calls; got != `media_seek {"seek_position":95}` {
+		t.Errorf("call should seek to 95, got '%v'", got)
+	}
+	p.command("lower", 2)
+	(<-queued)()
+	if got := <-calls; got != `volume_set {"volume_level":0.25}` {
+		t.Errorf("call should set the volume to 0.25, got '%v'", got)
+	}
+	if err := p.command("dance", 1); err == nil {
+		t.Errorf("unknown commands should fail")
+	}
+}
+
+func TestPlayTime(t *testing.T) {
+	times := map[float64]string{0: "0:00", 65: "1:05", 3599: "59:59", 3725: "1:02:05"}
+	for seconds, expected := range times {
+		if got := playTime(seconds); got != expected {
+			t.Errorf("%v should be '%v', got '%v'", seconds, expected, got)
+		}
+	}
+}
+
+func TestCoverPanel(t *testing.T) {
+	state := homeassistant.State{
+		EntityID:   "cover.garage",
+		State:      "open",
+		Attributes: map[string]interface{}{"current_position": 60.0},
+	}
+	p, calls, queued := panelOf(state)
+	_, content := p.content()
+	if len(content.Controls) != 1 || content.Controls[0].text() != "60%" {
+		t.Errorf("controls should be a position of 60%%, got '%v'", content.Controls)
+	}
+	p.command("stop-cover", 1)
+	if got := <-calls; got != "stop_cover null" {
+		t.Errorf("call should be 'stop_cover null', got '%v'", got)
+	}
+	p.change(-2)
+	(<-queued)()
+	if got := <-calls; got != `set_cover_position {"position":40}` {
+		t.Errorf("call should set the position to 40, got '%v'", got)
+	}
+}
+
+func TestLockAndAlarmPanels(t *testing.T) {
+	lock := homeassistant.State{
+		EntityID:   "lock.front_door",
+		State:      "locked",
+		Attributes: map[string]interface{}{"code_format": "^\\d{4}$"},
+	}
+	content := lockPanel(lock)
+	expectedLines := []string{"state: locked", "code: required"}
+	if !reflect.DeepEqual(content.Lines, expectedLines) {
+		t.Errorf("lines should be '%v', got '%v'", expectedLines, content.Lines)
+	}
+	if call := content.Commands["unlock"].Call; call.Service != "unlock" || !call.NeedsCode {
+		t.Errorf("unlock should need a code, got '%v'", call)
+	}
+
+	alarm := homeassistant.State{
+		EntityID:   "alarm_control_panel.home",
+		State:      "disarmed",
+		Attributes: map[string]interface{}{"supported_features": 3.0},
+	}
+	p, _, _ := panelOf(alarm)
+	p.keys = func(command string) string {
+		return map[string]string{"arm-home": "ah", "disarm": "d"}[command]
+	}
+	_, content = p.content()
+	expected := []string{"arm-away, arm-home ah, disarm d"}
+	if got := p.footer(content); !reflect.DeepEqual(got, expected) {
+		t.Errorf("footer should be '%v', got '%v'", expected, got)
+	}
+	if err := p.command("arm-night", 1); err == nil {
+		t.Errorf("unsupported modes should fail")
+	}
+}
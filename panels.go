@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/gdamore/tcell/v2"
+)
+
+// warmWhite is the color temperature (in mireds) a swatch shows
+// for lights that only dim (2700 K, like a light bulb).
+const warmWhite = 370
+
+// lightPanel controls what a light supports:
+// * brightness (percent), color temp (mireds)
+// * hue (degrees) and saturation (percent)
+// * effect (from the effect_list)
+// Every control calls light.turn_on, hue and saturation together.
+func lightPanel(state homeassistant.State) panelContent {
+	light := homeassistant.NewLight(state)
+	turnOn := func(data map[string]interface{}) homeassistant.ServiceCall {
+		return homeassistant.ServiceCall{
+			Domain:  "light",
+			Service: "turn_on",
+			Target:  homeassistant.Target{EntityID: []string{state.EntityID}},
+			Data:    data,
+		}
+	}
+	percent := func(value float64) string { return fmt.Sprintf("%v%%", value) }
+	hsColor := func(values map[string]float64) homeassistant.ServiceCall {
+		return turnOn(map[string]interface{}{
+			"hs_color": []float64{values["hue"], values["saturation"]},
+		})
+	}
+
+	content := panelContent{
+		Lines:    []string{"state: " + stateText(state)},
+		Commands: raiseLower("brightness"),
+	}
+	if light.ColorMode != "" {
+		content.Lines = append(content.Lines, "color mode: "+light.ColorMode)
+	}
+	if light.SupportsDimming {
+		content.Controls = append(content.Controls, control{
+			Name: "brightness", Value: light.Brightness, Max: 100, Step: 5,
+			Format: percent,
+			Call: func(values map[string]float64) homeassistant.ServiceCall {
+				return turnOn(map[string]interface{}{"brightness_pct": values["brightness"]})
+			},
+		})
+	}
+	if light.SupportsColorTemp {
+		content.Controls = append(content.Controls, control{
+			Name:  "color temp",
+			Value: light.ColorTemp, Min: light.MinMireds, Max: light.MaxMireds, Step: 10,
+			Format: func(value float64) string {
+				return fmt.Sprintf("%v K", math.Round(1e6/value/10)*10)
+			},
+			Call: func(values map[string]float64) homeassistant.ServiceCall {
+				return turnOn(map[string]interface{}{"color_temp": values["color temp"]})
+			},
+		})
+	}
+	if light.SupportsColor {
+		content.Controls = append(content.Controls,
+			control{
+				Name: "hue", Value: light.Hue, Max: 360, Step: 5,
+				Format: degrees,
+				Group:  "color", Call: hsColor,
+			},
+			control{
+				Name: "saturation", Value: light.Saturation, Max: 100, Step: 5,
+				Format: percent,
+				Group:  "color", Call: hsColor,
+			},
+		)
+	}
+	if len(light.Effects) > 0 {
+		content.Controls = append(content.Controls, choice(
+			"effect", light.Effects, light.Effect,
+			func(effect string) homeassistant.ServiceCall {
+				return turnOn(map[string]interface{}{"effect": effect})
+			},
+		))
+	}
+
+	// the swatch shows the color temperature or the hue and
+	// saturation, whichever was moved last or is the color mode:
+	content.Swatch = func(values map[string]float64, moved string) tcell.Color {
+		if !light.On && moved == "" {
+			return tcell.ColorBlack
+		}
+		temperature := moved == "color temp" ||
+			(moved != "hue" && moved != "saturation" &&
+				(light.ColorMode == "color_temp" || !light.SupportsColor))
+		switch {
+		case temperature && light.SupportsColorTemp:
+			return tcell.NewRGBColor(homeassistant.MiredsToRGB(values["color temp"]))
+		case light.SupportsColor:
+			return tcell.NewRGBColor(homeassistant.HSVToRGB(values["hue"], values["saturation"], 100))
+		}
+		return tcell.NewRGBColor(homeassistant.MiredsToRGB(warmWhite))
+	}
+	return content
+}
+
+// stateText is the state with its unit (or a note if there is none).
+func stateText(state homeassistant.State) string {
+	if state.State == "" {
+		return "no state received yet"
+	}
+	if unit := state.Unit(); unit != "" {
+		return state.State + " " + unit
+	}
+	return state.State
+}
+
+// climatePanel controls a thermostat:
+// * the setpoint (or the low and high end of a range)
+// * the HVAC mode, preset and fan mode (each if it has options)
+// The setpoint is also moved by the commands raise and lower.
+func climatePanel(state homeassistant.State) panelContent {
+	climate := homeassistant.NewClimate(state)
+	climateCall := func(service string, data map[string]interface{}) homeassistant.ServiceCall {
+		return homeassistant.ServiceCall{
+			Domain:  "climate",
+			Service: service,
+			Target:  homeassistant.Target{EntityID: []string{state.EntityID}},
+			Data:    data,
+		}
+	}
+	setpoint := func(name string, value float64) control {
+		return control{
+			Name: name, Value: value,
+			Min: climate.MinTemp, Max: climate.MaxTemp, Step: climate.Step,
+			Format: degrees,
+		}
+	}
+
+	content := panelContent{}
+	if climate.HasCurrent {
+		content.Lines = append(content.Lines, "current: "+degrees(climate.Current))
+	}
+	if climate.HVACAction != "" {
+		content.Lines = append(content.Lines, "action: "+climate.HVACAction)
+	}
+	if state.State == "" {
+		content.Lines = append(content.Lines, stateText(state))
+	}
+
+	if climate.HasRange {
+		low := setpoint("target low", climate.TargetLow)
+		high := setpoint("target high", climate.TargetHigh)
+		low.Group, high.Group = "target", "target"
+		low.Call = func(values map[string]float64) homeassistant.ServiceCall {
+			return climateCall("set_temperature", map[string]interface{}{
+				"target_temp_low":  values["target low"],
+				"target_temp_high": values["target high"],
+			})
+		}
+		high.Call = low.Call
+		content.Controls = append(content.Controls, low, high)
+		content.Commands = raiseLower("target low", "target high")
+	} else {
+		target := setpoint("target", climate.Target)
+		target.Call = func(values map[string]float64) homeassistant.ServiceCall {
+			return climateCall("set_temperature", map[string]interface{}{
+				"temperature": values["target"],
+			})
+		}
+		content.Controls = append(content.Controls, target)
+		content.Commands = raiseLower("target")
+	}
+
+	modes := []struct {
+		name, service, key, current string
+		options                     []string
+	}{
+		{"hvac mode", "set_hvac_mode", "hvac_mode", climate.HVACMode, climate.HVACModes},
+		{"preset", "set_preset_mode", "preset_mode", climate.Preset, climate.Presets},
+		{"fan mode", "set_fan_mode", "fan_mode", climate.FanMode, climate.FanModes},
+	}
+	for _, mode := range modes {
+		if len(mode.options) == 0 {
+			continue
+		}
+		mode := mode
+		content.Controls = append(content.Controls, choice(
+			mode.name, mode.options, mode.current,
+			func(option string) homeassistant.ServiceCall {
+				return climateCall(mode.service, map[string]interface{}{mode.key: option})
+			},
+		))
+	}
+	return content
+}
+
+// choice creates a control that selects one of options,
+// current is the selected one (none if it is not an option).
+func choice(
+	name string,
+	options []string,
+	current string,
+	call func(option string) homeassistant.ServiceCall,
+) control {
+	index := -1
+	for i, option := range options {
+		if option == current {
+			index = i
+		}
+	}
+	return control{
+		Name: name, Value: float64(index), Max: float64(len(options) - 1),
+		Options: options,
+		Call: func(values map[string]float64) homeassistant.ServiceCall {
+			return call(options[int(values[name])])
+		},
+	}
+}
+
+// raiseLower returns the commands raise and lower,
+// they move controls one step up or down.
+func raiseLower(controls ...string) map[string]panelCommand {
+	return map[string]panelCommand{
+		"raise": {Controls: controls, Steps: 1},
+		"lower": {Controls: controls, Steps: -1},
+	}
+}
+
+// degrees shows a temperature or an angle.
+func degrees(value float64) string {
+	return fmt.Sprintf("%v°", value)
+}
+
+// mediaPanel controls a media player:
+// * what is playing (title, artist and album)
+// * position (seeks), volume, source, shuffle and repeat
+// * the commands play-pause, next-track and previous-track
+// * the commands seek-forward, seek-backward (10s), raise and lower (volume)
+func mediaPanel(state homeassistant.State) panelContent {
+	player := homeassistant.NewMediaPlayer(state, time.Now())
+	mediaCall := func(service string, data map[string]interface{}) homeassistant.ServiceCall {
+		return homeassistant.ServiceCall{
+			Domain:  "media_player",
+			Service: service,
+			Target:  homeassistant.Target{EntityID: []string{state.EntityID}},
+			Data:    data,
+		}
+	}
+
+	content := panelContent{
+		Lines: []string{"state: " + stateText(state)},
+		Commands: map[string]panelCommand{
+			"play-pause":     {Call: mediaCall("media_play_pause", nil)},
+			"next-track":     {Call: mediaCall("media_next_track", nil)},
+			"previous-track": {Call: mediaCall("media_previous_track", nil)},
+		},
+	}
+	if player.Title != "" {
+		content.Lines = append(content.Lines, "title: "+player.Title)
+	}
+	if player.Artist != "" {
+		content.Lines = append(content.Lines, "artist: "+player.Artist)
+	}
+	if player.Album != "" {
+		content.Lines = append(content.Lines, "album: "+player.Album)
+	}
+
+	if player.HasDuration {
+		content.Controls = append(content.Controls, control{
+			Name: "position", Value: player.Position, Max: player.Duration, Step: 10,
+			Format: func(value float64) string {
+				return playTime(value) + " / " + playTime(player.Duration)
+			},
+			Call: func(values map[string]float64) homeassistant.ServiceCall {
+				return mediaCall("media_seek", map[string]interface{}{
+					"seek_position": values["position"],
+				})
+			},
+		})
+		content.Commands["seek-forward"] = panelCommand{Controls: []string{"position"}, Steps: 1}
+		content.Commands["seek-backward"] = panelCommand{Controls: []string{"position"}, Steps: -1}
+	}
+	if player.HasVolume {
+		content.Controls = append(content.Controls, control{
+			Name: "volume", Value: player.Volume, Max: 100, Step: 5,
+			Format: func(value float64) string {
+				if player.Muted {
+					return fmt.Sprintf("%v%% (muted)", value)
+				}
+				return fmt.Sprintf("%v%%", value)
+			},
+			Call: func(values map[string]float64) homeassistant.ServiceCall {
+				return mediaCall("volume_set", map[string]interface{}{
+					"volume_level": values["volume"] / 100,
+				})
+			},
+		})
+		for name, command := range raiseLower("volume") {
+			content.Commands[name] = command
+		}
+	}
+	if len(player.Sources) > 0 {
+		content.Controls = append(content.Controls, choice(
+			"source", player.Sources, player.Source,
+			func(source string) homeassistant.ServiceCall {
+				return mediaCall("select_source", map[string]interface{}{"source": source})
+			},
+		))
+	}
+	if player.HasShuffle {
+		shuffle := "off"
+		if player.Shuffle {
+			shuffle = "on"
+		}
+		content.Controls = append(content.Controls, choice(
+			"shuffle", []string{"off", "on"}, shuffle,
+			func(shuffle string) homeassistant.ServiceCall {
+				return mediaCall("shuffle_set", map[string]interface{}{"shuffle": shuffle == "on"})
+			},
+		))
+	}
+	if player.Repeat != "" {
+		content.Controls = append(content.Controls, choice(
+			"repeat", []string{"off", "all", "one"}, player.Repeat,
+			func(repeat string) homeassistant.ServiceCall {
+				return mediaCall("repeat_set", map[string]interface{}{"repeat": repeat})
+			},
+		))
+	}
+	return content
+}
+
+// playTime shows seconds as m:ss (or h:mm:ss).
+func playTime(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// coverPanel controls a cover:
+// * position and tilt position (percent, each if the cover reports it)
+// * the commands open-cover, close-cover and stop-cover
+func coverPanel(state homeassistant.State) panelContent {
+	coverCall := func(service string, data map[string]interface{}) homeassistant.ServiceCall {
+		return homeassistant.ServiceCall{
+			Domain:  "cover",
+			Service: service,
+			Target:  homeassistant.Target{EntityID: []string{state.EntityID}},
+			Data:    data,
+		}
+	}
+	percent := func(value float64) string { return fmt.Sprintf("%v%%", value) }
+
+	content := panelContent{
+		Lines: []string{"state: " + stateText(state)},
+		Commands: map[string]panelCommand{
+			"open-cover":  {Call: coverCall("open_cover", nil)},
+			"close-cover": {Call: coverCall("close_cover", nil)},
+			"stop-cover":  {Call: coverCall("stop_cover", nil)},
+		},
+	}
+	positions := []struct {
+		name, attribute, service, key string
+	}{
+		{"position", "current_position", "set_cover_position", "position"},
+		{"tilt", "current_tilt_position", "set_cover_tilt_position", "tilt_position"},
+	}
+	for _, position := range positions {
+		value, ok := state.Attributes[position.attribute].(float64)
+		if !ok {
+			continue
+		}
+		position := position
+		content.Controls = append(content.Controls, control{
+			Name: position.name, Value: value, Max: 100, Step: 10,
+			Format: percent,
+			Call: func(values map[string]float64) homeassistant.ServiceCall {
+				return coverCall(position.service, map[string]interface{}{
+					position.key: values[position.name],
+				})
+			},
+		})
+	}
+	return content
+}
+
+// lockPanel controls a lock with the commands lock and unlock
+// (they ask for a code if the lock has a code_format).
+func lockPanel(state homeassistant.State) panelContent {
+	content := panelContent{
+		Lines: []string{"state: " + stateText(state)},
+		Commands: map[string]panelCommand{
+			"lock":   {Call: homeassistant.LockCall(state, true)},
+			"unlock": {Call: homeassistant.LockCall(state, false)},
+		},
+	}
+	if content.Commands["lock"].Call.NeedsCode {
+		content.Lines = append(content.Lines, "code: required")
+	}
+	return content
+}
+
+// alarmPanel controls an alarm panel with the commands arm-<mode>
+// (for every mode it supports) and disarm, they ask for a code if
+// the panel has a code_format.
+func alarmPanel(state homeassistant.State) panelContent {
+	content := panelContent{
+		Lines: []string{"state: " + stateText(state)},
+		Commands: map[string]panelCommand{
+			"disarm": {Call: homeassistant.AlarmCall(state, "")},
+		},
+	}
+	for _, mode := range homeassistant.AlarmModes(state) {
+		content.Commands["arm-"+mode] = panelCommand{Call: homeassistant.AlarmCall(state, mode)}
+	}
+	if content.Commands["disarm"].Call.NeedsCode {
+		content.Lines = append(content.Lines, "code: required")
+	}
+	return content
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/util"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// prompt is a line typed into the statusbar, e.g. /pattern:
+// * Active: true while the line is typed
+// * Prefix: the key that opened the prompt
+// * Text: the line typed so far
+// * Label: shown instead of the prefix (e.g. "code: ")
+// * Masked: the text is shown as * (e.g. for codes)
+type prompt struct {
+	Active bool
+	Prefix rune
+	Text   string
+	Label  string
+	Masked bool
+}
+
+// promptResult tells what a key did to a prompt.
+type promptResult int
+
+const (
+	promptEdited    promptResult = iota // Text was changed (or not).
+	promptConfirmed                     // Enter was pressed.
+	promptCancelled                     // Esc was pressed.
+)
+
+// handle applies a key to an active prompt.
+// Confirming or cancelling deactivates it.
+func (p *prompt) handle(event *tcell.EventKey) promptResult {
+	switch event.Key() {
+	case tcell.KeyEnter:
+		p.Active = false
+		return promptConfirmed
+	case tcell.KeyEsc:
+		p.Active = false
+		return promptCancelled
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if p.Text == "" { // like vi, backspacing over the prefix cancels.
+			p.Active = false
+			return promptCancelled
+		}
+		runes := []rune(p.Text)
+		p.Text = string(runes[:len(runes)-1])
+	case tcell.KeyRune:
+		p.Text += string(event.Rune())
+	}
+	return promptEdited
+}
+
+// String is the prompt as shown in the statusbar.
+func (p prompt) String() string {
+	label, text := string(p.Prefix), p.Text
+	if p.Label != "" {
+		label = p.Label
+	}
+	if p.Masked {
+		text = strings.Repeat("*", len([]rune(p.Text)))
+	}
+	return label + text
+}
+
+// searchable returns the strings a node can be found by:
+// its label and for entities the entity id and friendly name.
+func searchable(node *tview.TreeNode, store *homeassistant.Store) []string {
+	texts := []string{node.GetText()}
+	if entity := nodeEntity(node); entity != nil {
+		texts = append(texts, entity.EntityID)
+		if state, ok := store.Get(entity.EntityID); ok {
+			texts = append(texts, state.FriendlyName())
+		}
+	}
+	return texts
+}
+
+// nodeMatches reports whether a node contains pattern (ignoring case).
+func nodeMatches(
+	node *tview.TreeNode,
+	pattern string,
+	store *homeassistant.Store,
+) bool {
+	pattern = strings.ToLower(pattern)
+	for _, text := range searchable(node, store) {
+		if strings.Contains(strings.ToLower(text), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMatches returns all nodes below root that match pattern,
+// in the order they are displayed (collapsed nodes included).
+func findMatches(
+	root *tview.TreeNode,
+	pattern string,
+	store *homeassistant.Store,
+) []*tview.TreeNode {
+	var matches []*tview.TreeNode
+	if pattern == "" {
+		return matches
+	}
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+		if node != root && nodeMatches(node, pattern, store) {
+			matches = append(matches, node)
+		}
+		return true
+	})
+	return matches
+}
+
+// nextMatch returns the first match after current (before
+// it if backwards is set), wrapping around at the end.
+// It returns nil if nothing matches.
+func nextMatch(
+	root *tview.TreeNode,
+	current *tview.TreeNode,
+	pattern string,
+	store *homeassistant.Store,
+	backwards bool,
+) *tview.TreeNode {
+	matches := findMatches(root, pattern, store)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	// position of every node in display order:
+	position := map[*tview.TreeNode]int{}
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+		position[node] = len(position)
+		return true
+	})
+	here := position[current]
+
+	if backwards {
+		for i := len(matches) - 1; i >= 0; i-- {
+			if position[matches[i]] < here {
+				return matches[i]
+			}
+		}
+		return matches[len(matches)-1]
+	}
+	for _, match := range matches {
+		if position[match] > here {
+			return match
+		}
+	}
+	return matches[0]
+}
+
+// revealNode expands all ancestors of a node and selects it.
+func revealNode(view *tview.TreeView, node *tview.TreeNode) {
+	root := view.GetRoot()
+	for parent := util.GetParent(node, root); parent != nil; {
+		parent.Expand()
+		parent = util.GetParent(parent, root)
+	}
+	view.SetCurrentNode(node)
+}
+
+// filterTree returns a copy of root that only contains matching
+// nodes, their ancestors and descendants. Ancestors are copied,
+// matching nodes are shared with the original tree (so their
+// labels stay up to date). The copy is empty if nothing matches.
+func filterTree(
+	root *tview.TreeNode,
+	pattern string,
+	store *homeassistant.Store,
+) *tview.TreeNode {
+	filtered := copyNode(root, nil)
+	filtered.SetSelectable(false)
+	for _, child := range root.GetChildren() {
+		if node := filterNode(child, pattern, store); node != nil {
+			filtered.AddChild(node)
+		}
+	}
+	return filtered
+}
+
+// filterNode is filterTree below the root, it returns nil
+// if neither the node nor one of its descendants matches.
+func filterNode(
+	node *tview.TreeNode,
+	pattern string,
+	store *homeassistant.Store,
+) *tview.TreeNode {
+	if nodeMatches(node, pattern, store) {
+		return node
+	}
+
+	var children []*tview.TreeNode
+	for _, child := range node.GetChildren() {
+		if filtered := filterNode(child, pattern, store); filtered != nil {
+			children = append(children, filtered)
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	return copyNode(node, children)
+}
+
+// copyNode copies a node (but not its children).
+func copyNode(node *tview.TreeNode, children []*tview.TreeNode) *tview.TreeNode {
+	copied := tview.NewTreeNode(node.GetText())
+	copied.SetReference(node.GetReference())
+	copied.SetColor(node.GetColor())
+	copied.SetChildren(children)
+	return copied
+}
+
+// findNode returns node if it is part of the tree below root,
+// else a node with the same reference (e.g. its filtered copy
+// or original) or nil.
+func findNode(root, node *tview.TreeNode) *tview.TreeNode {
+	if node == nil {
+		return nil
+	}
+	var same, similar *tview.TreeNode
+	reference := node.GetReference()
+	root.Walk(func(current, parent *tview.TreeNode) bool {
+		if current == node {
+			same = current
+		} else if similar == nil && reference != nil &&
+			current.GetReference() == reference {
+			similar = current
+		}
+		return true
+	})
+	if same != nil {
+		return same
+	}
+	return similar
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// newSearchTree has two groups with two entities each,
+// the store knows the friendly name of one of them.
+func newSearchTree() (*tview.TreeNode, *homeassistant.Store) {
+	store := homeassistant.NewStore()
+	store.Set(homeassistant.State{
+		EntityID:   "light.b",
+		State:      "on",
+		Attributes: map[string]interface{}{"friendly_name": "Desk Lamp"},
+	})
+
+	root := tview.NewTreeNode(".")
+	matchNone := func(entityID string) bool { return false }
+	tree := newEntityTree(root, matchNone, byDomain, objectID, true)
+	for _, entityID := range []string{"light.a", "light.b", "switch.a", "switch.c"} {
+		tree.add(entityID)
+	}
+	return root, store
+}
+
+// labels returns the labels of nodes.
+func labels(nodes []*tview.TreeNode) []string {
+	var labels []string
+	for _, node := range nodes {
+		labels = append(labels, node.GetText())
+	}
+	return labels
+}
+
+func TestFindMatches(t *testing.T) {
+	root, store := newSearchTree()
+
+	tests := map[string]int{
+		"a":         3, // light.b is found by its friendly name.
+		"switch.":   2, // entity ids.
+		"desk lamp": 1, // friendly names, ignoring case.
+		"x":         0,
+		"":          0,
+	}
+	for pattern, expected := range tests {
+		if got := findMatches(root, pattern, store); len(got) != expected {
+			t.Errorf("matches of '%v' should be '%v', got '%v'", pattern, expected, labels(got))
+		}
+	}
+}
+
+func TestNextMatch(t *testing.T) {
+	root, store := newSearchTree()
+	lights, switches := root.GetChildren()[0], root.GetChildren()[1]
+	lightA, switchA, switchC := lights.GetChildren()[0], switches.GetChildren()[0], switches.GetChildren()[1]
+
+	if got := nextMatch(root, lightA, "switch.", store, false); got != switchA {
+		t.Errorf("next match should be 'switch.a', got '%v'", got.GetText())
+	}
+	if got := nextMatch(root, switchC, "switch", store, true); got != switchA {
+		t.Errorf("previous match should be 'switch.a', got '%v'", got.GetText())
+	}
+
+	// searches wrap around:
+	if got := nextMatch(root, switchC, "light", store, false); got != lights {
+		t.Errorf("next match should be 'light', got '%v'", got.GetText())
+	}
+	if got := nextMatch(root, lights, "c", store, true); got != switchC {
+		t.Errorf("previous match should be 'switch.c', got '%v'", got.GetText())
+	}
+
+	if got := nextMatch(root, lights, "x", store, false); got != nil {
+		t.Errorf("next match should be nil, got '%v'", got.GetText())
+	}
+}
+
+func TestRevealNode(t *testing.T) {
+	root, _ := newSearchTree()
+	view := tview.NewTreeView().SetRoot(root)
+	switches := root.GetChildren()[1]
+	switches.Collapse()
+
+	revealNode(view, switches.GetChildren()[1])
+	if !switches.IsExpanded() {
+		t.Errorf("switch should be expanded")
+	}
+	if view.GetCurrentNode() != switches.GetChildren()[1] {
+		t.Errorf("current node should be 'c', got '%v'", view.GetCurrentNode().GetText())
+	}
+}
+
+func TestFilterTree(t *testing.T) {
+	root, store := newSearchTree()
+	lights := root.GetChildren()[0]
+
+	filtered := filterTree(root, "desk", store)
+	if got := texts(filtered); len(got) != 1 || got[0] != "light" {
+		t.Fatalf("filtered groups should be '[light]', got '%v'", got)
+	}
+	copiedLights := filtered.GetChildren()[0]
+	if copiedLights == lights || nodeGroup(copiedLights) != nodeGroup(lights) {
+		t.Errorf("the light group should be a copy with the same reference")
+	}
+	if got := copiedLights.GetChildren(); len(got) != 1 || got[0] != lights.GetChildren()[1] {
+		t.Errorf("the matching entity should be shared, got '%v'", labels(got))
+	}
+	if findNode(filtered, lights) != copiedLights {
+		t.Errorf("findNode should find the copy of the light group")
+	}
+
+	// matching groups keep all their members:
+	filtered = filterTree(root, "switch", store)
+	if got := filtered.GetChildren(); len(got) != 1 || len(got[0].GetChildren()) != 2 {
+		t.Errorf("filtered switches should have 2 children")
+	}
+
+	if got := filterTree(root, "x", store).GetChildren(); len(got) != 0 {
+		t.Errorf("nothing should match 'x', got '%v'", labels(got))
+	}
+	if len(texts(root)) != 2 {
+		t.Errorf("the original tree should not be changed")
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	p := prompt{Active: true, Prefix: '/'}
+	for _, key := range "höx" {
+		p.handle(tcell.NewEventKey(tcell.KeyRune, key, tcell.ModNone))
+	}
+	p.handle(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone))
+	if p.String() != "/hö" {
+		t.Errorf("prompt should be '/hö', got '%v'", p.String())
+	}
+
+	result := p.handle(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if result != promptConfirmed || p.Active {
+		t.Errorf("Enter should confirm and deactivate the prompt")
+	}
+
+	p = prompt{Active: true, Prefix: '/'}
+	result = p.handle(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone))
+	if result != promptCancelled || p.Active {
+		t.Errorf("backspace on an empty prompt should cancel it")
+	}
+
+	p = prompt{Active: true, Label: "code: ", Masked: true}
+	for _, key := range "12ö4" {
+		p.handle(tcell.NewEventKey(tcell.KeyRune, key, tcell.ModNone))
+	}
+	if p.String() != "code: ****" || p.Text != "12ö4" {
+		t.Errorf("prompt should be 'code: ****' (12ö4), got '%v' (%v)", p.String(), p.Text)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/rivo/tview"
+)
+
+// groupFunc returns the names of the group nodes an entity is
+// placed in (outermost first). An empty path places it at the root.
+type groupFunc func(entityID string) []string
+
+// entityTree keeps a node for every entity below a root node,
+// grouped by a groupFunc, e.g. for discovery by domain:
+//
+//	root TreeNode
+//	  ├── light TreeNode
+//	  │     ├── hue_go_1 TreeNode
+//	  │     └── ...
+//	  ├── switch TreeNode
+//	  └── ...
+//
+// or by area and device:
+//
+//	root TreeNode
+//	  ├── living room TreeNode
+//	  │     ├── Hue Go TreeNode
+//	  │     │     └── light.hue_go_1 TreeNode
+//	  │     └── ...
+//	  └── ...
+type entityTree struct {
+	root     *tview.TreeNode
+	matches  func(entityID string) bool
+	nickName func(entityID string) string
+	group    groupFunc
+	sorted   bool
+
+	order  []string                   // entity ids in insertion order.
+	groups map[string]*tview.TreeNode // joined group path → node.
+	nodes  map[string]*tview.TreeNode // entity id → node.
+}
+
+// newEntityTree creates an empty tree below root:
+// * matches: entities that apply adds (and removes)
+// * group: the groups of an entity
+// * nickName: the label of an entity (without state)
+// * sorted: sort children, else keep the order of insertion
+func newEntityTree(
+	root *tview.TreeNode,
+	matches func(entityID string) bool,
+	group groupFunc,
+	nickName func(entityID string) string,
+	sorted bool,
+) *entityTree {
+	return &entityTree{
+		root:     root,
+		matches:  matches,
+		nickName: nickName,
+		group:    group,
+		sorted:   sorted,
+		groups:   map[string]*tview.TreeNode{},
+		nodes:    map[string]*tview.TreeNode{},
+	}
+}
+
+// apply adds and removes the nodes of matching entities.
+// The labels of the entity nodes are left to the caller.
+func (t *entityTree) apply(change homeassistant.Change) {
+	if !t.matches(change.EntityID) {
+		return
+	}
+	if change.Removed() {
+		t.remove(change.EntityID)
+	} else {
+		t.add(change.EntityID)
+	}
+}
+
+// add creates the node of an entity (and its groups).
+func (t *entityTree) add(entityID string) {
+	if _, known := t.nodes[entityID]; known {
+		return
+	}
+
+	nickName := t.nickName(entityID)
+	node := tview.NewTreeNode(nickName)
+	node.SetReference(
+		&homeassistant.Entity{
+			EntityID: entityID,
+			NickName: nickName,
+		},
+	)
+	t.nodes[entityID] = node
+	t.order = append(t.order, entityID)
+	t.insert(t.groupNode(entityID), node)
+}
+
+// remove deletes the node of an entity and its empty groups.
+func (t *entityTree) remove(entityID string) {
+	node, known := t.nodes[entityID]
+	if !known {
+		return
+	}
+	delete(t.nodes, entityID)
+	for i, id := range t.order {
+		if id == entityID {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+
+	path := t.group(entityID)
+	parent := t.groupNode(entityID)
+	parent.RemoveChild(node)
+
+	// remove groups from the inside out, as long as they are empty:
+	for i := len(path); i > 0 && len(parent.GetChildren()) == 0; i-- {
+		delete(t.groups, groupKey(path[:i]))
+		grandparent := t.root
+		if i > 1 {
+			grandparent = t.groups[groupKey(path[:i-1])]
+		}
+		grandparent.RemoveChild(parent)
+		parent = grandparent
+	}
+}
+
+// regroup moves all entity nodes into the groups of a new groupFunc.
+// The entity nodes themselves are kept (and thus their labels).
+func (t *entityTree) regroup(group groupFunc) {
+	for _, node := range t.groups {
+		node.ClearChildren()
+	}
+	for _, node := range t.nodes {
+		t.root.RemoveChild(node)
+	}
+	for _, node := range t.groups {
+		t.root.RemoveChild(node)
+	}
+
+	t.group = group
+	t.groups = map[string]*tview.TreeNode{}
+	for _, entityID := range t.order {
+		t.insert(t.groupNode(entityID), t.nodes[entityID])
+	}
+}
+
+// groupNode returns the innermost group of an entity,
+// missing groups are created on the way.
+func (t *entityTree) groupNode(entityID string) *tview.TreeNode {
+	parent := t.root
+	path := t.group(entityID)
+	for i, name := range path {
+		key := groupKey(path[:i+1])
+		node, ok := t.groups[key]
+		if !ok {
+			node = tview.NewTreeNode(name)
+			node.SetReference(&entityGroup{Name: name})
+			node.SetColor(tview.Styles.SecondaryTextColor)
+			t.groups[key] = node
+			t.insert(parent, node)
+		}
+		parent = node
+	}
+	return parent
+}
+
+// insert adds child to parent, sorted or at the end.
+func (t *entityTree) insert(parent, child *tview.TreeNode) {
+	if t.sorted {
+		insertSorted(parent, child)
+	} else {
+		parent.AddChild(child)
+	}
+}
+
+// entityGroup is the reference of group nodes.
+type entityGroup struct {
+	Name string
+}
+
+// nodeGroup returns the group attached to a tree node (or nil).
+func nodeGroup(node *tview.TreeNode) *entityGroup {
+	if node == nil {
+		return nil
+	}
+	group, _ := node.GetReference().(*entityGroup)
+	return group
+}
+
+// groupMembers returns the entity ids of all entities below a node.
+func groupMembers(node *tview.TreeNode) []string {
+	var entityIDs []string
+	node.Walk(func(node, parent *tview.TreeNode) bool {
+		if entity := nodeEntity(node); entity != nil {
+			entityIDs = append(entityIDs, entity.EntityID)
+		}
+		return true
+	})
+	return entityIDs
+}
+
+// switchable returns the entities that are either on or off
+// (e.g. lights and switches but not sensors).
+func switchable(entityIDs []string, store *homeassistant.Store) (on, off []string) {
+	for _, entityID := range entityIDs {
+		state, _ := store.Get(entityID)
+		switch state.State {
+		case "on":
+			on = append(on, entityID)
+		case "off":
+			off = append(off, entityID)
+		}
+	}
+	return on, off
+}
+
+// groupKey identifies a group by its path.
+func groupKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// byDomain groups entities by their domain.
+func byDomain(entityID string) []string {
+	return []string{homeassistant.Domain(entityID)}
+}
+
+// byArea groups entities by area and device. Entities without
+// device are placed directly in their area, entities without
+// area in the group "unassigned".
+func byArea(registry homeassistant.Registry) groupFunc {
+	return func(entityID string) []string {
+		path := []string{"unassigned"}
+		if area, ok := registry.Area(entityID); ok {
+			path[0] = area.Name
+		}
+		if device, ok := registry.Device(entityID); ok {
+			path = append(path, device.DisplayName())
+		}
+		return path
+	}
+}
+
+// objectID names entities without their domain (e.g. in domain groups).
+func objectID(entityID string) string {
+	_, object, _ := strings.Cut(entityID, ".")
+	return object
+}
+
+// insertSorted adds child to parent, keeping the children sorted
+// by their entity id (or text for nodes without entity).
+// Existing children are not moved.
+func insertSorted(parent, child *tview.TreeNode) {
+	children := parent.GetChildren()
+	i := sort.Search(len(children), func(i int) bool {
+		return sortKey(children[i]) > sortKey(child)
+	})
+
+	sorted := make([]*tview.TreeNode, 0, len(children)+1)
+	sorted = append(sorted, children[:i]...)
+	sorted = append(sorted, child)
+	sorted = append(sorted, children[i:]...)
+	parent.SetChildren(sorted)
+}
+
+// sortKey is the entity id of a node (its text if it has none).
+// Labels are not used as they change with the state.
+func sortKey(node *tview.TreeNode) string {
+	if entity := nodeEntity(node); entity != nil {
+		return entity.EntityID
+	}
+	return node.GetText()
+}
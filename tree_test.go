@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/rivo/tview"
+)
+
+// texts returns the labels of the children of a node.
+func texts(node *tview.TreeNode) []string {
+	var texts []string
+	for _, child := range node.GetChildren() {
+		texts = append(texts, child.GetText())
+	}
+	return texts
+}
+
+// added is the change of a new entity.
+func added(entityID string) homeassistant.Change {
+	return homeassistant.Change{
+		EntityID: entityID,
+		New:      homeassistant.State{EntityID: entityID, State: "on"},
+	}
+}
+
+// newDomainTree discovers all entities, grouped by domain.
+func newDomainTree(root *tview.TreeNode) *entityTree {
+	matchAll := func(entityID string) bool { return true }
+	return newEntityTree(root, matchAll, byDomain, objectID, true)
+}
+
+// testRegistry has a living room with a lamp and an
+// unassigned sensor (without device).
+func testRegistry() homeassistant.Registry {
+	return homeassistant.Registry{
+		Areas: map[string]homeassistant.Area{
+			"living_room": {AreaID: "living_room", Name: "Living Room"},
+		},
+		Devices: map[string]homeassistant.Device{
+			"d1": {ID: "d1", Name: "Hue Go", AreaID: "living_room"},
+			"d2": {ID: "d2", Name: "Plug", NameByUser: "Fan Plug"},
+		},
+		Entities: map[string]homeassistant.RegistryEntry{
+			"light.hue":   {EntityID: "light.hue", DeviceID: "d1"},
+			"switch.fan":  {EntityID: "switch.fan", DeviceID: "d2", AreaID: "living_room"},
+			"sensor.temp": {EntityID: "sensor.temp"},
+		},
+	}
+}
+
+func TestEntityTreeGroupsByDomain(t *testing.T) {
+	root := tview.NewTreeNode(".")
+	tree := newDomainTree(root)
+
+	tree.apply(added("switch.b"))
+	tree.apply(added("light.hue"))
+	tree.apply(added("switch.a"))
+	tree.apply(added("switch.a")) // known entities are not added twice.
+
+	if got := texts(root); len(got) != 2 || got[0] != "light" || got[1] != "switch" {
+		t.Errorf("domains should be '[light switch]', got '%v'", got)
+	}
+	switches := root.GetChildren()[1]
+	if got := texts(switches); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("switches should be '[a b]', got '%v'", got)
+	}
+	if entity := nodeEntity(switches.GetChildren()[0]); entity.EntityID != "switch.a" {
+		t.Errorf("EntityID should be 'switch.a', got '%v'", entity.EntityID)
+	}
+}
+
+func TestEntityTreeRemovesEntities(t *testing.T) {
+	root := tview.NewTreeNode(".")
+	tree := newDomainTree(root)
+
+	tree.apply(added("light.hue"))
+	tree.apply(added("switch.a"))
+	tree.apply(homeassistant.Change{EntityID: "switch.a"})
+
+	if got := texts(root); len(got) != 1 || got[0] != "light" {
+		t.Errorf("domains should be '[light]', got '%v'", got)
+	}
+
+	// removed entities come back:
+	tree.apply(added("switch.a"))
+	if got := texts(root); len(got) != 2 {
+		t.Errorf("domains should be '[light switch]', got '%v'", got)
+	}
+}
+
+func TestEntityTreeKeepsOrder(t *testing.T) {
+	root := tview.NewTreeNode(".")
+	matchNone := func(entityID string) bool { return false }
+	tree := newEntityTree(root, matchNone, byDomain, objectID, false)
+
+	tree.add("switch.b")
+	tree.add("light.hue")
+	tree.add("switch.a")
+	tree.apply(homeassistant.Change{EntityID: "switch.a"}) // does not match.
+
+	if got := texts(root); len(got) != 2 || got[0] != "switch" || got[1] != "light" {
+		t.Errorf("domains should be '[switch light]', got '%v'", got)
+	}
+	if got := texts(root.GetChildren()[0]); len(got) != 2 || got[0] != "b" || got[1] != "a" {
+		t.Errorf("switches should be '[b a]', got '%v'", got)
+	}
+}
+
+func TestEntityTreeRegroupByArea(t *testing.T) {
+	root := tview.NewTreeNode(".")
+	tree := newDomainTree(root)
+	tree.apply(added("light.hue"))
+	tree.apply(added("switch.fan"))
+	tree.apply(added("sensor.temp"))
+	hue := tree.nodes["light.hue"]
+
+	tree.regroup(byArea(testRegistry()))
+
+	if got := texts(root); len(got) != 2 || got[0] != "Living Room" || got[1] != "unassigned" {
+		t.Fatalf("areas should be '[Living Room unassigned]', got '%v'", got)
+	}
+	livingRoom := root.GetChildren()[0]
+	if got := texts(livingRoom); len(got) != 2 || got[0] != "Fan Plug" || got[1] != "Hue Go" {
+		t.Fatalf("devices should be '[Fan Plug Hue Go]', got '%v'", got)
+	}
+	if got := livingRoom.GetChildren()[1].GetChildren(); len(got) != 1 || got[0] != hue {
+		t.Errorf("Hue Go should contain the node of light.hue, got '%v'", got)
+	}
+	if got := texts(root.GetChildren()[1]); len(got) != 1 || got[0] != "temp" {
+		t.Errorf("unassigned should be '[temp]', got '%v'", got)
+	}
+
+	// nested groups are removed once empty:
+	tree.apply(homeassistant.Change{EntityID: "light.hue"})
+	if got := texts(livingRoom); len(got) != 1 || got[0] != "Fan Plug" {
+		t.Errorf("devices should be '[Fan Plug]', got '%v'", got)
+	}
+	tree.apply(homeassistant.Change{EntityID: "switch.fan"})
+	if got := texts(root); len(got) != 1 || got[0] != "unassigned" {
+		t.Errorf("areas should be '[unassigned]', got '%v'", got)
+	}
+}
+
+func TestDiscoveryConfigMatches(t *testing.T) {
+	config := DiscoveryConfig{
+		Include: []string{"light.*", "sensor.*"},
+		Exclude: []string{"sensor.*_battery"},
+	}
+	tests := map[string]bool{
+		"light.hue":            true,
+		"sensor.temperature":   true,
+		"sensor.phone_battery": false,
+		"switch.fan":           false,
+	}
+	for entityID, expected := range tests {
+		if got := config.Matches(entityID); got != expected {
+			t.Errorf("Matches(%v) should be '%v', got '%v'", entityID, expected, got)
+		}
+	}
+
+	if !(DiscoveryConfig{}).Matches("switch.fan") {
+		t.Errorf("an empty include list should match everything")
+	}
+}
+
+func TestGroupToggle(t *testing.T) {
+	store := homeassistant.NewStore()
+	store.Replace([]homeassistant.State{
+		{EntityID: "light.a", State: "off"},
+		{EntityID: "light.b", State: "on"},
+		{EntityID: "sensor.c", State: "21"},
+	})
+	root := tview.NewTreeNode(".")
+	tree := newDomainTree(root)
+	for _, state := range store.All() {
+		tree.add(state.EntityID)
+	}
+	lights, sensors := root.GetChildren()[0], root.GetChildren()[1]
+
+	call, err := groupToggle(lights, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Domain != "homeassistant" || call.Service != "turn_off" {
+		t.Errorf("call should be 'homeassistant.turn_off', got '%v.%v'", call.Domain, call.Service)
+	}
+	if len(call.Target.EntityID) != 1 || call.Target.EntityID[0] != "light.b" {
+		t.Errorf("target should be '[light.b]', got '%v'", call.Target.EntityID)
+	}
+
+	store.Set(homeassistant.State{EntityID: "light.b", State: "off"})
+	call, _ = groupToggle(lights, store)
+	if call.Service != "turn_on" || len(call.Target.EntityID) != 2 {
+		t.Errorf("call should turn on '[light.a light.b]', got '%v' '%v'", call.Service, call.Target.EntityID)
+	}
+
+	_, err = groupToggle(sensors, store)
+	if err == nil || err.Error() != "sensor has no entities to toggle" {
+		t.Errorf("err should be 'sensor has no entities to toggle', got '%v'", err)
+	}
+}
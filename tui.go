@@ -1,8 +1,11 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/bmedicke/bhdr/homeassistant"
 	"github.com/bmedicke/bhdr/util"
@@ -14,6 +17,16 @@ import (
 //
 // app Application
 //   │
+// pages Pages
+//   ├── frame Frame (main page, see below)
+//   ├── panelLayout Flex (FlexRow, panel page)
+//   │     └── panelRow Flex (FlexColumn)
+//   │           └── panel (of the selected entity, see panel.go)
+//   ├── confirm Modal (confirm page)
+//   └── whichKeyLayout Flex (FlexRow, which-key page)
+//         └── whichKeyRow Flex (FlexColumn)
+//               └── whichKey TextView
+//
 // frame Frame
 //   │
 // outerLayout Flex (FlexRow)
@@ -22,41 +35,91 @@ import (
 //   │     ├── switches TreeView
 //   │     │    └── switchesRoot TreeNode
 //   │     │					└── haEntities TreeNode
-//   │     │					      └── ...
-//   │     └── status TextView
-//   ├── statusbar TextView
-//   └── logs TextView
+//   │     │					      └── entities (entityTree)
+//   │     └── details Flex (FlexRow)
+//   │           ├── inspector TextView
+//   │           └── status TextView
+//   ├── logs TextView
+//   └── statusLine Flex (FlexColumn)
+//         ├── statusbar TextView
+//         └── connection TextView
 
-func spawnTUI(config map[string]interface{}, showLogs bool) {
-	// channels for communicating with home-assistant:
-	haEvents := make(chan string)
-	haCommands := make(chan homeassistant.Command)
+// requestTimeout is how long to wait for HA to answer a request.
+const requestTimeout = 10 * time.Second
 
-	// create HA config from global config:
-	haConfig := homeassistant.Config{
-		Scheme: config["scheme"].(string),
-		Server: config["server"].(string),
-		Token:  config["token"].(string),
-	}
+func spawnTUI(config Config, showLogs bool) {
+	app, client := newTUI(config, showLogs)
+
+	// connect to Home Assistant:
+	go client.Run()
 
+	app.Run()
+}
+
+// newTUI creates all views and wires them to a client,
+// which is returned unconnected (call its Run method).
+func newTUI(
+	config Config,
+	showLogs bool,
+) (*tview.Application, *homeassistant.Client) {
 	// create node for home-assistant entities:
 	haEntities := tview.NewTreeNode("home-assistant")
-	haEntities.SetReference(homeassistant.Data{})
-	entitySlice := config["ha-entities"].([]interface{})
-
-	// fill entities with nodes:
-	for _, entityJSON := range entitySlice {
-		entityMap := entityJSON.(map[string]interface{})
-		entity := tview.NewTreeNode(entityMap["id"].(string))
-		entity.SetReference(
-			homeassistant.Data{
-				EntityID: entityMap["entity-id"].(string),
-				NickName: entityMap["id"].(string),
-			},
-		)
-		haEntities.AddChild(entity)
+
+	// configured entities keep their order (unless discovery sorts all)
+	// and nickname, discovered ones are added once their state arrives:
+	nickNames := map[string]string{}
+	configGroups := map[string][]string{}
+	walkEntities(config.HAEntities, "ha-entities", nil, func(
+		entity EntityConfig,
+		path string,
+		groups []string,
+	) {
+		if entity.EntityID != "" {
+			nickNames[entity.EntityID] = entity.ID
+			configGroups[entity.EntityID] = groups
+		}
+	})
+	nickName := func(entityID string) string {
+		if nickName, ok := nickNames[entityID]; ok {
+			return nickName
+		}
+		if config.Layout == "flat" {
+			return objectID(entityID) // the domain is the group.
+		}
+		return entityID
+	}
+	discovered := func(entityID string) bool {
+		return config.Discovery.Enabled && config.Discovery.Matches(entityID)
 	}
 
+	// the areas layout uses the configured groups
+	// until the registries are fetched:
+	group := func(entityID string) []string {
+		if groups, ok := configGroups[entityID]; ok {
+			return groups
+		}
+		return byDomain(entityID)
+	}
+	entities := newEntityTree(
+		haEntities,
+		discovered,
+		group,
+		nickName,
+		config.Discovery.Enabled,
+	)
+	walkEntities(config.HAEntities, "ha-entities", nil, func(
+		entity EntityConfig,
+		path string,
+		groups []string,
+	) {
+		if entity.EntityID != "" {
+			entities.add(entity.EntityID)
+		}
+	})
+
+	// client for communicating with home-assistant:
+	client := homeassistant.NewClient(config.HomeAssistant())
+
 	// create root tree node for the switches view:
 	switchesRoot := tview.NewTreeNode(".")
 	switchesRoot.SetSelectable(false)
@@ -68,10 +131,31 @@ func spawnTUI(config map[string]interface{}, showLogs bool) {
 	statusbar := tview.NewTextView()
 	statusbar.SetBackgroundColor(tcell.ColorDarkOliveGreen)
 
+	// create the connection view (right side of the statusbar):
+	connection := tview.NewTextView()
+	connection.SetTextAlign(tview.AlignRight)
+	connection.SetBackgroundColor(tcell.ColorDarkOliveGreen)
+	connection.SetText("connecting")
+
+	statusLine := tview.NewFlex()
+	statusLine.AddItem(statusbar, 0, 1, false)
+	statusLine.AddItem(connection, 0, 2, false)
+
+	// create the command line (replaces the statusbar while active):
+	commandLine := tview.NewInputField()
+	commandLine.SetLabel(":")
+	commandLine.SetLabelColor(tcell.ColorWhite)
+	commandLine.SetFieldBackgroundColor(tcell.ColorDarkOliveGreen)
+	commandLine.SetBackgroundColor(tcell.ColorDarkOliveGreen)
+
 	// create the status view:
 	status := tview.NewTextView()
 	status.SetBorder(true).SetTitle("status")
 
+	// create the inspector (shows the selected node, see inspect):
+	inspector := tview.NewTextView()
+	inspector.SetBorder(true).SetTitle("inspector")
+
 	// create the switches view:
 	switches := tview.NewTreeView()
 	switches.SetBorder(true).SetTitle("switches")
@@ -82,7 +166,10 @@ func spawnTUI(config map[string]interface{}, showLogs bool) {
 	// create the layout:
 	innerLayout := tview.NewFlex()
 	innerLayout.AddItem(switches, 0, 1, false)
-	innerLayout.AddItem(status, 0, 1, false)
+	details := tview.NewFlex().SetDirection(tview.FlexRow)
+	details.AddItem(inspector, 0, 2, false)
+	details.AddItem(status, 0, 1, false)
+	innerLayout.AddItem(details, 0, 1, false)
 	outerLayout := tview.NewFlex().SetDirection(tview.FlexRow)
 	outerLayout.AddItem(innerLayout, 0, 2, false)
 
@@ -98,181 +185,1099 @@ func spawnTUI(config map[string]interface{}, showLogs bool) {
 		logs.SetTitle("logs").SetBorder(true)
 		outerLayout.AddItem(logs, 0, 2, false)
 	}
-	outerLayout.AddItem(statusbar, 1, 0, false)
+	outerLayout.AddItem(statusLine, 1, 0, false)
+
+	// create the which-key popup, it lists the keys that continue
+	// a chord in the bottom right corner (sized when shown):
+	whichKey := tview.NewTextView()
+	whichKey.SetDynamicColors(true)
+	whichKey.SetBorder(true).SetTitle("which-key")
+	whichKeyRow := tview.NewFlex()
+	whichKeyRow.AddItem(nil, 0, 1, false)
+	whichKeyRow.AddItem(whichKey, 0, 0, false)
+	whichKeyLayout := tview.NewFlex().SetDirection(tview.FlexRow)
+	whichKeyLayout.AddItem(nil, 0, 1, false)
+	whichKeyLayout.AddItem(whichKeyRow, 0, 0, false)
+	whichKeyLayout.AddItem(nil, 1, 0, false) // keep the statusLine visible.
+
+	// create the panel popup, it controls an entity in the
+	// center of the screen (filled and sized when opened):
+	panelRow := tview.NewFlex()
+	panelLayout := tview.NewFlex().SetDirection(tview.FlexRow)
+	panelLayout.AddItem(nil, 0, 1, false)
+	panelLayout.AddItem(panelRow, 0, 0, false)
+	panelLayout.AddItem(nil, 0, 1, false)
+
+	// create the confirmation dialog for calls to sensitive entities:
+	confirm := tview.NewModal()
+	confirm.AddButtons([]string{"yes", "no"})
+
+	// stack the popups on top of the frame:
+	pages := tview.NewPages()
+	pages.AddPage("main", frame, true, true)
+	pages.AddPage("panel", panelLayout, true, false)
+	pages.AddPage("confirm", confirm, false, false)
+	pages.AddPage("which-key", whichKeyLayout, true, false)
 
 	// create the app:
 	app := tview.NewApplication()
-	app.SetRoot(frame, true)
+	app.SetRoot(pages, true)
 	app.SetFocus(switches)
 
+	// show the which-key popup (or hide it if there are no hints),
+	// pages move the focus to the top page, so it is restored:
+	whichKeyShown := false
+	showWhichKey := func(hints []util.ChordHint) {
+		if len(hints) == 0 && !whichKeyShown {
+			return
+		}
+		focused := app.GetFocus()
+		if len(hints) == 0 {
+			pages.HidePage("which-key")
+		} else {
+			lines := make([]string, len(hints))
+			width := 0
+			for i, hint := range hints {
+				lines[i] = fmt.Sprintf(
+					"[yellow]%v[-]  %v",
+					tview.Escape(hint.Key),
+					tview.Escape(hint.Description),
+				)
+				if w := tview.TaggedStringWidth(lines[i]); w > width {
+					width = w
+				}
+			}
+			whichKey.SetText(strings.Join(lines, "\n"))
+			whichKeyRow.ResizeItem(whichKey, width+2, 0) // +2 for the border.
+			whichKeyLayout.ResizeItem(whichKeyRow, len(lines)+2, 0)
+			pages.ShowPage("which-key")
+		}
+		whichKeyShown = len(hints) > 0
+		if focused != nil {
+			app.SetFocus(focused)
+		}
+	}
+
+	// send a request without blocking the UI, report failures in status:
+	request := func(
+		description string,
+		send func(ctx context.Context) error,
+	) {
+		go func() {
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				requestTimeout,
+			)
+			defer cancel()
+
+			if err := send(ctx); err != nil {
+				app.QueueUpdateDraw(func() {
+					status.SetText(fmt.Sprintf("%v failed: %v", description, err))
+				})
+			}
+		}()
+	}
+
+	// call a service without blocking the UI:
+	sendCall := func(description string, call homeassistant.ServiceCall) {
+		request(description, func(ctx context.Context) error {
+			_, err := client.Call(
+				ctx,
+				call.Domain,
+				call.Service,
+				call.Target,
+				call.Data,
+			)
+			return err
+		})
+	}
+
+	// the call that waits for its code or confirmation (nil if none),
+	// the code prompt and the focus to restore after the dialog:
+	var pending *homeassistant.ServiceCall
+	var pendingDescription string
+	var code prompt
+	var confirmFocus tview.Primitive
+	sensitive := config.SensitiveEntities()
+
+	// send the pending call once it is confirmed (or drop it):
+	finishPending := func(confirmed bool) {
+		if confirmFocus != nil {
+			pages.HidePage("confirm")
+			app.SetFocus(confirmFocus)
+			confirmFocus = nil
+		}
+		if confirmed {
+			sendCall(pendingDescription, *pending)
+			status.SetText(pendingDescription)
+		} else {
+			status.SetText(pendingDescription + " cancelled")
+		}
+		pending = nil
+	}
+	confirm.SetDoneFunc(func(index int, label string) {
+		finishPending(label == "yes")
+	})
+
+	// ask to confirm the pending call if it may reach a sensitive entity:
+	confirmPending := func() {
+		if !needsConfirmation(*pending, sensitive) {
+			finishPending(true)
+			return
+		}
+		confirmFocus = app.GetFocus()
+		confirm.SetText(pendingDescription + "?")
+		confirm.SetFocus(1) // no.
+		pages.ShowPage("confirm")
+		app.SetFocus(confirm)
+	}
+
+	// call a service, after asking for a code (if the call needs one)
+	// and a confirmation (if it targets a sensitive entity):
+	callService := func(description string, call homeassistant.ServiceCall) {
+		if pending != nil {
+			status.SetText(fmt.Sprintf("%v dropped, %v is waiting", description, pendingDescription))
+			return
+		}
+		pending, pendingDescription = &call, description
+		if call.NeedsCode {
+			code = prompt{Active: true, Label: "code: ", Masked: true}
+			statusbar.SetText(code.String())
+			return
+		}
+		confirmPending()
+	}
+
+	// keys go to the code prompt or the confirmation dialog first:
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case code.Active:
+			switch code.handle(event) {
+			case promptConfirmed:
+				data := map[string]interface{}{"code": code.Text}
+				for key, value := range pending.Data {
+					data[key] = value
+				}
+				pending.Data = data
+				statusbar.SetText("")
+				confirmPending()
+			case promptCancelled:
+				statusbar.SetText("")
+				finishPending(false)
+			default:
+				statusbar.SetText(code.String())
+			}
+			return nil
+		case confirmFocus != nil:
+			switch event.Rune() {
+			case 'y':
+				finishPending(true)
+				return nil
+			case 'n', 'q':
+				finishPending(false)
+				return nil
+			}
+		}
+		return event
+	})
+
+	// toggle an entity or all members of a group:
+	toggle := func(node *tview.TreeNode) error {
+		if nodeGroup(node) != nil {
+			call, err := groupToggle(node, client.Store())
+			if err != nil {
+				return err
+			}
+			callService(call.Service+" "+nodeGroup(node).Name, *call)
+			return nil
+		}
+		entity := nodeEntity(node)
+		if entity == nil {
+			return nil
+		}
+		call, err := homeassistant.ToggleCall(nodeState(node, client.Store()))
+		if err != nil {
+			return err
+		}
+		callService(call.Service+" "+entity.EntityID, call)
+		return nil
+	}
+
 	// for keeping track of vi-like key chords:
 	chord := util.KeyChord{Active: false, Buffer: "", Action: ""}
-	chordmap := config["chordmap"].(map[string]interface{})
+	chordmap := config.Chordmap
+
+	// registers for copying and pasting entity attributes:
+	registers := homeassistant.NewRegisters()
+
+	// send the action of a concluded chord to HA:
+	runChord := func(selection *tview.TreeNode) {
+		if chord.Action == "" {
+			return
+		}
+		status.SetText(chord.Action)
+		state := nodeState(selection, client.Store())
+		call, err := chordCall(chord, registers, state)
+		if err != nil {
+			status.SetText(fmt.Sprint(err))
+		} else if call != nil {
+			callService(chord.Action, *call)
+		}
+		chord.Action = ""
+		chord.Register = 0
+	}
+
+	// search (/) and filter (&) prompts, the last search pattern
+	// for n and N and the pattern of an active filter:
+	var search prompt
+	var searchOrigin *tview.TreeNode
+	var lastPattern, filterPattern string
+
+	// keymap (validated with the config) and the key sequence in progress:
+	keymap := config.buildKeymap(func(path string, err error) {})
+	var keys util.KeySequence
+
+	// functions of the named actions of the keymap, they get
+	// the [count] typed before (0 if none), set below:
+	var keyActions map[string]func(count int)
+
+	// run the action bound to a key in a scope (or the global one),
+	// it reports whether the key was part of a bound sequence:
+	handleKey := func(scope string, event *tcell.EventKey, count int) bool {
+		action, consumed := keymap.Handle(
+			&keys,
+			[]string{scope, util.GlobalScope},
+			util.NewKeySpec(event),
+		)
+		if run, ok := keyActions[action]; ok {
+			run(count)
+		}
+		return consumed
+	}
+
+	// (re)starts or stops the timeout of a chord's number, set below:
+	var waitForChord func()
+
+	// show a prompt or the keys in progress (count, sequence, chord)
+	// and the keys that continue a chord:
+	showKeys := func() {
+		waitForChord()
+		if code.Active {
+			statusbar.SetText(code.String())
+			showWhichKey(nil)
+			return
+		}
+		if search.Active {
+			statusbar.SetText(search.String())
+			showWhichKey(nil)
+			return
+		}
+		showWhichKey(util.ChordHints(chord, chordmap, homeassistant.DescribeAction))
+		text := keys.String() + chord.Buffer
+		if chord.Register != 0 {
+			text = `"` + string(chord.Register) + text
+		}
+		if chord.Count > 0 {
+			text = fmt.Sprint(chord.Count) + text
+		}
+		statusbar.SetText(text)
+	}
+
+	// filter the switches view (or show the whole tree again):
+	applyFilter := func(pattern string) {
+		current := switches.GetCurrentNode()
+		root := switchesRoot
+		if pattern != "" {
+			root = filterTree(switchesRoot, pattern, client.Store())
+		}
+		switches.SetRoot(root)
+		if current != nil {
+			if node := findNode(root, current); node != nil {
+				switches.SetCurrentNode(node)
+			}
+		}
+		if switches.GetCurrentNode() == nil { // e.g. nothing matched before.
+			util.SelectLine(switches, 1)
+		}
+	}
+
+	// handle a key typed into the search or filter prompt:
+	handleSearch := func(event *tcell.EventKey) {
+		result := search.handle(event)
+		switch search.Prefix {
+		case '/':
+			switch result {
+			case promptEdited:
+				if search.Text == "" {
+					switches.SetCurrentNode(searchOrigin)
+				} else if match := nextMatch(
+					switches.GetRoot(),
+					searchOrigin,
+					search.Text,
+					client.Store(),
+					false,
+				); match != nil {
+					revealNode(switches, match)
+					status.SetText("")
+				} else {
+					status.SetText("pattern not found: " + search.Text)
+				}
+			case promptConfirmed:
+				if search.Text != "" {
+					lastPattern = search.Text
+				}
+			case promptCancelled:
+				switches.SetCurrentNode(searchOrigin)
+			}
+		case '&':
+			switch result {
+			case promptEdited:
+				applyFilter(search.Text)
+				if matches := findMatches(
+					switches.GetRoot(),
+					search.Text,
+					client.Store(),
+				); len(matches) > 0 {
+					switches.SetCurrentNode(matches[0])
+				}
+			case promptConfirmed:
+				filterPattern = search.Text
+				applyFilter(filterPattern)
+			case promptCancelled:
+				applyFilter(filterPattern)
+				if node := findNode(switches.GetRoot(), searchOrigin); node != nil {
+					switches.SetCurrentNode(node)
+				}
+			}
+		}
+		if search.Active {
+			statusbar.SetText(search.String())
+		} else {
+			statusbar.SetText("")
+		}
+	}
+
+	// jump to the next (or previous) match of the last search:
+	jumpToMatch := func(backwards bool) {
+		if lastPattern == "" {
+			return
+		}
+		match := nextMatch(
+			switches.GetRoot(),
+			switches.GetCurrentNode(),
+			lastPattern,
+			client.Store(),
+			backwards,
+		)
+		if match == nil {
+			status.SetText("pattern not found: " + lastPattern)
+			return
+		}
+		revealNode(switches, match)
+	}
 
 	// switches keybindings:
 	switches.SetInputCapture(
 		func(event *tcell.EventKey) *tcell.EventKey {
+			if search.Active {
+				handleSearch(event)
+				return nil
+			}
+
 			selection := switches.GetCurrentNode()
 			key := event.Rune()
 
 			if event.Key() == tcell.KeyEsc {
 				util.ResetChord(&chord)
+				keys.Pending = nil
+
+				// clear the filter:
+				if filterPattern != "" {
+					filterPattern = ""
+					applyFilter("")
+					if selection != nil {
+						if node := findNode(switchesRoot, selection); node != nil {
+							revealNode(switches, node)
+						}
+					}
+				}
+			}
+
+			if event.Key() == tcell.KeyEnter && chord.Active {
+				// conclude the number of a chord:
+				if err := util.ConcludeChord(&chord, chordmap); err != nil {
+					status.SetText(fmt.Sprint(err))
+				}
+				runChord(selection)
+				showKeys()
+				return nil
 			}
 
 			if chord.Active {
 				if err := util.HandleChords(key, &chord, chordmap); err != nil {
 					status.SetText(fmt.Sprint(err))
 				}
-				if chord.Action != "" {
-					status.SetText(chord.Action)
+				runChord(selection)
+			} else if len(keys.Pending) == 0 && util.HandleCount(key, &chord) {
+				showKeys()
+				return nil
+			} else if handleKey("switches", event, chord.Count) {
+				// the [count] is kept until the sequence is complete:
+				if len(keys.Pending) == 0 {
+					chord.Count = 0
 				}
+				showKeys()
+				return nil
 			} else {
-				switch key {
-				case 'J', 'K': // disable tview's default bindings.
+				chord.Count = 0 // a [count] only applies to the next key.
+				_, nomen := chordmap[string(key)]
+				switch {
+				case key == 'J' || key == 'K': // disable tview's default bindings.
+					showKeys()
 					return nil
-				case 'H', 'L', 'h', 'l': // use custom vi bindings:
-					util.IntuitiveViBindings(key, switches)
-					return nil // disable defaults.
-				case 'c', 'd', 'o', 'y', 'p': // runes that start a chord:
+				case nomen || key == '"': // runes that start a chord:
 					if err := util.HandleChords(key, &chord, chordmap); err != nil {
 						status.SetText(fmt.Sprint(err))
 					}
-				case 'i': // print information about current node.
-					if parent := util.GetParent(selection, switchesRoot); parent != nil {
-						t := "parent: " + parent.GetText() +
-							"\ncurrent: " + selection.GetText()
-						status.SetText(t)
-					} else {
-						t := "no parent found" +
-							"\ncurrent: " + selection.GetText()
-						status.SetText(t)
-					}
-				case 'R': // refetch all states from HA.
-					haCommands <- homeassistant.Command{
-						Type: "get_states",
-					}
-				case ';': // toggle entity.
-					haCommands <- homeassistant.Command{
-						EntityID: selection.GetReference().(homeassistant.Data).EntityID,
-						Service:  "toggle",
-						Type:     "call_service",
-						Domain:   true,
-					}
 				}
 			}
-			statusbar.SetText(chord.Buffer)
+			showKeys()
 			return event
 		},
 	)
 
+	// the open panel (nil if none is open):
+	var activePanel *panel
+
+	// panel keybindings, a [count] repeats the action:
+	panelCapture := func(event *tcell.EventKey) *tcell.EventKey {
+		if len(keys.Pending) == 0 && util.HandleCount(event.Rune(), &chord) {
+			showKeys()
+			return nil
+		}
+		handleKey("panel", event, chord.Count)
+		if len(keys.Pending) == 0 {
+			chord.Count = 0
+		}
+		showKeys()
+		return nil
+	}
+
+	// open the panel of the selected entity:
+	openPanel := func() {
+		entity := nodeEntity(switches.GetCurrentNode())
+		if entity == nil {
+			status.SetText("select an entity to open its panel")
+			return
+		}
+		p := newPanel(entity.EntityID, client.Store(), callService, func(f func()) {
+			app.QueueUpdateDraw(f)
+		})
+		if p == nil {
+			status.SetText(fmt.Sprintf("no panel for %v", entity.EntityID))
+			return
+		}
+		p.SetInputCapture(panelCapture)
+		p.keys = func(command string) string {
+			return strings.Join(keymap.Keys("panel", command), " ")
+		}
+		panelRow.Clear()
+		panelRow.AddItem(nil, 0, 1, false)
+		panelRow.AddItem(p, panelWidth, 0, true)
+		panelRow.AddItem(nil, 0, 1, false)
+		panelLayout.ResizeItem(panelRow, p.height(), 0)
+		pages.ShowPage("panel")
+		app.SetFocus(p)
+		activePanel = p
+	}
+	closePanel := func() {
+		if activePanel == nil {
+			return
+		}
+		activePanel = nil
+		pages.HidePage("panel")
+		app.SetFocus(switches)
+	}
+
+	// conclude the number of a chord after a pause, the timer
+	// only runs while a chord with a number is typed:
+	var chordTimer *time.Timer
+	waitForChord = func() {
+		pending := chord.Active && len([]rune(chord.Buffer)) > 2
+		switch {
+		case pending && chordTimer == nil:
+			chordTimer = time.AfterFunc(util.ChordTimeout, func() {
+				app.QueueUpdateDraw(func() {
+					if !util.ChordTimedOut(&chord, time.Now()) {
+						return
+					}
+					if err := util.ConcludeChord(&chord, chordmap); err != nil {
+						status.SetText(fmt.Sprint(err))
+					}
+					runChord(switches.GetCurrentNode())
+					showKeys()
+				})
+			})
+		case pending:
+			chordTimer.Reset(util.ChordTimeout)
+		case chordTimer != nil:
+			chordTimer.Stop()
+		}
+	}
+
 	// logs keybindings:
 	if showLogs {
 		logs.SetInputCapture(
 			func(event *tcell.EventKey) *tcell.EventKey {
-				key := event.Rune()
-
-				switch key {
-				case 'd':
-					logs.SetText("")
-				case 'w':
-					util.OverwriteFile("bhdr_log.json", logs.GetText(true))
+				consumed := handleKey("logs", event, 0)
+				showKeys()
+				if consumed {
+					return nil
 				}
 				return event
 			},
 		)
 	}
 
-	// global keybindings:
-	app.SetInputCapture(
-		func(event *tcell.EventKey) *tcell.EventKey {
-			key := event.Rune()
+	// command line history (saved in the history file):
+	history, err := util.NewHistory(config.HistoryFile)
+	if err != nil {
+		status.SetText(fmt.Sprintf("history: %v", err))
+	}
+
+	// services for completing :call, fetched once online:
+	var services map[string]map[string]homeassistant.Service
+
+	// run a line of the command line:
+	runCommand := func(line string) error {
+		words, err := util.SplitCommand(line)
+		if err != nil || len(words) == 0 {
+			return err
+		}
+		command, args := words[0], words[1:]
+
+		switch command {
+		case "call":
+			call, err := parseCall(args)
+			if err != nil {
+				return err
+			}
+			callService(line, call)
+		case "toggle":
+			name := strings.Join(args, " ")
+			if node := findNamedNode(switchesRoot, name); node != nil {
+				return toggle(node)
+			}
+			if !strings.Contains(name, ".") {
+				return fmt.Errorf("no entity or group named [%v]", name)
+			}
+			state, ok := client.Store().Get(name)
+			if !ok {
+				state.EntityID = name
+			}
+			call, err := homeassistant.ToggleCall(state)
+			if err != nil {
+				return err
+			}
+			callService(call.Service+" "+name, call)
+		case "reload":
+			request("reload", func(ctx context.Context) error {
+				if _, err := client.GetStates(ctx); err != nil {
+					return err
+				}
+				fetched, err := client.GetServices(ctx)
+				if err != nil {
+					return err
+				}
+				app.QueueUpdateDraw(func() {
+					services = fetched
+					status.SetText("reloaded")
+				})
+				return nil
+			})
+		case "w":
+			if logs == nil {
+				return fmt.Errorf("no logs to write (start with --show-logs)")
+			}
+			file := "bhdr_log.json"
+			if len(args) > 0 {
+				file = args[0]
+			}
+			if err := util.OverwriteFile(file, logs.GetText(true)); err != nil {
+				return err
+			}
+			status.SetText(fmt.Sprintf("written %v", file))
+		case "q", "quit":
+			app.Stop()
+		default:
+			return fmt.Errorf("not a command: %v", command)
+		}
+		return nil
+	}
+
+	// open the command line in place of the statusbar:
+	var focusBefore tview.Primitive
+	openCommandLine := func() {
+		focusBefore = app.GetFocus()
+		commandLine.SetText("")
+		statusLine.Clear()
+		statusLine.AddItem(commandLine, 0, 1, true)
+		statusLine.AddItem(connection, 0, 2, false)
+		app.SetFocus(commandLine)
+	}
+	closeCommandLine := func() {
+		statusLine.Clear()
+		statusLine.AddItem(statusbar, 0, 1, false)
+		statusLine.AddItem(connection, 0, 2, false)
+		app.SetFocus(focusBefore)
+	}
 
-			switch key {
-			case '[': // focus switches view.
-				if showLogs {
-					app.SetFocus(switches)
-					switches.SetBorderColor(tcell.ColorGreen)
-					logs.SetBorderColor(tcell.ColorWhite)
+	// command line keybindings:
+	commandLine.SetInputCapture(
+		func(event *tcell.EventKey) *tcell.EventKey {
+			switch event.Key() {
+			case tcell.KeyTab: // complete the last word.
+				var entityIDs []string
+				for _, state := range client.Store().All() {
+					entityIDs = append(entityIDs, state.EntityID)
+				}
+				line, matches := completeCommand(
+					commandLine.GetText(),
+					completionSources{
+						services:  services,
+						entityIDs: entityIDs,
+						names:     nodeNames(switchesRoot),
+					},
+				)
+				commandLine.SetText(line)
+				if len(matches) > 1 {
+					status.SetText(strings.Join(matches, "\n"))
 				}
-			case ']': // focus logs view.
-				if showLogs {
-					app.SetFocus(logs)
-					switches.SetBorderColor(tcell.ColorWhite)
-					logs.SetBorderColor(tcell.ColorGreen)
+				return nil
+			case tcell.KeyUp: // navigate the history.
+				commandLine.SetText(history.Previous(commandLine.GetText()))
+				return nil
+			case tcell.KeyDown:
+				commandLine.SetText(history.Next())
+				return nil
+			case tcell.KeyBackspace, tcell.KeyBackspace2:
+				if commandLine.GetText() == "" { // like vi, close the line.
+					closeCommandLine()
+					return nil
 				}
-			case 'q': // quit the program.
-				app.Stop()
 			}
 			return event
 		},
 	)
+	commandLine.SetDoneFunc(func(key tcell.Key) {
+		line := strings.TrimSpace(commandLine.GetText())
+		closeCommandLine()
+		if key != tcell.KeyEnter || line == "" {
+			return
+		}
+		if err := history.Add(historyLine(line)); err != nil {
+			status.SetText(fmt.Sprintf("history: %v", err))
+		}
+		if err := runCommand(line); err != nil {
+			status.SetText(fmt.Sprint(err))
+		}
+	})
+
+	// focus the switches or the logs view (if it is shown):
+	focus := func(logsFocused bool) {
+		if !showLogs {
+			return
+		}
+		switches.SetBorderColor(tcell.ColorWhite)
+		logs.SetBorderColor(tcell.ColorWhite)
+		if logsFocused {
+			logs.SetBorderColor(tcell.ColorGreen)
+			app.SetFocus(logs)
+		} else {
+			switches.SetBorderColor(tcell.ColorGreen)
+			app.SetFocus(switches)
+		}
+	}
+
+	// run a command of the open panel (see panelContent):
+	panelAction := func(name string) func(count int) {
+		return func(count int) {
+			if err := activePanel.command(name, util.Times(count)); err != nil {
+				status.SetText(fmt.Sprint(err))
+			}
+		}
+	}
+
+	// the named actions of the keymap:
+	keyActions = map[string]func(count int){
+		// global:
+		"quit":           func(count int) { app.Stop() },
+		"focus-switches": func(count int) { closePanel(); focus(false) },
+		"focus-logs":     func(count int) { closePanel(); focus(true) },
+		"command-line":   func(count int) { openCommandLine() },
+
+		// switches:
+		"down":   func(count int) { util.MoveSelection(switches, util.Times(count)) },
+		"up":     func(count int) { util.MoveSelection(switches, -util.Times(count)) },
+		"top":    func(count int) { util.SelectLine(switches, util.Times(count)) },
+		"bottom": func(count int) { util.SelectLine(switches, count) },
+		"collapse": func(count int) {
+			for i := 0; i < util.Times(count); i++ {
+				util.IntuitiveViBindings('h', switches)
+			}
+		},
+		"expand":       func(count int) { util.IntuitiveViBindings('l', switches) },
+		"collapse-all": func(count int) { util.IntuitiveViBindings('H', switches) },
+		"expand-all":   func(count int) { util.IntuitiveViBindings('L', switches) },
+		"toggle": func(count int) {
+			if err := toggle(switches.GetCurrentNode()); err != nil {
+				status.SetText(fmt.Sprint(err))
+			}
+		},
+		"info": func(count int) { // print information about current node.
+			selection := switches.GetCurrentNode()
+			if selection == nil {
+				status.SetText("no node selected")
+				return
+			}
+			t := "no parent found"
+			if parent := util.GetParent(selection, switches.GetRoot()); parent != nil {
+				t = "parent: " + parent.GetText()
+			}
+			t += "\ncurrent: " + selection.GetText()
+			status.SetText(t)
+		},
+		"refetch": func(count int) { // refetch all states from HA.
+			request("get_states", func(ctx context.Context) error {
+				_, err := client.GetStates(ctx)
+				return err
+			})
+		},
+		"search": func(count int) {
+			search = prompt{Active: true, Prefix: '/'}
+			searchOrigin = switches.GetCurrentNode()
+		},
+		"filter": func(count int) {
+			search = prompt{Active: true, Prefix: '&'}
+			searchOrigin = switches.GetCurrentNode()
+		},
+		"next-match":     func(count int) { jumpToMatch(false) },
+		"previous-match": func(count int) { jumpToMatch(true) },
+		"open-panel":     func(count int) { openPanel() },
+
+		// panel:
+		"next-control":     func(count int) { activePanel.selectControl(util.Times(count)) },
+		"previous-control": func(count int) { activePanel.selectControl(-util.Times(count)) },
+		"decrease":         func(count int) { activePanel.change(-float64(util.Times(count))) },
+		"increase":         func(count int) { activePanel.change(float64(util.Times(count))) },
+		"minimum":          func(count int) { activePanel.extreme(false) },
+		"maximum":          func(count int) { activePanel.extreme(true) },
+		"raise":            panelAction("raise"),
+		"lower":            panelAction("lower"),
+		"play-pause":       panelAction("play-pause"),
+		"next-track":       panelAction("next-track"),
+		"previous-track":   panelAction("previous-track"),
+		"seek-forward":     panelAction("seek-forward"),
+		"seek-backward":    panelAction("seek-backward"),
+		"open-cover":       panelAction("open-cover"),
+		"close-cover":      panelAction("close-cover"),
+		"stop-cover":       panelAction("stop-cover"),
+		"lock":             panelAction("lock"),
+		"unlock":           panelAction("unlock"),
+		"arm-home":         panelAction("arm-home"),
+		"arm-away":         panelAction("arm-away"),
+		"arm-night":        panelAction("arm-night"),
+		"arm-vacation":     panelAction("arm-vacation"),
+		"disarm":           panelAction("disarm"),
+		"close-panel":      func(count int) { closePanel() },
+
+		// logs:
+		"clear-logs": func(count int) { logs.SetText("") },
+		"write-logs": func(count int) {
+			if err := util.OverwriteFile("bhdr_log.json", logs.GetText(true)); err != nil {
+				status.SetText(fmt.Sprint(err))
+			}
+		},
+	}
 
 	// preselect node:
 	switches.SetCurrentNode(switchesRoot)
 
-	// connect to Home Assistant:
-	go homeassistant.Connect(haConfig, haEvents, haCommands)
+	// keep the inspector up to date. Updating it before every draw
+	// follows state changes, the clock (redrawn every second) and
+	// selections by the app. Selections by the tree view itself
+	// happen while drawing, they are followed by its changed func:
+	updateInspector := func() {
+		t := inspect(switches.GetCurrentNode(), client.Store(), time.Now())
+		if t != inspector.GetText(false) { // keep the scroll position.
+			inspector.SetText(t).ScrollToBeginning()
+		}
+	}
+	switches.SetChangedFunc(func(node *tview.TreeNode) { updateInspector() })
+	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		updateInspector()
+		if activePanel != nil { // its controls may change with the state.
+			panelLayout.ResizeItem(panelRow, activePanel.height(), 0)
+		}
+		return false
+	})
+
+	// relabels the group nodes, set below:
+	var updateGroups func()
 
-	// handle Home Assistant events:
+	// show the connection state, count down while retrying:
 	go func() {
-		// TODO clean up this entire function.
-		nodeFormat := "%s == %s"
+		var state homeassistant.ConnectionState
+		var retryAt time.Time
+		ticks := make(chan time.Time)
+		go util.AttachTicker(ticks, time.Second)
 
 		for {
-			m := homeassistant.Message{}
-			message := <-haEvents
-			json.Unmarshal([]byte(message), &m)
-
-			// handle event type messages:
-			if m.Event.Type == "state_changed" {
-				for _, node := range haEntities.GetChildren() {
-					r := node.GetReference().(homeassistant.Data)
-					if r.EntityID == m.Event.Data.EntityID {
-						node.SetText(
-							fmt.Sprintf(
-								nodeFormat,
-								r.NickName,
-								m.Event.Data.NewState.State,
-							),
-						)
-					}
+			select {
+			case state = <-client.States():
+				retryAt = time.Now().Add(state.Retry)
+
+				// (re)fetch the services for completing :call:
+				if state.Status == homeassistant.Online {
+					request("get_services", func(ctx context.Context) error {
+						fetched, err := client.GetServices(ctx)
+						if err != nil {
+							return err
+						}
+						app.QueueUpdate(func() {
+							services = fetched
+						})
+						return nil
+					})
 				}
-			}
 
-			// handle result type messages:
-			if len(m.Result) > 0 {
-				for _, node := range haEntities.GetChildren() {
-					r := node.GetReference().(homeassistant.Data)
-					for _, result := range m.Result {
-						if r.EntityID == result.EntityID {
-							node.SetText(
-								fmt.Sprintf(
-									nodeFormat,
-									r.NickName,
-									result.State,
-								),
-							)
+				// (re)fetch the registries for the areas layout:
+				if state.Status == homeassistant.Online && config.Layout == "areas" {
+					request("registry", func(ctx context.Context) error {
+						registry, err := client.Registry(ctx)
+						if err != nil {
+							return err
 						}
-					}
+						app.QueueUpdateDraw(func() {
+							entities.regroup(byArea(registry))
+							updateGroups()
+							if filterPattern != "" {
+								applyFilter(filterPattern)
+							}
+						})
+						return nil
+					})
+				}
+			case <-ticks:
+				app.QueueUpdateDraw(func() {}) // relative times of the inspector.
+				if state.Status != homeassistant.Retrying {
+					continue
 				}
+				state.Retry = time.Until(retryAt)
 			}
 
-			// update logs view:
-			if logs != nil {
-				current := logs.GetText(true)
-				if len(current) == 0 {
-					logs.SetText(message)
-				} else {
-					logs.SetText(current + ",\n" + message) // append message.
+			text := state.String()
+			color := tcell.ColorDarkOliveGreen
+			switch state.Status {
+			case homeassistant.Retrying:
+				color = tcell.ColorDarkGoldenrod
+			case homeassistant.Failed:
+				color = tcell.ColorDarkRed
+			}
+			app.QueueUpdateDraw(func() {
+				connection.SetText(text)
+				connection.SetBackgroundColor(color)
+			})
+		}
+	}()
+
+	// keep the entity nodes in sync with the store:
+	nodeFormat := "%s == %s"
+
+	// show how many members of each group are on:
+	updateGroups = func() {
+		haEntities.Walk(func(node, parent *tview.TreeNode) bool {
+			if group := nodeGroup(node); group != nil {
+				label := group.Name
+				on, off := switchable(groupMembers(node), client.Store())
+				if len(on)+len(off) > 0 {
+					label = fmt.Sprintf(
+						nodeFormat,
+						group.Name,
+						fmt.Sprintf("%v/%v on", len(on), len(on)+len(off)),
+					)
 				}
+				node.SetText(label)
+			}
+			return true
+		})
+	}
+	// changes are collected by entity id (the store's goroutine must
+	// not block) and applied in one batch, e.g. a Replace on reconnect:
+	var dirtyMutex sync.Mutex
+	dirty := map[string]bool{}
+	applyChanges := func() {
+		dirtyMutex.Lock()
+		changed := dirty
+		dirty = map[string]bool{}
+		dirtyMutex.Unlock()
+
+		for entityID := range changed {
+			state, ok := client.Store().Get(entityID)
+			change := homeassistant.Change{EntityID: entityID}
+			if ok {
+				change.New = state
+			}
+			entities.apply(change)
+			if activePanel != nil {
+				activePanel.stateChanged(entityID)
 			}
 
-			app.Draw() // external changes require a manual redraw.
+			node, ok := entities.nodes[entityID]
+			if !ok {
+				continue
+			}
+			entity := nodeEntity(node)
+			if change.Removed() {
+				node.SetText(entity.NickName)
+				continue
+			}
+			node.SetText(
+				fmt.Sprintf(
+					nodeFormat,
+					entity.NickName,
+					strings.TrimSpace(state.State+" "+state.Unit()),
+				),
+			)
 		}
-	}()
+		updateGroups()
+		if filterPattern != "" { // new entities may match.
+			applyFilter(filterPattern)
+		}
+	}
+	client.Store().Subscribe(func(change homeassistant.Change) {
+		dirtyMutex.Lock()
+		scheduled := len(dirty) > 0
+		dirty[change.EntityID] = true
+		dirtyMutex.Unlock()
+		if !scheduled {
+			go app.QueueUpdateDraw(applyChanges)
+		}
+	})
+
+	// update logs view with all messages from HA:
+	if logs != nil {
+		go func() {
+			for message := range client.Events() {
+				message := message
+				app.QueueUpdateDraw(func() {
+					current := logs.GetText(true)
+					if len(current) == 0 {
+						logs.SetText(message)
+					} else {
+						logs.SetText(current + ",\n" + message) // append message.
+					}
+				})
+			}
+		}()
+	} else {
+		go func() {
+			for range client.Events() { // nobody is interested.
+			}
+		}()
+	}
 
-	// fetch all states at startup.
-	haCommands <- homeassistant.Command{Type: "get_states"}
+	return app, client
+}
 
-	app.Run()
+// chordCall turns the action of a concluded chord into a service call.
+// Copy actions only fill a register and thus return no call.
+func chordCall(
+	chord util.KeyChord,
+	registers *homeassistant.Registers,
+	state homeassistant.State,
+) (*homeassistant.ServiceCall, error) {
+	register := chord.Register
+	if register == 0 {
+		register = homeassistant.UnnamedRegister
+	}
+
+	verb, attribute, _ := strings.Cut(chord.Action, ":")
+	switch verb {
+	case "copy":
+		return nil, registers.Copy(register, attribute, state)
+	case "paste":
+		call, err := registers.Paste(register, attribute, state)
+		return &call, err
+	}
+	call, err := homeassistant.ParseAction(chord.Action, state)
+	return &call, err
+}
+
+// needsConfirmation reports whether a call may reach a sensitive
+// entity: it targets one, or it targets areas, devices or all
+// entities (which are not resolved) in the domain of one (or with
+// a service of the homeassistant domain, which works for all).
+func needsConfirmation(call homeassistant.ServiceCall, sensitive map[string]bool) bool {
+	for _, entityID := range call.Target.EntityID {
+		if sensitive[entityID] {
+			return true
+		}
+	}
+	broad := len(call.Target.AreaID) > 0 || len(call.Target.DeviceID) > 0 ||
+		contains(call.Target.EntityID, "all")
+	if !broad {
+		return false
+	}
+	for entityID := range sensitive {
+		if call.Domain == "homeassistant" || homeassistant.Domain(entityID) == call.Domain {
+			return true
+		}
+	}
+	return false
+}
+
+// groupToggle switches all members of a group node: off
+// if any of them is on, else on. Only members that are on or
+// off are switched, the domain homeassistant works for all.
+func groupToggle(
+	node *tview.TreeNode,
+	store *homeassistant.Store,
+) (*homeassistant.ServiceCall, error) {
+	on, off := switchable(groupMembers(node), store)
+	call := &homeassistant.ServiceCall{Domain: "homeassistant"}
+	switch {
+	case len(on) > 0:
+		call.Service = "turn_off"
+		call.Target.EntityID = on
+	case len(off) > 0:
+		call.Service = "turn_on"
+		call.Target.EntityID = off
+	default:
+		return nil, fmt.Errorf("%v has no entities to toggle", nodeGroup(node).Name)
+	}
+	return call, nil
+}
+
+// nodeEntity returns the entity attached to a tree node (or nil).
+func nodeEntity(node *tview.TreeNode) *homeassistant.Entity {
+	if node == nil {
+		return nil
+	}
+	entity, _ := node.GetReference().(*homeassistant.Entity)
+	return entity
+}
+
+// nodeState returns the stored state of a node's entity. The zero
+// State is returned for other nodes and entities without state.
+func nodeState(node *tview.TreeNode, store *homeassistant.Store) homeassistant.State {
+	entity := nodeEntity(node)
+	if entity == nil {
+		return homeassistant.State{}
+	}
+	state, ok := store.Get(entity.EntityID)
+	if !ok {
+		state.EntityID = entity.EntityID
+	}
+	return state
 }
@@ -0,0 +1,724 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmedicke/bhdr/homeassistant"
+	"github.com/bmedicke/bhdr/homeassistant/hatest"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// newTestServer starts a fake HA with the entities of the template config.
+func newTestServer(t *testing.T) *hatest.Server {
+	server := hatest.NewServer(
+		"secret",
+		homeassistant.State{EntityID: "switch.tasmota_2", State: "off"},
+		homeassistant.State{EntityID: "switch.tasmota_edison", State: "on"},
+		homeassistant.State{
+			EntityID:   "light.hue_go_1",
+			State:      "on",
+			Attributes: map[string]interface{}{"brightness": 255.0},
+		},
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testTUI is a running TUI on a simulation screen.
+type testTUI struct {
+	app    *tview.Application
+	screen tcell.SimulationScreen
+}
+
+// startTUI runs the TUI with the template config on a simulation screen.
+func startTUI(t *testing.T, server *hatest.Server) *testTUI {
+	return startTUIWith(t, server, func(config *Config) {})
+}
+
+// startTUIWith runs the TUI with a modified template config.
+func startTUIWith(
+	t *testing.T,
+	server *hatest.Server,
+	modify func(config *Config),
+) *testTUI {
+	var config Config
+	if err := json.Unmarshal([]byte(bhdrJSON), &config); err != nil {
+		t.Fatal(err)
+	}
+	config.URL = server.URL
+	config.Token = server.Token
+	modify(&config)
+
+	app, client := newTUI(config, false)
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	screen.SetSize(100, 30)
+	app.SetScreen(screen)
+
+	go client.Run()
+	go app.Run()
+	t.Cleanup(app.Stop)
+
+	tui := &testTUI{app: app, screen: screen}
+	tui.waitFor(t, "online")
+	return tui
+}
+
+// text returns the rendered content of the screen, line by line.
+// It is read on the app's goroutine (QueueUpdate blocks until done),
+// as the screen is not thread-safe.
+func (tui *testTUI) text() string {
+	var text strings.Builder
+	tui.app.QueueUpdate(func() {
+		cells, width, _ := tui.screen.GetContents()
+		for i, cell := range cells {
+			if i > 0 && i%width == 0 {
+				text.WriteRune('\n')
+			}
+			if len(cell.Runes) > 0 {
+				text.WriteString(string(cell.Runes))
+			} else {
+				text.WriteRune(' ')
+			}
+		}
+	})
+	return text.String()
+}
+
+// waitFor fails the test if text is not rendered within two seconds.
+func (tui *testTUI) waitFor(t *testing.T, text string) {
+	t.Helper()
+	tui.waitUntil(t, "'"+text+"' was not rendered", func(screen string) bool {
+		return strings.Contains(screen, text)
+	})
+}
+
+// waitForGone fails the test if text is still rendered after two seconds.
+func (tui *testTUI) waitForGone(t *testing.T, text string) {
+	t.Helper()
+	tui.waitUntil(t, "'"+text+"' is still rendered", func(screen string) bool {
+		return !strings.Contains(screen, text)
+	})
+}
+
+// waitUntil fails the test if the screen does not satisfy done
+// within two seconds.
+func (tui *testTUI) waitUntil(
+	t *testing.T,
+	failure string,
+	done func(screen string) bool,
+) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if done(tui.text()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%v, screen:\n%v", failure, tui.text())
+}
+
+// pressKey sends a special key (e.g. tcell.KeyEnter) to the TUI.
+// Keys are queued on the app, the screen drops them if busy.
+func (tui *testTUI) pressKey(key tcell.Key) {
+	tui.app.QueueEvent(tcell.NewEventKey(key, 0, tcell.ModNone))
+}
+
+// typeKeys sends runes to the TUI.
+func (tui *testTUI) typeKeys(keys string) {
+	for _, key := range keys {
+		tui.app.QueueEvent(tcell.NewEventKey(tcell.KeyRune, key, tcell.ModNone))
+	}
+}
+
+func TestTUIRendersStates(t *testing.T) {
+	tui := startTUI(t, newTestServer(t))
+
+	tui.waitFor(t, "fan == off")
+	tui.waitFor(t, "edison == on")
+	tui.waitFor(t, "hue == on")
+}
+
+func TestTUIFollowsStateChanges(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "edison == on")
+
+	server.SetState(homeassistant.State{EntityID: "switch.tasmota_edison", State: "off"})
+	tui.waitFor(t, "edison == off")
+}
+
+func TestTUIToggle(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "fan == off")
+
+	tui.typeKeys("ji")
+	tui.waitFor(t, "current: fan == off")
+
+	tui.typeKeys(";")
+	tui.waitFor(t, "fan == on")
+}
+
+func TestTUIChordSetsBrightness(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "hue == on")
+
+	tui.typeKeys("jjji")
+	tui.waitFor(t, "current: hue == on")
+
+	tui.typeKeys("cb50")
+	tui.waitFor(t, "cb50")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "set:brightness:50")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		state, _ := server.State("light.hue_go_1")
+		if state.Attributes["brightness"] == 128.0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("brightness was not set, calls: %+v", server.Calls())
+}
+
+func TestTUIShowsServiceErrors(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "fan == off")
+
+	// the fake server knows no effects, so the chord fails locally
+	// (once it is concluded by the timeout):
+	tui.typeKeys("jjjce1")
+	tui.waitFor(t, "light.hue_go_1 has no effect number 1")
+}
+
+func TestTUICount(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "hue == on")
+	tui.waitFor(t, "edison == on") // states are labeled in any order.
+
+	// home-assistant is selected first:
+	tui.typeKeys("2ji")
+	tui.waitFor(t, "current: edison == on")
+
+	tui.typeKeys("2ki")
+	tui.waitFor(t, "current: home-assistant")
+
+	tui.typeKeys("4Gi")
+	tui.waitFor(t, "current: hue == on")
+
+	// a count stops at the first and last node:
+	tui.typeKeys("12ki")
+	tui.waitFor(t, "current: home-assistant")
+	tui.typeKeys("12ji")
+	tui.waitFor(t, "current: hue == on")
+}
+
+func TestTUIReconnects(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "edison == on")
+
+	server.DropConnections()
+	tui.waitFor(t, "retrying")
+
+	server.SetState(homeassistant.State{EntityID: "switch.tasmota_edison", State: "off"})
+	tui.waitFor(t, "online")
+	tui.waitFor(t, "edison == off")
+}
+
+func TestTUIDiscovery(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = nil
+		config.Discovery = DiscoveryConfig{
+			Enabled: true,
+			Exclude: []string{"switch.*_2"},
+		}
+	})
+
+	tui.waitFor(t, "light")
+	tui.waitFor(t, "hue_go_1 == on")
+	tui.waitFor(t, "tasmota_edison == on")
+	if strings.Contains(tui.text(), "tasmota_2") {
+		t.Errorf("excluded switch.tasmota_2 should not be rendered")
+	}
+
+	server.SetState(homeassistant.State{EntityID: "sensor.temperature", State: "21"})
+	tui.waitFor(t, "sensor")
+	tui.waitFor(t, "temperature == 21")
+}
+
+func TestTUIAreasLayout(t *testing.T) {
+	server := newTestServer(t)
+	server.SetRegistry(
+		[]homeassistant.Area{{AreaID: "office", Name: "Office"}},
+		[]homeassistant.Device{{ID: "d1", Name: "Hue Go", AreaID: "office"}},
+		[]homeassistant.RegistryEntry{
+			{EntityID: "light.hue_go_1", DeviceID: "d1"},
+			{EntityID: "switch.tasmota_2", AreaID: "office"},
+		},
+	)
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.Layout = "areas"
+	})
+
+	tui.waitFor(t, "Office")
+	tui.waitFor(t, "Hue Go")
+	tui.waitFor(t, "unassigned")
+	tui.waitFor(t, "hue == on")
+
+	// walk down to the light, collapse its device with h, then walk up:
+	tui.typeKeys("jjjji")
+	tui.waitFor(t, "parent: Hue Go")
+	tui.typeKeys("hhi")
+	tui.waitFor(t, "current: Hue Go")
+	tui.typeKeys("hi")
+	tui.waitFor(t, "current: Office")
+}
+
+func TestTUIGroups(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = []EntityConfig{
+			{Group: "upstairs", Entities: []EntityConfig{
+				{Group: "office", Entities: []EntityConfig{
+					{ID: "fan", EntityID: "switch.tasmota_2"},
+					{ID: "edison", EntityID: "switch.tasmota_edison"},
+				}},
+			}},
+			{ID: "hue", EntityID: "light.hue_go_1"},
+		}
+	})
+
+	tui.waitFor(t, "upstairs == 1/2 on")
+	tui.waitFor(t, "office == 1/2 on")
+
+	// toggling a group with a member that is on turns all off:
+	tui.typeKeys("jji")
+	tui.waitFor(t, "current: office")
+	tui.typeKeys(";")
+	tui.waitFor(t, "office == 0/2 on")
+	tui.waitFor(t, "edison == off")
+
+	// and then all on:
+	tui.typeKeys(";")
+	tui.waitFor(t, "upstairs == 2/2 on")
+	tui.waitFor(t, "fan == on")
+}
+
+func TestTUISearch(t *testing.T) {
+	tui := startTUI(t, newTestServer(t))
+	tui.waitFor(t, "hue == on")
+
+	tui.typeKeys("/edi")
+	tui.waitFor(t, "/edi")
+	tui.pressKey(tcell.KeyEnter)
+	tui.typeKeys("i")
+	tui.waitFor(t, "current: edison == on")
+
+	// n wraps around to the only match, N searches backwards:
+	tui.typeKeys("ni")
+	tui.waitFor(t, "current: edison == on")
+	tui.typeKeys("/tasmota")
+	tui.pressKey(tcell.KeyEnter)
+	tui.typeKeys("i")
+	tui.waitFor(t, "current: fan == off")
+	tui.typeKeys("ni")
+	tui.waitFor(t, "current: edison == on")
+	tui.typeKeys("Ni")
+	tui.waitFor(t, "current: fan == off")
+
+	// q is typed into the prompt instead of quitting:
+	tui.typeKeys("/q")
+	tui.waitFor(t, "pattern not found: q")
+	tui.pressKey(tcell.KeyEsc)
+	tui.typeKeys("i")
+	tui.waitFor(t, "current: fan == off")
+}
+
+func TestTUIFilter(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "fan == off")
+
+	tui.typeKeys("&edi")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitForGone(t, "fan")
+	tui.waitForGone(t, "hue")
+	tui.waitFor(t, "edison == on")
+
+	// the filtered tree follows state changes:
+	server.SetState(homeassistant.State{EntityID: "switch.tasmota_edison", State: "off"})
+	tui.waitFor(t, "edison == off")
+
+	tui.pressKey(tcell.KeyEsc)
+	tui.waitFor(t, "fan == off")
+	tui.waitFor(t, "hue == on")
+}
+
+func TestTUIFilterWithoutMatches(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "fan == off")
+
+	// nothing is selected, the tree keys must not fail:
+	tui.typeKeys("&nomatch")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitForGone(t, "fan")
+	tui.typeKeys("hli")
+	tui.waitFor(t, "no node selected")
+
+	// showing all nodes again selects the first one:
+	tui.pressKey(tcell.KeyEsc)
+	tui.waitFor(t, "fan == off")
+	tui.typeKeys("i")
+	tui.waitFor(t, "current: home-assistant")
+}
+
+func TestTUICommandLine(t *testing.T) {
+	server := newTestServer(t)
+	history := filepath.Join(t.TempDir(), "history")
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HistoryFile = history
+	})
+	tui.waitFor(t, "fan == off")
+
+	tui.typeKeys(":tog")
+	tui.pressKey(tcell.KeyTab)
+	tui.waitFor(t, ":toggle ")
+	tui.typeKeys("fan")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "fan == on")
+
+	tui.typeKeys(":call light.turn_on entity_id=light.hue_go_1 brightness_pct=50")
+	tui.pressKey(tcell.KeyEnter)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		state, _ := server.State("light.hue_go_1")
+		if state.Attributes["brightness"] == 128.0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state, _ := server.State("light.hue_go_1"); state.Attributes["brightness"] != 128.0 {
+		t.Fatalf("brightness was not set, calls: %+v", server.Calls())
+	}
+
+	// the history is navigated with up and down:
+	tui.typeKeys(":")
+	tui.pressKey(tcell.KeyUp)
+	tui.pressKey(tcell.KeyUp)
+	tui.waitFor(t, ":toggle fan")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "fan == off")
+
+	tui.typeKeys(":nope")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "not a command: nope")
+
+	saved, err := os.ReadFile(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "toggle fan\n" +
+		"call light.turn_on entity_id=light.hue_go_1 brightness_pct=50\n" +
+		"toggle fan\n" +
+		"nope\n"
+	if string(saved) != expected {
+		t.Errorf("history should be '%v', got '%v'", expected, string(saved))
+	}
+}
+
+func TestTUIKeymap(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.Keymap["switches"]["zt"] = "toggle"
+		config.Keymap["switches"]["zj"] = "down"
+		config.Keymap["global"]["<ctrl-x>"] = "command-line"
+	})
+	tui.waitFor(t, "fan == off")
+
+	tui.typeKeys("jzt")
+	tui.waitFor(t, "fan == on")
+
+	// a [count] is kept while the sequence is in progress:
+	tui.typeKeys("2zji")
+	tui.waitFor(t, "current: hue == on")
+
+	tui.app.QueueEvent(tcell.NewEventKey(tcell.KeyCtrlX, 0, tcell.ModCtrl))
+	tui.typeKeys("toggle edison")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "edison == off")
+}
+
+func TestTUIWhichKey(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "hue == on")
+
+	tui.typeKeys("jjj")
+	tui.typeKeys("c")
+	tui.waitFor(t, "which-key")
+	tui.waitFor(t, "b  set brightness to a number (percent)")
+	tui.waitFor(t, "c  toggle")
+
+	tui.typeKeys("b5")
+	tui.waitFor(t, "0-9  digit of the number")
+	tui.waitFor(t, "<enter>  set brightness to 5%")
+
+	// the popup disappears on completion:
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitForGone(t, "which-key")
+	tui.waitFor(t, "set:brightness:5")
+
+	// and on Esc, the switches view keeps the focus:
+	tui.typeKeys(`"a`)
+	tui.waitFor(t, "y  +copy")
+	tui.pressKey(tcell.KeyEsc)
+	tui.waitForGone(t, "which-key")
+	tui.typeKeys("ki")
+	tui.waitFor(t, "current: edison == on")
+}
+
+func TestTUIInspector(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "hue == on")
+
+	tui.typeKeys("jjj")
+	tui.waitFor(t, "light.hue_go_1")
+	tui.waitFor(t, "brightness: 255")
+
+	// state changes of the selected entity are shown live:
+	server.SetState(homeassistant.State{
+		EntityID:   "light.hue_go_1",
+		State:      "on",
+		Attributes: map[string]interface{}{"brightness": 128.0},
+	})
+	tui.waitFor(t, "brightness: 128")
+	tui.waitForGone(t, "brightness: 255")
+
+	// the inspector follows the selection:
+	tui.typeKeys("k")
+	tui.waitFor(t, "switch.tasmota_edison")
+	tui.waitForGone(t, "brightness: 128")
+}
+
+func TestTUILightPanel(t *testing.T) {
+	server := newTestServer(t)
+	tui := startTUI(t, server)
+	tui.waitFor(t, "hue == on")
+
+	tui.typeKeys("jjj")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "▸ brightness")
+	tui.waitFor(t, "100%")
+
+	// moving a slider quickly sends a single call:
+	tui.typeKeys("hh")
+	tui.waitFor(t, "90%")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		state, _ := server.State("light.hue_go_1")
+		if state.Attributes["brightness"] == 230.0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var calls []hatest.Call
+	for _, call := range server.Calls() {
+		if call.Domain == "light" {
+			calls = append(calls, call)
+		}
+	}
+	if len(calls) != 1 || calls[0].Data["brightness_pct"] != 90.0 {
+		t.Fatalf("one call should set the brightness to 90%%, calls: %+v", calls)
+	}
+
+	tui.pressKey(tcell.KeyEsc)
+	tui.waitForGone(t, "▸ brightness")
+}
+
+func TestTUIClimatePanel(t *testing.T) {
+	server := newTestServer(t)
+	server.SetState(homeassistant.State{
+		EntityID: "climate.office",
+		State:    "heat",
+		Attributes: map[string]interface{}{
+			"current_temperature": 19.5,
+			"temperature":         21.0,
+			"hvac_modes":          []interface{}{"off", "heat"},
+		},
+	})
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = append(config.HAEntities,
+			EntityConfig{ID: "office", EntityID: "climate.office"},
+		)
+	})
+	tui.waitFor(t, "office == heat")
+
+	tui.typeKeys("G")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "current: 19.5°")
+	tui.waitFor(t, "21°")
+
+	// a count nudges the setpoint further:
+	tui.typeKeys("3+")
+	tui.waitFor(t, "22.5°")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		state, _ := server.State("climate.office")
+		if state.Attributes["temperature"] == 22.5 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state, _ := server.State("climate.office"); state.Attributes["temperature"] != 22.5 {
+		t.Fatalf("setpoint was not raised, calls: %+v", server.Calls())
+	}
+
+	// modes are cycled:
+	tui.typeKeys("jl")
+	tui.waitFor(t, "office == off")
+}
+
+func TestTUIMediaPanel(t *testing.T) {
+	server := newTestServer(t)
+	server.SetState(homeassistant.State{
+		EntityID: "media_player.kitchen",
+		State:    "playing",
+		Attributes: map[string]interface{}{
+			"media_title":    "So What",
+			"media_duration": 562.0,
+			"volume_level":   0.5,
+		},
+	})
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = append(config.HAEntities,
+			EntityConfig{ID: "kitchen", EntityID: "media_player.kitchen"},
+		)
+	})
+	tui.waitFor(t, "kitchen == playing")
+
+	tui.typeKeys("G")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "title: So What")
+	tui.waitFor(t, "/ 9:22")
+
+	tui.typeKeys(" ")
+	tui.waitFor(t, "kitchen == paused")
+
+	tui.typeKeys(">")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		calls := server.Calls()
+		if len(calls) > 0 && calls[len(calls)-1].Service == "media_next_track" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("next track was not called, calls: %+v", server.Calls())
+}
+
+func TestTUISensitiveLock(t *testing.T) {
+	server := newTestServer(t)
+	server.SetState(homeassistant.State{
+		EntityID:   "lock.front_door",
+		State:      "locked",
+		Attributes: map[string]interface{}{"code_format": "^\\d{4}$"},
+	})
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = append(config.HAEntities,
+			EntityConfig{ID: "front", EntityID: "lock.front_door", Sensitive: true},
+		)
+	})
+	tui.waitFor(t, "front == locked")
+
+	// the code is asked for first, then the call is confirmed:
+	tui.typeKeys("G")
+	tui.waitFor(t, "lock.front_door")
+	tui.typeKeys(";")
+	tui.waitFor(t, "code: ")
+	tui.typeKeys("1234")
+	tui.waitFor(t, "code: ****")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "unlock lock.front_door?")
+	tui.typeKeys("n")
+	tui.waitFor(t, "unlock lock.front_door cancelled")
+
+	tui.typeKeys(";1234")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "unlock lock.front_door?")
+	tui.typeKeys("y")
+	tui.waitFor(t, "front == unlocked")
+	calls := server.Calls()
+	last := calls[len(calls)-1]
+	if last.Service != "unlock" || last.Data["code"] != "1234" {
+		t.Errorf("call should be unlock with code 1234, got '%+v'", last)
+	}
+	if len(calls) != 1 {
+		t.Errorf("the cancelled call should not be sent, got '%+v'", calls)
+	}
+}
+
+func TestTUISensitiveArea(t *testing.T) {
+	server := newTestServer(t)
+	server.SetState(homeassistant.State{EntityID: "lock.front_door", State: "locked"})
+	tui := startTUIWith(t, server, func(config *Config) {
+		config.HAEntities = append(config.HAEntities,
+			EntityConfig{ID: "front", EntityID: "lock.front_door", Sensitive: true},
+		)
+	})
+	tui.waitFor(t, "front == locked")
+
+	// the area may contain the sensitive lock:
+	tui.typeKeys(":call lock.unlock area_id=front")
+	tui.pressKey(tcell.KeyEnter)
+	tui.waitFor(t, "call lock.unlock area_id=front?")
+	tui.typeKeys("n")
+	tui.waitFor(t, "call lock.unlock area_id=front cancelled")
+	if calls := server.Calls(); len(calls) != 0 {
+		t.Errorf("the cancelled call should not be sent, got '%+v'", calls)
+	}
+}
+
+func TestNeedsConfirmation(t *testing.T) {
+	sensitive := map[string]bool{"lock.front_door": true}
+	calls := map[string]homeassistant.ServiceCall{
+		"entity": {Domain: "lock", Target: homeassistant.Target{
+			EntityID: []string{"lock.front_door"},
+		}},
+		"area":   {Domain: "lock", Target: homeassistant.Target{AreaID: []string{"hall"}}},
+		"device": {Domain: "homeassistant", Target: homeassistant.Target{DeviceID: []string{"d1"}}},
+		"all":    {Domain: "lock", Target: homeassistant.Target{EntityID: []string{"all"}}},
+	}
+	for name, call := range calls {
+		if !needsConfirmation(call, sensitive) {
+			t.Errorf("%v should need a confirmation", name)
+		}
+	}
+
+	other := []homeassistant.ServiceCall{
+		{Domain: "lock", Target: homeassistant.Target{EntityID: []string{"lock.back_door"}}},
+		{Domain: "light", Target: homeassistant.Target{AreaID: []string{"hall"}}},
+	}
+	for _, call := range other {
+		if needsConfirmation(call, sensitive) {
+			t.Errorf("'%+v' should not need a confirmation", call)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MaxHistory is the number of commands a History keeps.
+const MaxHistory = 1000
+
+// History stores the lines of a command line, like Vi's
+// command-line history it is navigated with Previous and Next.
+// If it has a file, every added line is saved there.
+type History struct {
+	file     string
+	saved    int // lines in the file.
+	entries  []string
+	position int    // index into entries, len(entries) while editing.
+	draft    string // the line typed before navigating.
+}
+
+// NewHistory loads a history file (which may not exist yet).
+// An empty file name keeps the history in memory.
+func NewHistory(file string) (*History, error) {
+	h := &History{file: file}
+	if file == "" {
+		return h, nil
+	}
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+		h.saved++
+	}
+	if len(h.entries) > MaxHistory {
+		h.entries = h.entries[len(h.entries)-MaxHistory:]
+	}
+	h.position = len(h.entries)
+	return h, scanner.Err()
+}
+
+// Entries returns all lines, oldest first.
+func (h *History) Entries() []string {
+	return append([]string(nil), h.entries...)
+}
+
+// Add appends a line (unless it repeats the last one),
+// saves it and resets the navigation. Once the file holds
+// twice MaxHistory lines it is rewritten with the kept ones.
+func (h *History) Add(line string) error {
+	h.position = len(h.entries)
+	h.draft = ""
+	if line == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == line) {
+		return nil
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > MaxHistory {
+		h.entries = h.entries[1:]
+	}
+	h.position = len(h.entries)
+
+	if h.file == "" {
+		return nil
+	}
+	if h.saved >= 2*MaxHistory {
+		h.saved = len(h.entries)
+		return os.WriteFile(h.file, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h.saved++
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+// Previous returns the line before the current one.
+// current is what has been typed so far, it is returned
+// by Next once the navigation is back at the end.
+func (h *History) Previous(current string) string {
+	if h.position == len(h.entries) {
+		h.draft = current
+	}
+	if len(h.entries) == 0 {
+		return current
+	}
+	if h.position == 0 {
+		return h.entries[0]
+	}
+	h.position--
+	return h.entries[h.position]
+}
+
+// Next returns the line after the current one.
+func (h *History) Next() string {
+	if h.position >= len(h.entries)-1 {
+		h.position = len(h.entries)
+		return h.draft
+	}
+	h.position++
+	return h.entries[h.position]
+}
+
+// Complete returns the candidates that start with word (sorted, once)
+// and their longest common prefix (word if nothing matches).
+func Complete(word string, candidates []string) (string, []string) {
+	var matches []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) && !seen[candidate] {
+			matches = append(matches, candidate)
+			seen[candidate] = true
+		}
+	}
+	if len(matches) == 0 {
+		return word, nil
+	}
+	sort.Strings(matches)
+
+	// the first and last match differ the most:
+	first, last := []rune(matches[0]), []rune(matches[len(matches)-1])
+	i := 0
+	for i < len(first) && i < len(last) && first[i] == last[i] {
+		i++
+	}
+	return string(first[:i]), matches
+}
+
+// SplitCommand splits a command line into words. Double quotes
+// group words (e.g. effect="Color Loop"), the quotes around a word
+// or the value of key=value are removed, others are kept (e.g. in
+// data={"a":"b c"}).
+func SplitCommand(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+			word.WriteRune(r)
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, unquote(word.String()))
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, unquote(word.String()))
+	}
+	return words, nil
+}
+
+// unquote removes the outer quotes of a word (or of its value).
+func unquote(word string) string {
+	prefix, value := "", word
+	if key, rest, found := strings.Cut(word, "="); found && !strings.HasPrefix(word, `"`) {
+		prefix, value = key+"=", rest
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	return prefix + value
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistoryNavigation(t *testing.T) {
+	history, _ := NewHistory("")
+	history.Add("q")
+	history.Add("toggle fan")
+	history.Add("toggle fan") // repeated lines are stored once.
+
+	if got := history.Previous("tog"); got != "toggle fan" {
+		t.Errorf("Previous should be 'toggle fan', got '%v'", got)
+	}
+	if got := history.Previous(""); got != "q" {
+		t.Errorf("Previous should be 'q', got '%v'", got)
+	}
+	if got := history.Previous(""); got != "q" {
+		t.Errorf("Previous should stay at 'q', got '%v'", got)
+	}
+	if got := history.Next(); got != "toggle fan" {
+		t.Errorf("Next should be 'toggle fan', got '%v'", got)
+	}
+	if got := history.Next(); got != "tog" {
+		t.Errorf("Next should return the draft 'tog', got '%v'", got)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	history, err := NewHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.Add("reload")
+	history.Add("w logs.json")
+
+	content, _ := os.ReadFile(file)
+	if string(content) != "reload\nw logs.json\n" {
+		t.Errorf("file should be 'reload\\nw logs.json\\n', got '%q'", content)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("file mode should be '-rw-------', got '%v'", info.Mode().Perm())
+	}
+
+	history, err = NewHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"reload", "w logs.json"}
+	if !reflect.DeepEqual(history.Entries(), expected) {
+		t.Errorf("Entries should be '%v', got '%v'", expected, history.Entries())
+	}
+	if got := history.Previous(""); got != "w logs.json" {
+		t.Errorf("Previous should be 'w logs.json', got '%v'", got)
+	}
+}
+
+func TestHistoryFileLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	history, _ := NewHistory(file)
+	for i := 0; i < 2*MaxHistory+1; i++ {
+		history.Add(fmt.Sprint(i))
+	}
+
+	history, err := NewHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(file)
+	if lines := strings.Count(string(content), "\n"); lines > 2*MaxHistory {
+		t.Errorf("file should have at most %v lines, got '%v'", 2*MaxHistory, lines)
+	}
+	entries := history.Entries()
+	if len(entries) != MaxHistory || entries[len(entries)-1] != fmt.Sprint(2*MaxHistory) {
+		t.Errorf("the last %v lines should be kept, got '%v'", MaxHistory, len(entries))
+	}
+}
+
+func TestComplete(t *testing.T) {
+	candidates := []string{"light.turn_on", "light.turn_off", "light.toggle", "switch.toggle"}
+
+	prefix, matches := Complete("light.t", candidates)
+	if prefix != "light.t" || len(matches) != 3 {
+		t.Errorf("Complete should be 'light.t' with 3 matches, got '%v' '%v'", prefix, matches)
+	}
+
+	prefix, matches = Complete("light.tu", candidates)
+	if prefix != "light.turn_o" || len(matches) != 2 {
+		t.Errorf("Complete should be 'light.turn_o' with 2 matches, got '%v' '%v'", prefix, matches)
+	}
+
+	prefix, _ = Complete("sw", candidates)
+	if prefix != "switch.toggle" {
+		t.Errorf("Complete should be 'switch.toggle', got '%v'", prefix)
+	}
+
+	prefix, matches = Complete("x", candidates)
+	if prefix != "x" || matches != nil {
+		t.Errorf("Complete should be 'x' without matches, got '%v' '%v'", prefix, matches)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	words, err := SplitCommand(`call  light.turn_on effect="Color Loop" brightness_pct=40`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"call", "light.turn_on", "effect=Color Loop", "brightness_pct=40"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("words should be '%v', got '%v'", expected, words)
+	}
+
+	words, _ = SplitCommand(`call "a b" data={"a":"b c"} name=x"y"`)
+	expected = []string{"call", "a b", `data={"a":"b c"}`, `name=x"y"`}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("words should be '%v', got '%v'", expected, words)
+	}
+
+	if _, err := SplitCommand(`call "light`); err == nil {
+		t.Errorf("unterminated quotes should be an error")
+	}
+}
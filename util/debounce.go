@@ -0,0 +1,51 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer delays functions until they stop coming for a while,
+// e.g. service calls while a slider is moved. Functions are grouped
+// by key, only the last one of a group runs (on its own goroutine).
+type Debouncer struct {
+	delay  time.Duration
+	mutex  sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// NewDebouncer creates a Debouncer that waits for delay.
+func NewDebouncer(delay time.Duration) *Debouncer {
+	return &Debouncer{delay: delay, timers: map[string]*time.Timer{}}
+}
+
+// Call runs f after the delay, unless another function
+// of the same key comes first (f is then dropped).
+func (d *Debouncer) Call(key string, f func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if timer, ok := d.timers[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d.delay, func() {
+		d.mutex.Lock()
+		if d.timers[key] != timer { // replaced after it fired.
+			d.mutex.Unlock()
+			return
+		}
+		delete(d.timers, key)
+		d.mutex.Unlock()
+		f()
+	})
+	d.timers[key] = timer
+}
+
+// Pending reports whether a function of key waits to run.
+func (d *Debouncer) Pending(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, ok := d.timers[key]
+	return ok
+}
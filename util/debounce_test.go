@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	debouncer := NewDebouncer(20 * time.Millisecond)
+	calls := make(chan string, 10)
+
+	debouncer.Call("brightness", func() { calls <- "brightness 10" })
+	debouncer.Call("brightness", func() { calls <- "brightness 20" })
+	debouncer.Call("hue", func() { calls <- "hue 5" })
+	if !debouncer.Pending("brightness") {
+		t.Errorf("brightness should be pending")
+	}
+
+	got := map[string]bool{<-calls: true, <-calls: true}
+	for _, expected := range []string{"brightness 20", "hue 5"} {
+		if !got[expected] {
+			t.Errorf("'%v' should have run, got '%v'", expected, got)
+		}
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("replaced functions should not run, got '%v'", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if debouncer.Pending("brightness") {
+		t.Errorf("brightness should not be pending after it ran")
+	}
+}
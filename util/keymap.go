@@ -0,0 +1,360 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// GlobalScope is the scope of bindings that work in every view.
+const GlobalScope = "global"
+
+// KeySpec is a single key of a key sequence:
+// * Key: tcell.KeyRune for printable keys, else the special key
+// * Rune: the printable key (0 for special keys)
+// * Mod: tcell.ModAlt or tcell.ModNone (ctrl is part of Key)
+type KeySpec struct {
+	Key  tcell.Key
+	Rune rune
+	Mod  tcell.ModMask
+}
+
+// specialKeys are the names of keys written in angle brackets.
+var specialKeys = map[string]tcell.Key{
+	"enter":     tcell.KeyEnter,
+	"esc":       tcell.KeyEsc,
+	"tab":       tcell.KeyTab,
+	"backtab":   tcell.KeyBacktab,
+	"backspace": tcell.KeyBackspace2,
+	"delete":    tcell.KeyDelete,
+	"insert":    tcell.KeyInsert,
+	"up":        tcell.KeyUp,
+	"down":      tcell.KeyDown,
+	"left":      tcell.KeyLeft,
+	"right":     tcell.KeyRight,
+	"home":      tcell.KeyHome,
+	"end":       tcell.KeyEnd,
+	"pgup":      tcell.KeyPgUp,
+	"pgdn":      tcell.KeyPgDn,
+	"f1":        tcell.KeyF1,
+	"f2":        tcell.KeyF2,
+	"f3":        tcell.KeyF3,
+	"f4":        tcell.KeyF4,
+	"f5":        tcell.KeyF5,
+	"f6":        tcell.KeyF6,
+	"f7":        tcell.KeyF7,
+	"f8":        tcell.KeyF8,
+	"f9":        tcell.KeyF9,
+	"f10":       tcell.KeyF10,
+	"f11":       tcell.KeyF11,
+	"f12":       tcell.KeyF12,
+}
+
+// specialRunes are printable keys that need a name.
+var specialRunes = map[string]rune{
+	"space": ' ',
+	"lt":    '<',
+}
+
+// ParseKeys parses a key sequence written like in Vi mappings:
+// * printable keys stand for themselves (gg)
+// * other keys are named in angle brackets (<enter>, <space>, <lt>)
+// * modifiers prefix a name (<ctrl-w>, <alt-x>, <alt-enter>)
+func ParseKeys(keys string) ([]KeySpec, error) {
+	var specs []KeySpec
+	runes := []rune(keys)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '<' {
+			specs = append(specs, KeySpec{Key: tcell.KeyRune, Rune: runes[i]})
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && runes[end] != '>' {
+			end++
+		}
+		if end == len(runes) {
+			return nil, fmt.Errorf("unterminated key [%v]", string(runes[i:]))
+		}
+		spec, err := parseKeyName(string(runes[i+1 : end]))
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+		i = end
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+	return specs, nil
+}
+
+// parseKeyName parses the name of a key in angle brackets.
+func parseKeyName(name string) (KeySpec, error) {
+	spec := KeySpec{}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "alt-"):
+		spec.Mod = tcell.ModAlt
+		name, lower = name[4:], lower[4:]
+	case strings.HasPrefix(lower, "ctrl-"):
+		letter := []rune(lower[5:])
+		if len(letter) != 1 || letter[0] < 'a' || letter[0] > 'z' {
+			return spec, fmt.Errorf("invalid key [<%v>] (ctrl only works with letters)", name)
+		}
+		spec.Key = tcell.KeyCtrlA + tcell.Key(letter[0]-'a')
+		return spec, nil
+	}
+
+	if key, ok := specialKeys[lower]; ok {
+		spec.Key = key
+		return spec, nil
+	}
+	if r, ok := specialRunes[lower]; ok {
+		spec.Key, spec.Rune = tcell.KeyRune, r
+		return spec, nil
+	}
+	if runes := []rune(name); len(runes) == 1 && spec.Mod == tcell.ModAlt {
+		spec.Key, spec.Rune = tcell.KeyRune, runes[0]
+		return spec, nil
+	}
+	return spec, fmt.Errorf("invalid key [<%v>]", name)
+}
+
+// NewKeySpec returns the key of a key event. Ctrl and shift are
+// part of the key or rune, so only alt is kept as modifier.
+func NewKeySpec(event *tcell.EventKey) KeySpec {
+	spec := KeySpec{Key: event.Key(), Mod: event.Modifiers() & tcell.ModAlt}
+	if spec.Key == tcell.KeyRune {
+		spec.Rune = event.Rune()
+	}
+	return spec
+}
+
+// String writes a key the way ParseKeys reads it.
+func (k KeySpec) String() string {
+	var name string
+	switch {
+	case k.Key == tcell.KeyRune:
+		name = string(k.Rune)
+		for n, r := range specialRunes {
+			if r == k.Rune {
+				name = "<" + n + ">"
+			}
+		}
+	case k.Key >= tcell.KeyCtrlA && k.Key <= tcell.KeyCtrlZ &&
+		k.Key != tcell.KeyTab && k.Key != tcell.KeyEnter:
+		return fmt.Sprintf("<ctrl-%c>", 'a'+rune(k.Key-tcell.KeyCtrlA))
+	default:
+		name = fmt.Sprintf("<%v>", k.Key)
+		for n, key := range specialKeys {
+			if key == k.Key {
+				name = "<" + n + ">"
+			}
+		}
+	}
+	if k.Mod&tcell.ModAlt != 0 {
+		return "<alt-" + strings.Trim(name, "<>") + ">"
+	}
+	return name
+}
+
+// FormatKeys writes a key sequence the way ParseKeys reads it.
+func FormatKeys(specs []KeySpec) string {
+	var text strings.Builder
+	for _, spec := range specs {
+		text.WriteString(spec.String())
+	}
+	return text.String()
+}
+
+// keyNode is a node of the trie of a scope, a sequence
+// is bound if the node of its last key has an action.
+type keyNode struct {
+	action   string
+	children map[KeySpec]*keyNode
+}
+
+// Keymap maps key sequences to named actions, by scope (e.g. a view).
+// Bindings of the GlobalScope work in every scope, bindings of
+// a scope override global ones of the same sequence.
+type Keymap struct {
+	scopes map[string]*keyNode
+}
+
+// NewKeymap creates an empty keymap.
+func NewKeymap() *Keymap {
+	return &Keymap{scopes: map[string]*keyNode{}}
+}
+
+// Bind maps a key sequence (see ParseKeys) to an action. It returns
+// an error if the sequence cannot be reached because of another one:
+// * the same sequence is bound to another action in the scope
+// * one of them starts with the other (in the scope or globally)
+func (m *Keymap) Bind(scope, keys, action string) error {
+	specs, err := ParseKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	// check the scope and the scopes it shares keys with:
+	others := []string{GlobalScope}
+	if scope == GlobalScope {
+		others = nil
+		for other := range m.scopes {
+			if other != GlobalScope {
+				others = append(others, other)
+			}
+		}
+		sort.Strings(others)
+	}
+	if err := m.conflict(scope, specs, action, true); err != nil {
+		return err
+	}
+	for _, other := range others {
+		if err := m.conflict(other, specs, action, false); err != nil {
+			return err
+		}
+	}
+
+	node, ok := m.scopes[scope]
+	if !ok {
+		node = &keyNode{}
+		m.scopes[scope] = node
+	}
+	for _, spec := range specs {
+		child, ok := node.children[spec]
+		if !ok {
+			child = &keyNode{}
+			if node.children == nil {
+				node.children = map[KeySpec]*keyNode{}
+			}
+			node.children[spec] = child
+		}
+		node = child
+	}
+	node.action = action
+	return nil
+}
+
+// conflict checks a new sequence against the bindings of a scope.
+// Sequences of other scopes may be the same (they override).
+func (m *Keymap) conflict(
+	scope string,
+	specs []KeySpec,
+	action string,
+	sameScope bool,
+) error {
+	node := m.scopes[scope]
+	keys := FormatKeys(specs)
+	for i, spec := range specs {
+		if node == nil {
+			return nil
+		}
+		node = node.children[spec]
+		if node == nil {
+			return nil
+		}
+		if node.action != "" && i < len(specs)-1 {
+			return fmt.Errorf(
+				"[%v] conflicts with [%v] of %v", keys, FormatKeys(specs[:i+1]), scope,
+			)
+		}
+	}
+	if len(node.children) > 0 {
+		return fmt.Errorf(
+			"[%v] conflicts with [%v] of %v", keys, keys+longer(node), scope,
+		)
+	}
+	if sameScope && node.action != "" && node.action != action {
+		return fmt.Errorf("[%v] is already bound to %v", keys, node.action)
+	}
+	return nil
+}
+
+// longer returns the keys of a bound sequence below node
+// (the first one in order, for stable messages).
+func longer(node *keyNode) string {
+	var keys []string
+	for spec := range node.children {
+		keys = append(keys, spec.String())
+	}
+	sort.Strings(keys)
+	for spec, child := range node.children {
+		if spec.String() != keys[0] {
+			continue
+		}
+		if child.action != "" {
+			return keys[0]
+		}
+		return keys[0] + longer(child)
+	}
+	return ""
+}
+
+// Keys returns the sequences bound to an action in a scope (sorted).
+func (m *Keymap) Keys(scope, action string) []string {
+	var keys []string
+	var walk func(node *keyNode, prefix string)
+	walk = func(node *keyNode, prefix string) {
+		if node.action == action && prefix != "" {
+			keys = append(keys, prefix)
+		}
+		for spec, child := range node.children {
+			walk(child, prefix+spec.String())
+		}
+	}
+	if node, ok := m.scopes[scope]; ok {
+		walk(node, "")
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// KeySequence holds the keys of a sequence in progress.
+type KeySequence struct {
+	Pending []KeySpec
+}
+
+// String shows the pending keys (e.g. in a statusbar).
+func (s KeySequence) String() string {
+	return FormatKeys(s.Pending)
+}
+
+// Handle adds a key to a sequence and looks it up in the scopes
+// (the first one wins). It returns the action of a complete
+// sequence and whether the key was consumed: false means the
+// key is not bound (a pending sequence is then dropped).
+func (m *Keymap) Handle(
+	sequence *KeySequence,
+	scopes []string,
+	key KeySpec,
+) (string, bool) {
+	keys := append(append([]KeySpec(nil), sequence.Pending...), key)
+	pending := false
+	for _, scope := range scopes {
+		node := m.scopes[scope]
+		for _, spec := range keys {
+			if node == nil {
+				break
+			}
+			node = node.children[spec]
+		}
+		if node == nil {
+			continue
+		}
+		if node.action != "" {
+			sequence.Pending = nil
+			return node.action, true
+		}
+		pending = true
+	}
+
+	if pending {
+		sequence.Pending = keys
+		return "", true
+	}
+	sequence.Pending = nil
+	return "", false
+}
@@ -0,0 +1,183 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := map[string][]KeySpec{
+		"gg": {
+			{Key: tcell.KeyRune, Rune: 'g'},
+			{Key: tcell.KeyRune, Rune: 'g'},
+		},
+		"<ctrl-w>l": {
+			{Key: tcell.KeyCtrlW},
+			{Key: tcell.KeyRune, Rune: 'l'},
+		},
+		"<alt-x><alt-enter>": {
+			{Key: tcell.KeyRune, Rune: 'x', Mod: tcell.ModAlt},
+			{Key: tcell.KeyEnter, Mod: tcell.ModAlt},
+		},
+		"<Space><lt><F5>": {
+			{Key: tcell.KeyRune, Rune: ' '},
+			{Key: tcell.KeyRune, Rune: '<'},
+			{Key: tcell.KeyF5},
+		},
+	}
+	for keys, expected := range tests {
+		specs, err := ParseKeys(keys)
+		if err != nil {
+			t.Errorf("%v: got unexpected error: '%v'", keys, err)
+			continue
+		}
+		if !reflect.DeepEqual(specs, expected) {
+			t.Errorf("%v: keys should be '%+v', got '%+v'", keys, expected, specs)
+		}
+	}
+}
+
+func TestParseKeysErrors(t *testing.T) {
+	tests := map[string]string{
+		"":          "empty key sequence",
+		"g<enter":   "unterminated key [<enter]",
+		"<foo>":     "invalid key [<foo>]",
+		"<ctrl-up>": "invalid key [<ctrl-up>] (ctrl only works with letters)",
+		"<alt-foo>": "invalid key [<foo>]",
+	}
+	for keys, expected := range tests {
+		if _, err := ParseKeys(keys); err == nil || err.Error() != expected {
+			t.Errorf("%v: expected error: '%v', got '%v'", keys, expected, err)
+		}
+	}
+}
+
+func TestKeySpecString(t *testing.T) {
+	for _, keys := range []string{
+		"gg", "<ctrl-w>l", "<alt-x><alt-enter>", "<space><lt><f5>", "<esc><tab><backspace>",
+	} {
+		specs, err := ParseKeys(keys)
+		if err != nil {
+			t.Fatalf("%v: got unexpected error: '%v'", keys, err)
+		}
+		if text := FormatKeys(specs); text != keys {
+			t.Errorf("keys should be '%v', got '%v'", keys, text)
+		}
+	}
+}
+
+func TestNewKeySpec(t *testing.T) {
+	tests := []struct {
+		event *tcell.EventKey
+		keys  string
+	}{
+		{tcell.NewEventKey(tcell.KeyRune, 'G', tcell.ModShift), "G"},
+		{tcell.NewEventKey(tcell.KeyCtrlF, 0, tcell.ModCtrl), "<ctrl-f>"},
+		{tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), "<alt-x>"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "<enter>"},
+		{tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone), "<space>"},
+	}
+	for _, test := range tests {
+		specs, _ := ParseKeys(test.keys)
+		if spec := NewKeySpec(test.event); spec != specs[0] {
+			t.Errorf("%v: key should be '%+v', got '%+v'", test.keys, specs[0], spec)
+		}
+	}
+}
+
+func TestKeymapConflicts(t *testing.T) {
+	keymap := NewKeymap()
+	bindings := []struct {
+		scope, keys, action, err string
+	}{
+		{GlobalScope, "q", "quit", ""},
+		{GlobalScope, "gq", "quit", ""},
+		{GlobalScope, "q", "quit", ""}, // the same binding again.
+		{GlobalScope, "q", "info", "[q] is already bound to quit"},
+		{GlobalScope, "g", "top", "[g] conflicts with [gq] of global"},
+		{GlobalScope, "gqx", "top", "[gqx] conflicts with [gq] of global"},
+		{"switches", "q", "info", ""}, // overrides the global q.
+		{"switches", "g", "top", "[g] conflicts with [gq] of global"},
+		{"switches", "<ctrl-w>l", "right", ""},
+		{"switches", "<ctrl-w>", "window", "[<ctrl-w>] conflicts with [<ctrl-w>l] of switches"},
+		{GlobalScope, "<ctrl-w>", "window", "[<ctrl-w>] conflicts with [<ctrl-w>l] of switches"},
+		{"logs", "<ctrl-w>", "window", ""}, // scopes other than global are separate.
+	}
+	for _, b := range bindings {
+		err := keymap.Bind(b.scope, b.keys, b.action)
+		if (err == nil && b.err != "") || (err != nil && err.Error() != b.err) {
+			t.Errorf("%v %v: expected error: '%v', got '%v'", b.scope, b.keys, b.err, err)
+		}
+	}
+}
+
+func TestKeymapHandle(t *testing.T) {
+	keymap := NewKeymap()
+	keymap.Bind(GlobalScope, "q", "quit")
+	keymap.Bind(GlobalScope, "<ctrl-w>w", "next-view")
+	keymap.Bind("switches", "q", "info")
+	keymap.Bind("switches", "gg", "top")
+
+	var sequence KeySequence
+	handle := func(scope string, keys string) (string, bool) {
+		specs, _ := ParseKeys(keys)
+		var action string
+		var consumed bool
+		for _, spec := range specs {
+			action, consumed = keymap.Handle(&sequence, []string{scope, GlobalScope}, spec)
+		}
+		return action, consumed
+	}
+
+	tests := []struct {
+		scope, keys, action string
+		consumed            bool
+		pending             string
+	}{
+		{"switches", "q", "info", true, ""}, // the scope wins.
+		{"logs", "q", "quit", true, ""},
+		{"switches", "g", "", true, "g"},
+		{"switches", "g", "top", true, ""},
+		{"switches", "<ctrl-w>w", "next-view", true, ""},
+		{"logs", "gg", "", false, ""}, // only bound in switches.
+		{"switches", "gx", "", false, ""},
+		{"switches", "x", "", false, ""},
+	}
+	for _, test := range tests {
+		action, consumed := handle(test.scope, test.keys)
+		if action != test.action || consumed != test.consumed {
+			t.Errorf(
+				"%v %v: should be '%v' %v, got '%v' %v",
+				test.scope,
+				test.keys,
+				test.action,
+				test.consumed,
+				action,
+				consumed,
+			)
+		}
+		if sequence.String() != test.pending {
+			t.Errorf("%v %v: pending keys should be '%v', got '%v'", test.scope, test.keys, test.pending, sequence)
+		}
+	}
+}
+
+func TestKeymapKeys(t *testing.T) {
+	keymap := NewKeymap()
+	keymap.Bind("panel", "q", "close-panel")
+	keymap.Bind("panel", "<esc>", "close-panel")
+	keymap.Bind("panel", "ah", "arm-home")
+	keymap.Bind(GlobalScope, "q", "quit")
+
+	if got := keymap.Keys("panel", "close-panel"); !reflect.DeepEqual(got, []string{"<esc>", "q"}) {
+		t.Errorf("keys should be '[<esc> q]', got '%v'", got)
+	}
+	if got := keymap.Keys("panel", "arm-home"); !reflect.DeepEqual(got, []string{"ah"}) {
+		t.Errorf("keys should be '[ah]', got '%v'", got)
+	}
+	if got := keymap.Keys("panel", "quit"); got != nil {
+		t.Errorf("keys of other scopes should not be returned, got '%v'", got)
+	}
+}
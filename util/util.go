@@ -4,9 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
 
@@ -40,8 +44,12 @@ func GetParent(
 // L: recursively expand all nodes.
 // h: collapse OR move up (if already collapsed or not folder)
 // l: expand node without switching.
+// Nothing happens if no node is selected (e.g. filtered out).
 func IntuitiveViBindings(rune int32, view *tview.TreeView) {
 	selection := view.GetCurrentNode()
+	if selection == nil {
+		return
+	}
 
 	switch rune {
 	case 'H':
@@ -58,10 +66,10 @@ func IntuitiveViBindings(rune int32, view *tview.TreeView) {
 	case 'L':
 		view.GetRoot().ExpandAll()
 	case 'h':
-		if selection.IsExpanded() && nil != selection.GetChildren() {
+		if selection.IsExpanded() && len(selection.GetChildren()) > 0 {
 			selection.Collapse()
-		} else if selection.GetLevel() > 1 {
-			parent := GetParent(selection, view.GetRoot())
+		} else if parent := GetParent(selection, view.GetRoot()); parent != nil &&
+			parent != view.GetRoot() { // the root node is hidden.
 			view.SetCurrentNode(parent)
 		}
 	case 'l':
@@ -101,36 +109,81 @@ func CreateFileIfNotExist(file string, content string) error {
 	return nil
 }
 
+// ChordTimeout is how long a chord waits for the next digit
+// of its value before it is concluded (like Vi's timeoutlen).
+const ChordTimeout = time.Second
+
+// MaxCount is the largest [count] prefix.
+const MaxCount = 9999
+
 // KeyChord stores the following Vi grammar values:
 // * Active: true if a chord is currently in progress
 // * Buffer: holds the key sequence
 // * Action: result from successful chord conclusion
+// * Register: register named by a "x prefix (0 if none was named)
+// * Count: [count] prefix of a motion (0 if none was typed)
+// * LastKey: when the last key of the chord was handled
 type KeyChord struct {
-	Active bool
-	Buffer string
-	Action string
+	Active   bool
+	Buffer   string
+	Action   string
+	Register rune
+	Count    int
+	LastKey  time.Time
 }
 
 // HandleChords emulates Vi-based key chords.
 // It updates a Chord struct based on a keyrune and
 // a JSON mapping (from chords to actions).
-// The rules are based on Vi grammar:
+// The rules are based on Vi grammar (keys are runes, so
+// umlauts and symbols work as well):
 // * a single letter nomen,
 // * followed by a single letter verb,
-// * followed by an optional digit (marked by a # in JSON).
+// * followed by an optional number (marked by a # in JSON).
+// A number can have several digits, it is concluded by
+// ConcludeChord (on Enter or after the ChordTimeout).
+// Like in Vi a chord can be prefixed by "x to name a register.
+// The register survives the chord's conclusion and has
+// to be cleared by the caller once the action is handled.
 func HandleChords(
 	keyrune rune,
 	chord *KeyChord,
-	chordmap map[string]interface{},
+	chordmap map[string]map[string]string,
 ) error {
-	key := string(keyrune)
-	(*chord).Buffer += key
+	key := keyName(keyrune)
+	(*chord).LastKey = time.Now()
+	if !utf8.ValidRune(keyrune) {
+		ResetChord(chord)
+		return fmt.Errorf("invalid key [%v]", key)
+	}
+
+	// handle the register prefix:
+	if (*chord).Buffer == `"` {
+		if !unicode.IsLetter(keyrune) &&
+			!unicode.IsDigit(keyrune) &&
+			keyrune != '"' {
+			ResetChord(chord)
+			return fmt.Errorf("invalid register [%v]", key)
+		}
+		(*chord).Register = keyrune
+		(*chord).Buffer = ""
+		return nil
+	}
+	if (*chord).Buffer == "" && keyrune == '"' {
+		(*chord).Buffer = `"`
+		(*chord).Active = true
+		return nil
+	}
+
+	register := (*chord).Register
+	(*chord).Buffer += string(keyrune)
 	(*chord).Active = false
-	chordLength := len((*chord).Buffer)
+	buffer := []rune((*chord).Buffer)
+	chordLength := len(buffer)
 
 	// handle the verb:
 	if chordLength > 0 {
-		nomenmap := chordmap[string((*chord).Buffer[0])]
+		nomenmap := chordmap[string(buffer[0])]
 		if nomenmap == nil {
 			ResetChord(chord)
 			return fmt.Errorf("invalid nomen [%v]", key)
@@ -139,50 +192,247 @@ func HandleChords(
 	}
 
 	// handle the nomen:
-	if chordLength > 1 {
-		nomenmap := chordmap[string((*chord).Buffer[0])]
-		verb := nomenmap.(map[string]interface{})[string((*chord).Buffer[1])]
+	if chordLength == 2 {
+		nomenmap := chordmap[string(buffer[0])]
+		verb, ok := nomenmap[string(buffer[1])]
 
-		if verb == nil {
+		if !ok {
 			ResetChord(chord)
 			return fmt.Errorf("invalid verb [%v]", key)
 		}
 
-		if strings.HasSuffix(verb.(string), "#") {
-			(*chord).Active = true
-			(*chord).Action = verb.(string)
-		} else {
+		if !strings.HasSuffix(verb, "#") {
 			ResetChord(chord)
-			(*chord).Action = verb.(string)
+			(*chord).Action = verb
+			(*chord).Register = register
 		}
 	}
 
-	// handle the postfix:
-	if chordLength > 2 {
-		postfix := string((*chord).Buffer[2])
+	// handle the digits of the number (only 0-9, other
+	// scripts' digits are not understood by HA):
+	if chordLength > 2 && (keyrune < '0' || keyrune > '9') {
+		ResetChord(chord)
+		return fmt.Errorf("invalid value [%v]", key)
+	}
+	return nil
+}
 
-		if strings.ContainsAny(postfix, "0123456789") {
-			(*chord).Action += postfix
-		} else {
-			ResetChord(chord)
-			return fmt.Errorf("invalid value [%v]", postfix)
-		}
-		(*chord).Buffer = ""
-		(*chord).Active = false
+// keyName shows a key in messages, unprintable ones by code point.
+func keyName(keyrune rune) string {
+	if utf8.ValidRune(keyrune) && unicode.IsPrint(keyrune) {
+		return string(keyrune)
+	}
+	return fmt.Sprintf("%U", keyrune)
+}
+
+// ConcludeChord ends the number of a chord (e.g. cb50 becomes
+// set:brightness:50). Chords without number are left as they are.
+func ConcludeChord(
+	chord *KeyChord,
+	chordmap map[string]map[string]string,
+) error {
+	buffer := []rune((*chord).Buffer)
+	if !(*chord).Active || len(buffer) < 2 {
+		return nil
+	}
+	verb := chordmap[string(buffer[0])][string(buffer[1])]
+	if !strings.HasSuffix(verb, "#") {
+		return nil
 	}
 
-	if strings.HasSuffix((*chord).Action, "#") {
-		(*chord).Action = ""
+	register := (*chord).Register
+	ResetChord(chord)
+	if len(buffer) == 2 {
+		return fmt.Errorf("missing value")
 	}
+	(*chord).Action = strings.TrimSuffix(verb, "#") + string(buffer[2:])
+	(*chord).Register = register
 	return nil
 }
 
+// ChordTimedOut reports whether the number of a chord has
+// not been continued for the ChordTimeout (at time now).
+func ChordTimedOut(chord *KeyChord, now time.Time) bool {
+	return (*chord).Active &&
+		len([]rune((*chord).Buffer)) > 2 &&
+		now.Sub((*chord).LastKey) >= ChordTimeout
+}
+
+// ChordHint is a key that continues a chord and what it does.
+type ChordHint struct {
+	Key         string
+	Description string
+}
+
+// ChordHints lists the keys that continue an active chord (like
+// Vim's which-key), describe explains an action of the chordmap:
+// * after ": the register names
+// * before the nomen: every nomen with the verbs of its actions
+// * after the nomen: every verb with its action
+// * in a number: the digits and Enter (with the action so far)
+func ChordHints(
+	chord KeyChord,
+	chordmap map[string]map[string]string,
+	describe func(action string) string,
+) []ChordHint {
+	if !chord.Active {
+		return nil
+	}
+	buffer := []rune(chord.Buffer)
+	keyName := func(key string) string {
+		for _, r := range key {
+			return KeySpec{Key: tcell.KeyRune, Rune: r}.String()
+		}
+		return key
+	}
+
+	var hints []ChordHint
+	switch {
+	case chord.Buffer == `"`:
+		hints = append(hints, ChordHint{`a-z 0-9 "`, "name a register"})
+	case len(buffer) == 0:
+		for _, nomen := range sortedKeys(chordmap) {
+			var verbs []string
+			for _, verb := range sortedKeys(chordmap[nomen]) {
+				name, _, _ := strings.Cut(chordmap[nomen][verb], ":")
+				if !containsString(verbs, name) {
+					verbs = append(verbs, name)
+				}
+			}
+			hints = append(hints, ChordHint{keyName(nomen), "+" + strings.Join(verbs, ", ")})
+		}
+	case len(buffer) == 1:
+		verbs := chordmap[string(buffer[0])]
+		for _, verb := range sortedKeys(verbs) {
+			hints = append(hints, ChordHint{keyName(verb), describe(verbs[verb])})
+		}
+	default:
+		hints = append(hints, ChordHint{"0-9", "digit of the number"})
+		if len(buffer) > 2 {
+			action := chordmap[string(buffer[0])][string(buffer[1])]
+			action = strings.TrimSuffix(action, "#") + string(buffer[2:])
+			hints = append(hints, ChordHint{"<enter>", describe(action)})
+		}
+	}
+	return hints
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsString reports whether a slice contains value.
+func containsString(slice []string, value string) bool {
+	for _, element := range slice {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleCount adds a digit to the [count] prefix of a motion
+// (e.g. 5j). It reports whether keyrune belongs to the count,
+// a leading 0 does not (like in Vi).
+func HandleCount(keyrune rune, chord *KeyChord) bool {
+	if (*chord).Active || keyrune < '0' || keyrune > '9' ||
+		(keyrune == '0' && (*chord).Count == 0) {
+		return false
+	}
+	(*chord).Count = (*chord).Count*10 + int(keyrune-'0')
+	if (*chord).Count > MaxCount {
+		(*chord).Count = MaxCount
+	}
+	return true
+}
+
+// Times is the number of repetitions of a [count] prefix
+// (1 if none was typed, i.e. count is 0).
+func Times(count int) int {
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
 // ResetChord disables .Active and clears the
-// .Action and .Buffer strings.
+// .Action and .Buffer strings as well as the .Register
+// and .Count.
 func ResetChord(chord *KeyChord) {
 	(*chord).Active = false
 	(*chord).Action = ""
 	(*chord).Buffer = ""
+	(*chord).Register = 0
+	(*chord).Count = 0
+}
+
+// MoveSelection moves the selection of a TreeView by offset
+// visible nodes (down if positive), stopping at the first
+// and last node.
+func MoveSelection(view *tview.TreeView, offset int) {
+	nodes := visibleNodes(view.GetRoot())
+	if len(nodes) == 0 {
+		return
+	}
+	current := -1 // e.g. the root, before the first node.
+	for i, node := range nodes {
+		if node == view.GetCurrentNode() {
+			current = i
+		}
+	}
+	selectIndex(view, nodes, current+offset)
+}
+
+// SelectLine selects the nth visible node of a TreeView
+// (counting from 1, like Vi's [count]G), 0 selects the last.
+func SelectLine(view *tview.TreeView, line int) {
+	nodes := visibleNodes(view.GetRoot())
+	if len(nodes) == 0 {
+		return
+	}
+	if line == 0 {
+		line = len(nodes)
+	}
+	selectIndex(view, nodes, line-1)
+}
+
+// selectIndex selects nodes[i], i is clamped to the nodes.
+func selectIndex(view *tview.TreeView, nodes []*tview.TreeNode, i int) {
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(nodes) {
+		i = len(nodes) - 1
+	}
+	view.SetCurrentNode(nodes[i])
+}
+
+// visibleNodes returns the nodes below root in the order they
+// are displayed (children of collapsed nodes are hidden).
+// The root itself is left out as it is not selectable.
+func visibleNodes(root *tview.TreeNode) []*tview.TreeNode {
+	var nodes []*tview.TreeNode
+	var visit func(node *tview.TreeNode)
+	visit = func(node *tview.TreeNode) {
+		nodes = append(nodes, node)
+		if node.IsExpanded() {
+			for _, child := range node.GetChildren() {
+				visit(child)
+			}
+		}
+	}
+	if root != nil {
+		for _, child := range root.GetChildren() {
+			visit(child)
+		}
+	}
+	return nodes
 }
 
 // AttachTicker adds a ticker to a time.Time channel and
@@ -3,7 +3,13 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
 )
 
 func TestResetChord(t *testing.T) {
@@ -28,7 +34,7 @@ func TestResetChord(t *testing.T) {
 func TestHandleChordsInvalidNomen(t *testing.T) {
 	keyrune := 'x'
 	chord := KeyChord{}
-	var chordmap map[string]interface{}
+	var chordmap map[string]map[string]string
 	testJSON := `{"c": {"c": "toggle:power"}}`
 	json.Unmarshal([]byte(testJSON), &chordmap)
 
@@ -69,7 +75,7 @@ func TestHandleChordsInvalidNomen(t *testing.T) {
 func TestHandleChordsValidNomenVerb(t *testing.T) {
 	keyrune := 'c'
 	chord := KeyChord{}
-	var chordmap map[string]interface{}
+	var chordmap map[string]map[string]string
 	testJSON := `{"c": {"c": "toggle:power"}}`
 	json.Unmarshal([]byte(testJSON), &chordmap)
 
@@ -144,7 +150,7 @@ func TestHandleChordsValidNomenInvalidVerb(t *testing.T) {
 	keyrune1 := 'c'
 	keyrune2 := 'x'
 	chord := KeyChord{}
-	var chordmap map[string]interface{}
+	var chordmap map[string]map[string]string
 	testJSON := `{"c": {"c": "toggle:power"}}`
 	json.Unmarshal([]byte(testJSON), &chordmap)
 
@@ -191,7 +197,7 @@ func TestHandleChordsValidNomenVerbPost(t *testing.T) {
 	keyrune2 := 'b'
 	keyrune3 := '5'
 	chord := KeyChord{}
-	var chordmap map[string]interface{}
+	var chordmap map[string]map[string]string
 	testJSON := `{"c": {"b": "set:brightness:#"}}`
 	json.Unmarshal([]byte(testJSON), &chordmap)
 
@@ -207,7 +213,8 @@ func TestHandleChordsValidNomenVerbPost(t *testing.T) {
 		t.Errorf("got unexpected error: '%v'", err)
 	}
 
-	expectedAction := "set:brightness:#5"
+	// the number is not concluded yet:
+	expectedAction := ""
 	if chord.Action != expectedAction {
 		t.Errorf(
 			"chord.Action should be '%v', got '%v'",
@@ -216,7 +223,7 @@ func TestHandleChordsValidNomenVerbPost(t *testing.T) {
 		)
 	}
 
-	expectedActive := false
+	expectedActive := true
 	if chord.Active != expectedActive {
 		t.Errorf(
 			"chord.Active should be '%v', got '%v'",
@@ -225,7 +232,7 @@ func TestHandleChordsValidNomenVerbPost(t *testing.T) {
 		)
 	}
 
-	expectedBuffer := ""
+	expectedBuffer := "cb5"
 	if chord.Buffer != expectedBuffer {
 		t.Errorf(
 			"chord.Buffer should be '%v', got '%v'",
@@ -233,6 +240,169 @@ func TestHandleChordsValidNomenVerbPost(t *testing.T) {
 			chord.Buffer,
 		)
 	}
+
+	// conclude the number:
+	err = ConcludeChord(&chord, chordmap)
+	if err != nil {
+		t.Errorf("got unexpected error: '%v'", err)
+	}
+
+	expectedAction = "set:brightness:5"
+	if chord.Action != expectedAction {
+		t.Errorf(
+			"chord.Action should be '%v', got '%v'",
+			expectedAction,
+			chord.Action,
+		)
+	}
+
+	if chord.Active || chord.Buffer != "" {
+		t.Errorf("chord should be concluded, got '%+v'", chord)
+	}
+}
+
+func TestHandleChordsMultipleDigits(t *testing.T) {
+	var chordmap map[string]map[string]string
+	testJSON := `{"c": {"b": "set:brightness:#", "c": "toggle:power"}}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+
+	tests := map[string]string{
+		"cb50":   "set:brightness:50",
+		"cb100":  "set:brightness:100",
+		"cb007":  "set:brightness:007",
+		`"acb42`: "set:brightness:42",
+	}
+	for keys, expectedAction := range tests {
+		chord := KeyChord{}
+		for _, keyrune := range keys {
+			if err := HandleChords(keyrune, &chord, chordmap); err != nil {
+				t.Fatalf("%v: got unexpected error: '%v'", keys, err)
+			}
+		}
+		if err := ConcludeChord(&chord, chordmap); err != nil {
+			t.Fatalf("%v: got unexpected error: '%v'", keys, err)
+		}
+		if chord.Action != expectedAction {
+			t.Errorf(
+				"%v: chord.Action should be '%v', got '%v'",
+				keys,
+				expectedAction,
+				chord.Action,
+			)
+		}
+	}
+
+	// the register survives the conclusion:
+	chord := KeyChord{}
+	for _, keyrune := range `"acb4` {
+		HandleChords(keyrune, &chord, chordmap)
+	}
+	ConcludeChord(&chord, chordmap)
+	if chord.Register != 'a' {
+		t.Errorf("chord.Register should be 'a', got '%c'", chord.Register)
+	}
+
+	// chords without number are not changed:
+	chord = KeyChord{}
+	HandleChords('c', &chord, chordmap)
+	if err := ConcludeChord(&chord, chordmap); err != nil || !chord.Active || chord.Buffer != "c" {
+		t.Errorf("chord should be unchanged, got '%+v' (%v)", chord, err)
+	}
+}
+
+func TestConcludeChordMissingValue(t *testing.T) {
+	chord := KeyChord{}
+	var chordmap map[string]map[string]string
+	testJSON := `{"c": {"b": "set:brightness:#"}}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+
+	HandleChords('c', &chord, chordmap)
+	HandleChords('b', &chord, chordmap)
+	err := ConcludeChord(&chord, chordmap)
+	expectedError := fmt.Errorf("missing value")
+	if err == nil || err.Error() != expectedError.Error() {
+		t.Errorf("expected error: '%v', got '%v'", expectedError, err)
+	}
+	if chord.Active || chord.Action != "" || chord.Buffer != "" {
+		t.Errorf("chord should be reset, got '%+v'", chord)
+	}
+}
+
+func TestChordTimedOut(t *testing.T) {
+	chord := KeyChord{}
+	var chordmap map[string]map[string]string
+	testJSON := `{"c": {"b": "set:brightness:#"}}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+
+	// only numbers time out:
+	HandleChords('c', &chord, chordmap)
+	HandleChords('b', &chord, chordmap)
+	if ChordTimedOut(&chord, chord.LastKey.Add(ChordTimeout)) {
+		t.Errorf("chord without digits should not time out")
+	}
+
+	HandleChords('5', &chord, chordmap)
+	if ChordTimedOut(&chord, chord.LastKey.Add(ChordTimeout/2)) {
+		t.Errorf("chord should not time out before ChordTimeout")
+	}
+	if !ChordTimedOut(&chord, chord.LastKey.Add(ChordTimeout)) {
+		t.Errorf("chord should time out after ChordTimeout")
+	}
+
+	ResetChord(&chord)
+	if ChordTimedOut(&chord, time.Now().Add(ChordTimeout)) {
+		t.Errorf("inactive chord should not time out")
+	}
+}
+
+func TestHandleCount(t *testing.T) {
+	chord := KeyChord{}
+
+	// like in Vi, a leading 0 is not a count:
+	if HandleCount('0', &chord) {
+		t.Errorf("leading 0 should not be part of the count")
+	}
+	for _, keyrune := range "120" {
+		if !HandleCount(keyrune, &chord) {
+			t.Errorf("%c should be part of the count", keyrune)
+		}
+	}
+	if HandleCount('j', &chord) {
+		t.Errorf("j should not be part of the count")
+	}
+
+	expectedCount := 120
+	if chord.Count != expectedCount {
+		t.Errorf("chord.Count should be '%v', got '%v'", expectedCount, chord.Count)
+	}
+	if times := Times(chord.Count); times != expectedCount {
+		t.Errorf("times should be '%v', got '%v'", expectedCount, times)
+	}
+	if times := Times(0); times != 1 {
+		t.Errorf("times without prefix should be '1', got '%v'", times)
+	}
+	chord.Count = 0
+
+	// counts are capped:
+	for _, keyrune := range "123456789" {
+		HandleCount(keyrune, &chord)
+	}
+	if chord.Count != MaxCount {
+		t.Errorf("chord.Count should be '%v', got '%v'", MaxCount, chord.Count)
+	}
+
+	// digits of an active chord are its number:
+	chord = KeyChord{Active: true, Buffer: "cb"}
+	if HandleCount('5', &chord) {
+		t.Errorf("digits of an active chord should not be a count")
+	}
+
+	ResetChord(&chord)
+	HandleCount('3', &chord)
+	ResetChord(&chord)
+	if chord.Count != 0 {
+		t.Errorf("chord.Count should be reset, got '%v'", chord.Count)
+	}
 }
 
 func TestHandleChordsValidNomenVerbInvalidPost(t *testing.T) {
@@ -240,7 +410,7 @@ func TestHandleChordsValidNomenVerbInvalidPost(t *testing.T) {
 	keyrune2 := 'b'
 	keyrune3 := 'x'
 	chord := KeyChord{}
-	var chordmap map[string]interface{}
+	var chordmap map[string]map[string]string
 	testJSON := `{"c": {"b": "set:brightness:#"}}`
 	json.Unmarshal([]byte(testJSON), &chordmap)
 
@@ -284,3 +454,339 @@ func TestHandleChordsValidNomenVerbInvalidPost(t *testing.T) {
 		)
 	}
 }
+
+func TestHandleChordsRegister(t *testing.T) {
+	chord := KeyChord{}
+	var chordmap map[string]map[string]string
+	testJSON := `{"y": {"h": "copy:hue"}}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+
+	for _, keyrune := range `"ayh` {
+		if err := HandleChords(keyrune, &chord, chordmap); err != nil {
+			t.Fatalf("got unexpected error: '%v'", err)
+		}
+	}
+
+	expectedAction := "copy:hue"
+	if chord.Action != expectedAction {
+		t.Errorf(
+			"chord.Action should be '%v', got '%v'",
+			expectedAction,
+			chord.Action,
+		)
+	}
+
+	expectedRegister := 'a'
+	if chord.Register != expectedRegister {
+		t.Errorf(
+			"chord.Register should be '%c', got '%c'",
+			expectedRegister,
+			chord.Register,
+		)
+	}
+
+	expectedActive := false
+	if chord.Active != expectedActive {
+		t.Errorf(
+			"chord.Active should be '%v', got '%v'",
+			expectedActive,
+			chord.Active,
+		)
+	}
+}
+
+func TestHandleChordsInvalidRegister(t *testing.T) {
+	chord := KeyChord{}
+	var chordmap map[string]map[string]string
+	testJSON := `{"y": {"h": "copy:hue"}}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+
+	HandleChords('"', &chord, chordmap)
+	err := HandleChords('%', &chord, chordmap)
+	expectedError := fmt.Errorf("invalid register [%%]")
+	if err == nil || err.Error() != expectedError.Error() {
+		t.Errorf("expected error: '%v', got '%v'", expectedError, err)
+	}
+
+	expectedRegister := rune(0)
+	if chord.Register != expectedRegister || chord.Active || chord.Buffer != "" {
+		t.Errorf("chord should be reset, got '%+v'", chord)
+	}
+}
+
+// unicodeChordmap uses keys of a German keyboard and symbols.
+const unicodeChordmap = `{
+	"ä": {"ö": "toggle:power", "ü": "set:brightness:#", "c": "copy:all"},
+	"€": {"ß": "turn_off:power"},
+	"c": {"c": "toggle:power", "b": "set:brightness:#"}
+}`
+
+func TestHandleChordsUnicode(t *testing.T) {
+	var chordmap map[string]map[string]string
+	json.Unmarshal([]byte(unicodeChordmap), &chordmap)
+
+	tests := []struct {
+		keys     string
+		action   string
+		register rune
+		err      string
+	}{
+		{"äö", "toggle:power", 0, ""},
+		{"€ß", "turn_off:power", 0, ""},
+		{"äü42", "set:brightness:42", 0, ""},
+		{`"öäc`, "copy:all", 'ö', ""},
+		{"ä€", "", 0, "invalid verb [€]"},
+		{"ö", "", 0, "invalid nomen [ö]"},
+		{"äü٣", "", 0, "invalid value [٣]"}, // an Arabic-Indic digit.
+		{"c\x00", "", 0, "invalid verb [U+0000]"},
+		{`"€`, "", 0, "invalid register [€]"},
+		{"c\ufffd", "", 0, "invalid verb [\ufffd]"},
+	}
+	for _, test := range tests {
+		chord := KeyChord{}
+		var err error
+		for _, keyrune := range test.keys {
+			if err = HandleChords(keyrune, &chord, chordmap); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = ConcludeChord(&chord, chordmap)
+		}
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%v: expected error: '%v', got '%v'", test.keys, test.err, err)
+		}
+		if chord.Action != test.action || chord.Register != test.register {
+			t.Errorf(
+				"%v: action should be '%v' (register '%c'), got '%v' ('%c')",
+				test.keys,
+				test.action,
+				test.register,
+				chord.Action,
+				chord.Register,
+			)
+		}
+	}
+
+	// runes that are no valid code points are rejected:
+	chord := KeyChord{}
+	HandleChords('c', &chord, chordmap)
+	err := HandleChords(-1, &chord, chordmap)
+	if err == nil || err.Error() != "invalid key [U+FFFFFFFFFFFFFFFF]" || chord.Active {
+		t.Errorf("invalid rune should reset the chord, got '%v' '%+v'", err, chord)
+	}
+}
+
+func FuzzHandleChords(f *testing.F) {
+	var chordmap map[string]map[string]string
+	json.Unmarshal([]byte(unicodeChordmap), &chordmap)
+
+	for _, seed := range []string{
+		"cc", "cb50", `"acc`, "äö", "äü42", "€ß", `""`, "ä€", "c\x00b", "\xff\xfe",
+	} {
+		f.Add(seed, int32(0))
+	}
+	f.Add("cb", int32(-1))
+	f.Add("ä", int32(0x10FFFF+1))
+
+	f.Fuzz(func(t *testing.T, keys string, last int32) {
+		chord := KeyChord{}
+		runes := append([]rune(keys), last)
+		for _, keyrune := range runes {
+			HandleChords(keyrune, &chord, chordmap)
+			HandleCount(keyrune, &chord)
+			checkChord(t, chord, chordmap)
+			if chord.Action != "" {
+				chord.Action = ""
+				chord.Register = 0
+			}
+		}
+		ConcludeChord(&chord, chordmap)
+		checkChord(t, chord, chordmap)
+		ChordTimedOut(&chord, chord.LastKey.Add(ChordTimeout))
+	})
+}
+
+// checkChord fails if a chord is in a state HandleChords cannot reach.
+func checkChord(t *testing.T, chord KeyChord, chordmap map[string]map[string]string) {
+	t.Helper()
+	if !utf8.ValidString(chord.Buffer) {
+		t.Fatalf("chord.Buffer should be valid UTF-8, got '%q'", chord.Buffer)
+	}
+	buffer := []rune(chord.Buffer)
+	if len(buffer) > 0 && !chord.Active {
+		t.Fatalf("chord with buffer '%v' should be active", chord.Buffer)
+	}
+	if len(buffer) > 0 && buffer[0] != '"' && chordmap[string(buffer[0])] == nil {
+		t.Fatalf("chord.Buffer should start with a nomen, got '%v'", chord.Buffer)
+	}
+	for i, r := range buffer {
+		if i >= 2 && (r < '0' || r > '9') {
+			t.Fatalf("chord.Buffer should end in digits, got '%v'", chord.Buffer)
+		}
+	}
+	if chord.Action == "" {
+		return
+	}
+
+	// actions are verbs of the chordmap, numbers replace the #:
+	for _, verbs := range chordmap {
+		for _, verb := range verbs {
+			if chord.Action == verb {
+				return
+			}
+			prefix := strings.TrimSuffix(verb, "#")
+			number := strings.TrimPrefix(chord.Action, prefix)
+			if strings.HasSuffix(verb, "#") && strings.HasPrefix(chord.Action, prefix) &&
+				number != "" && strings.Trim(number, "0123456789") == "" {
+				return
+			}
+		}
+	}
+	t.Fatalf("chord.Action should be from the chordmap, got '%v'", chord.Action)
+}
+
+func TestChordHints(t *testing.T) {
+	var chordmap map[string]map[string]string
+	testJSON := `{
+		"c": {"c": "toggle:power", "b": "set:brightness:#"},
+		"y": {"y": "copy:all", " ": "copy:hue"}
+	}`
+	json.Unmarshal([]byte(testJSON), &chordmap)
+	describe := func(action string) string { return "<" + action + ">" }
+
+	tests := map[string][]ChordHint{
+		"": nil,
+		`"`: {
+			{`a-z 0-9 "`, "name a register"},
+		},
+		`"a`: {
+			{"c", "+set, toggle"},
+			{"y", "+copy"},
+		},
+		"c": {
+			{"b", "<set:brightness:#>"},
+			{"c", "<toggle:power>"},
+		},
+		"y": {
+			{"<space>", "<copy:hue>"},
+			{"y", "<copy:all>"},
+		},
+		"cb": {
+			{"0-9", "digit of the number"},
+		},
+		"cb42": {
+			{"0-9", "digit of the number"},
+			{"<enter>", "<set:brightness:42>"},
+		},
+		"cc": nil, // concluded.
+	}
+	for keys, expected := range tests {
+		chord := KeyChord{}
+		for _, keyrune := range keys {
+			HandleChords(keyrune, &chord, chordmap)
+		}
+		hints := ChordHints(chord, chordmap, describe)
+		if !reflect.DeepEqual(hints, expected) {
+			t.Errorf("%v: hints should be '%v', got '%v'", keys, expected, hints)
+		}
+	}
+}
+
+func TestMoveSelection(t *testing.T) {
+	// root → a (→ a1, a2), b:
+	root := tview.NewTreeNode(".")
+	a := tview.NewTreeNode("a")
+	a1 := tview.NewTreeNode("a1")
+	a2 := tview.NewTreeNode("a2")
+	b := tview.NewTreeNode("b")
+	root.AddChild(a)
+	root.AddChild(b)
+	a.AddChild(a1)
+	a.AddChild(a2)
+
+	view := tview.NewTreeView()
+	view.SetRoot(root)
+	view.SetCurrentNode(root)
+
+	tests := []struct {
+		move     func()
+		expected *tview.TreeNode
+	}{
+		{func() { MoveSelection(view, 1) }, a}, // the root is not selectable.
+		{func() { MoveSelection(view, 2) }, a2},
+		{func() { MoveSelection(view, 5) }, b},
+		{func() { MoveSelection(view, -2) }, a1},
+		{func() { MoveSelection(view, -5) }, a},
+		{func() { SelectLine(view, 4) }, b},
+		{func() { SelectLine(view, 2) }, a1},
+		{func() { SelectLine(view, 9) }, b},
+		{func() { a.Collapse(); SelectLine(view, 2) }, b}, // children are hidden.
+		{func() { MoveSelection(view, -1) }, a},
+	}
+	for i, test := range tests {
+		test.move()
+		if view.GetCurrentNode() != test.expected {
+			t.Errorf(
+				"move %v: current node should be '%v', got '%v'",
+				i,
+				test.expected.GetText(),
+				view.GetCurrentNode().GetText(),
+			)
+		}
+	}
+}
+
+func TestIntuitiveViBindingsNestedGroups(t *testing.T) {
+	// root → home-assistant → area → device → entity:
+	root := tview.NewTreeNode(".")
+	ha := tview.NewTreeNode("home-assistant")
+	area := tview.NewTreeNode("area")
+	device := tview.NewTreeNode("device")
+	entity := tview.NewTreeNode("entity")
+	root.AddChild(ha)
+	ha.AddChild(area)
+	area.AddChild(device)
+	device.AddChild(entity)
+
+	view := tview.NewTreeView()
+	view.SetRoot(root)
+	view.SetTopLevel(1)
+	view.SetCurrentNode(entity)
+
+	// h moves up from leaves and collapses expanded groups:
+	IntuitiveViBindings('h', view)
+	if view.GetCurrentNode() != device {
+		t.Errorf("current node should be 'device', got '%v'", view.GetCurrentNode().GetText())
+	}
+	IntuitiveViBindings('h', view)
+	if device.IsExpanded() {
+		t.Errorf("device should be collapsed")
+	}
+	IntuitiveViBindings('h', view)
+	if view.GetCurrentNode() != area {
+		t.Errorf("current node should be 'area', got '%v'", view.GetCurrentNode().GetText())
+	}
+
+	// H collapses every level, L expands them again:
+	IntuitiveViBindings('H', view)
+	for _, node := range []*tview.TreeNode{ha, area, device, entity} {
+		if node.IsExpanded() {
+			t.Errorf("%v should be collapsed", node.GetText())
+		}
+	}
+	IntuitiveViBindings('L', view)
+	for _, node := range []*tview.TreeNode{ha, area, device, entity} {
+		if !node.IsExpanded() {
+			t.Errorf("%v should be expanded", node.GetText())
+		}
+	}
+
+	// l expands a single group:
+	view.SetCurrentNode(device)
+	device.Collapse()
+	IntuitiveViBindings('l', view)
+	if !device.IsExpanded() {
+		t.Errorf("device should be expanded")
+	}
+}